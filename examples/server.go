@@ -15,16 +15,23 @@ func main() {
 	server, err := runtime.NewGateway(
 		runtime.WithServer("0.0.0.0", 8081),
 		runtime.WithBackend("0.0.0.0", 8080),
+		runtime.WithBackendInsecure(),
 		runtime.WithEndpoint(
 			gw.RegisterHelloWorldServiceHandlerFromEndpoint,
 		),
+		runtime.WithGRPCWeb(runtime.RPCProxyMethods("/skelton.v1.HelloWorldService/*")),
+		runtime.WithConnect(),
 		runtime.WithHealthCheckPathHandle("/ping/heartbeat"),
 		runtime.WithStatusPathHandle("/ping/status"),
+		runtime.WithOpenAPI("/openapi.json",
+			runtime.OpenAPIFile("openapi/skelton/v1/*.swagger.json"),
+			runtime.OpenAPIUI("/docs", runtime.SwaggerUI),
+		),
 		runtime.WithCORS(
 			handlers.AllowCredentials(),
 			handlers.AllowedOrigins([]string{"*"}),
-			handlers.AllowedMethods([]string{http.MethodOptions, http.MethodGet}),
-			handlers.AllowedHeaders([]string{"Authorization", "Content-Type", "Accept-Encoding", "Accept"}),
+			handlers.AllowedMethods([]string{http.MethodOptions, http.MethodGet, http.MethodPost}),
+			handlers.AllowedHeaders([]string{"Authorization", "Content-Type", "Accept-Encoding", "Accept", "X-Grpc-Web", "X-User-Agent", "Connect-Protocol-Version"}),
 			handlers.MaxAge(300),
 		),
 		runtime.WithMetadata(
@@ -58,11 +65,7 @@ func main() {
 		panic(err)
 	}
 
-	defer func() {
-		server.Stop()
-	}()
-
-	if err := server.Start(); err != nil {
+	if err := runtime.Run(context.Background(), server); err != nil {
 		panic(err)
 	}
 }
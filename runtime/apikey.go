@@ -0,0 +1,506 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"os"
+	goruntime "runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// APIKeyHeader is the header carrying the API key unless other sources are configured.
+	APIKeyHeader = "X-API-Key"
+
+	// The metadata keys under which the API key of a request is described to the backend.
+	APIKeyNameMeta   = "x-api-key-name"
+	APIKeyTenantMeta = "x-api-key-tenant"
+	APIKeyScopesMeta = "x-api-key-scopes"
+)
+
+// APIKey describes the client owning an API key.
+type APIKey struct {
+	Name   string
+	Tenant string
+	Scopes []string
+	// Expires is the time after which the key is rejected, never when zero.
+	Expires time.Time
+}
+
+// KeyStore looks the API keys up. Lookup returns nil without an error for unknown keys.
+type KeyStore interface {
+	Lookup(ctx context.Context, key string) (*APIKey, error)
+}
+
+type APIKeyOption func(*apiKeyAuth)
+
+type apiKeyAuth struct {
+	store    KeyStore
+	sources  []func(*http.Request) string
+	exempt   []string
+	optional bool
+}
+
+// WithAPIKeyAuth is a GatewayOptionFunc that adds APIKeyAuthHandler to the handler chain.
+//
+// Example usage:
+//
+//	keys, err := NewFileKeyStore("/etc/gateway/api-keys.yaml")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//
+//	server := NewGateway(
+//	    WithAPIKeyAuth(keys, APIKeyFromHeader("X-API-Key"), APIKeyFromQuery("api_key")),
+//	)
+func WithAPIKeyAuth(store KeyStore, opts ...APIKeyOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.handlers = append(opt.handlers, APIKeyAuthHandler(store, opts...))
+	}
+}
+
+// APIKeyAuthHandler returns a GatewayHandler that requires an API key known to store on every
+// request but those to the exempt paths. The key is read from the X-API-Key header unless other
+// sources are given. Its name, tenant and scopes are forwarded to the backend as metadata and are
+// available with IdentityFromContext. Other requests are answered with Unauthenticated through
+// the error handler of the gateway, so an ErrorHandle registered for codes.Unauthenticated renders
+// them. Requests already authenticated by a previous handler of the chain are passed through.
+func APIKeyAuthHandler(store KeyStore, opts ...APIKeyOption) GatewayHandler {
+	a := &apiKeyAuth{store: store}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	if len(a.sources) == 0 {
+		APIKeyFromHeader(APIKeyHeader)(a)
+	}
+
+	keys := []string{APIKeyNameMeta, APIKeyTenantMeta, APIKeyScopesMeta}
+
+	return func(h http.Handler, o *GatewayOption) http.Handler {
+		if s, ok := a.store.(*fileKeyStore); ok {
+			s.logTo(o.err)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stripMetadataHeaders(r, keys)
+
+			if _, ok := IdentityFromContext(r.Context()); ok || exempted(r, a.exempt) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			value := ""
+
+			for _, source := range a.sources {
+				if value = source(r); value != "" {
+					break
+				}
+			}
+
+			if value == "" {
+				if a.optional {
+					h.ServeHTTP(w, r)
+					return
+				}
+
+				httpError(w, r, status.Error(codes.Unauthenticated, "missing API key"))
+				return
+			}
+
+			key, err := a.store.Lookup(r.Context(), value)
+
+			if err != nil {
+				httpError(w, r, status.Error(codes.Unavailable, "API key store unavailable"))
+				return
+			}
+
+			if key == nil || (!key.Expires.IsZero() && time.Now().After(key.Expires)) {
+				httpError(w, r, status.Error(codes.Unauthenticated, "invalid API key"))
+				return
+			}
+
+			h.ServeHTTP(w, withIdentity(r, key.identity()))
+		})
+	}
+}
+
+// APIKeyFromHeader reads the API key from the header name.
+func APIKeyFromHeader(name string) APIKeyOption {
+	return func(a *apiKeyAuth) {
+		a.sources = append(a.sources, func(r *http.Request) string {
+			return r.Header.Get(name)
+		})
+	}
+}
+
+// APIKeyFromQuery reads the API key from the query parameter name, which is removed from the
+// request so that it reaches neither the backend nor the access log.
+func APIKeyFromQuery(name string) APIKeyOption {
+	return func(a *apiKeyAuth) {
+		a.sources = append(a.sources, func(r *http.Request) string {
+			query := r.URL.Query()
+			value := query.Get(name)
+
+			if query.Has(name) {
+				query.Del(name)
+				r.URL.RawQuery = query.Encode()
+				r.RequestURI = r.URL.RequestURI()
+			}
+
+			return value
+		})
+	}
+}
+
+// APIKeyFromCookie reads the API key from the cookie name.
+func APIKeyFromCookie(name string) APIKeyOption {
+	return func(a *apiKeyAuth) {
+		a.sources = append(a.sources, func(r *http.Request) string {
+			cookie, err := r.Cookie(name)
+
+			if err != nil {
+				return ""
+			}
+
+			return cookie.Value
+		})
+	}
+}
+
+// APIKeyExemptPaths lets the requests matching the path patterns through without a key, with the
+// pattern syntax of WithRouteTimeout.
+func APIKeyExemptPaths(patterns ...string) APIKeyOption {
+	return func(a *apiKeyAuth) {
+		a.exempt = append(a.exempt, patterns...)
+	}
+}
+
+// APIKeyOptional passes the requests without a key on, leaving them to a later authentication
+// handler of the chain. Requests with an unknown key are still rejected.
+func APIKeyOptional() APIKeyOption {
+	return func(a *apiKeyAuth) {
+		a.optional = true
+	}
+}
+
+func (k *APIKey) identity() *Identity {
+	md := metadata.Pairs(APIKeyNameMeta, k.Name)
+
+	if k.Tenant != "" {
+		md.Set(APIKeyTenantMeta, k.Tenant)
+	}
+
+	if len(k.Scopes) > 0 {
+		md.Set(APIKeyScopesMeta, k.Scopes...)
+	}
+
+	return &Identity{
+		Subject:  k.Name,
+		Scheme:   "api_key",
+		Scopes:   k.Scopes,
+		Claims:   map[string]interface{}{"tenant": k.Tenant},
+		metadata: md,
+	}
+}
+
+// HashAPIKey returns the hex encoded SHA-256 digest of key, as taken by NewHashedKeyStore.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// MemoryKeyStore is a KeyStore holding the digests of its keys in memory. The keys can be
+// replaced while the gateway serves requests, without locking the lookups.
+type MemoryKeyStore struct {
+	keys atomic.Pointer[map[[sha256.Size]byte]APIKey]
+}
+
+// NewMemoryKeyStore returns a MemoryKeyStore of the plain keys.
+func NewMemoryKeyStore(keys map[string]APIKey) *MemoryKeyStore {
+	s := &MemoryKeyStore{}
+	s.Set(keys)
+
+	return s
+}
+
+// NewHashedKeyStore returns a MemoryKeyStore of the keys given by their HashAPIKey digest, so
+// that the keys themselves need not be stored with the gateway.
+func NewHashedKeyStore(digests map[string]APIKey) (*MemoryKeyStore, error) {
+	s := &MemoryKeyStore{}
+
+	if err := s.SetHashed(digests); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Set replaces the keys of the store with the plain keys.
+func (s *MemoryKeyStore) Set(keys map[string]APIKey) {
+	hashed := make(map[[sha256.Size]byte]APIKey, len(keys))
+
+	for key, value := range keys {
+		hashed[sha256.Sum256([]byte(key))] = value
+	}
+
+	s.replace(hashed)
+}
+
+// SetHashed replaces the keys of the store with the keys given by their HashAPIKey digest.
+func (s *MemoryKeyStore) SetHashed(digests map[string]APIKey) error {
+	hashed := make(map[[sha256.Size]byte]APIKey, len(digests))
+
+	for digest, value := range digests {
+		sum, ok := parseDigest(digest)
+
+		if !ok {
+			return fmt.Errorf("invalid API key digest for %q", value.Name)
+		}
+
+		hashed[sum] = value
+	}
+
+	s.replace(hashed)
+
+	return nil
+}
+
+func parseDigest(digest string) ([sha256.Size]byte, bool) {
+	var sum [sha256.Size]byte
+
+	buf, err := hex.DecodeString(digest)
+
+	if err != nil || len(buf) != sha256.Size {
+		return sum, false
+	}
+
+	copy(sum[:], buf)
+
+	return sum, true
+}
+
+func (s *MemoryKeyStore) replace(keys map[[sha256.Size]byte]APIKey) {
+	s.keys.Store(&keys)
+}
+
+func (s *MemoryKeyStore) Lookup(_ context.Context, key string) (*APIKey, error) {
+	keys := s.keys.Load()
+
+	if keys == nil {
+		return nil, nil
+	}
+
+	value, ok := (*keys)[sha256.Sum256([]byte(key))]
+
+	if !ok {
+		return nil, nil
+	}
+
+	return &value, nil
+}
+
+// APIKeyEntry is an entry of an API key file, giving either the key or its SHA-256 digest.
+type APIKeyEntry struct {
+	Name    string    `json:"name" yaml:"name"`
+	Key     string    `json:"key,omitempty" yaml:"key,omitempty"`
+	SHA256  string    `json:"sha256,omitempty" yaml:"sha256,omitempty"`
+	Tenant  string    `json:"tenant,omitempty" yaml:"tenant,omitempty"`
+	Scopes  []string  `json:"scopes,omitempty" yaml:"scopes,omitempty"`
+	Expires time.Time `json:"expires,omitempty" yaml:"expires,omitempty"`
+}
+
+// fileKeyStore is the KeyStore of NewFileKeyStore. The refresh loop only holds the fileKeys, so
+// that it is stopped once the store is no longer used.
+type fileKeyStore struct {
+	*fileKeys
+}
+
+type fileKeys struct {
+	path  string
+	store *MemoryKeyStore
+	stop  chan struct{}
+	once  sync.Once
+	log   atomic.Pointer[logrus.Logger]
+
+	// mu serializes the refreshes, which run in the refresh loop rather than in the lookups
+	mu      sync.Mutex
+	modTime time.Time
+	// failure is the last error refreshing the keys, logged once until the keys load again
+	failure string
+}
+
+// NewFileKeyStore returns a KeyStore of the API keys listed in the YAML or JSON file at path,
+// a list of APIKeyEntry values. The file is checked for changes once a second in the background
+// and read again when it was modified, so lookups never wait for the file; keys that fail to
+// load keep the previous ones in effect, and the error is logged to the error log of the gateway
+// using the store.
+//
+// Example (YAML):
+//
+//	# api-keys.yaml
+//	- name: billing
+//	  sha256: 5e884898da28047151d0e56f8dc6292773603d0d6aabbdd62a11ef721d1542d8
+//	  tenant: acme
+//	  scopes: [invoices.read]
+//	- name: ci
+//	  key: s3cr3t
+//	  expires: 2025-01-01T00:00:00Z
+func NewFileKeyStore(path string) (KeyStore, error) {
+	keys := &fileKeys{path: path, store: &MemoryKeyStore{}, stop: make(chan struct{})}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key file %s: %s", path, err)
+	}
+
+	if err := keys.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+
+	go keys.poll(time.Second)
+
+	s := &fileKeyStore{keys}
+	goruntime.SetFinalizer(s, func(s *fileKeyStore) {
+		s.close()
+	})
+
+	return s, nil
+}
+
+func (s *fileKeys) Lookup(ctx context.Context, key string) (*APIKey, error) {
+	return s.store.Lookup(ctx, key)
+}
+
+// poll refreshes the keys every interval until stop is closed.
+func (s *fileKeys) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.refresh()
+		}
+	}
+}
+
+// close stops the refresh loop.
+func (s *fileKeys) close() {
+	s.once.Do(func() {
+		close(s.stop)
+	})
+}
+
+// refresh reads the file again when it was modified since the keys were loaded.
+func (s *fileKeys) refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+
+	if err == nil && info.ModTime().Equal(s.modTime) {
+		return
+	}
+
+	if err == nil {
+		err = s.load(info.ModTime())
+	} else {
+		err = fmt.Errorf("failed to read API key file %s: %s", s.path, err)
+	}
+
+	if err == nil {
+		s.failure = ""
+		return
+	}
+
+	// A file that stays broken is logged once rather than on every check
+	if err.Error() == s.failure {
+		return
+	}
+
+	s.failure = err.Error()
+
+	if log := s.log.Load(); log != nil {
+		log.Errorf("Failed to reload API keys, keeping the previous ones: %v", err)
+	} else {
+		grpclog.Errorf("Failed to reload API keys, keeping the previous ones: %v", err)
+	}
+}
+
+// logTo makes the store log the errors refreshing the keys to log.
+func (s *fileKeys) logTo(log *logrus.Logger) {
+	s.log.Store(log)
+}
+
+func (s *fileKeys) load(modTime time.Time) error {
+	entries, err := readAPIKeyFile(s.path)
+
+	if err != nil {
+		return err
+	}
+
+	hashed := make(map[[sha256.Size]byte]APIKey, len(entries))
+
+	for _, entry := range entries {
+		var sum [sha256.Size]byte
+		var ok bool
+
+		switch {
+		case entry.Key != "" && entry.SHA256 == "":
+			sum = sha256.Sum256([]byte(entry.Key))
+		case entry.SHA256 != "" && entry.Key == "":
+			if sum, ok = parseDigest(entry.SHA256); !ok {
+				return fmt.Errorf("invalid API key digest for %q in %s", entry.Name, s.path)
+			}
+		default:
+			return fmt.Errorf("API key %q in %s needs one of key and sha256", entry.Name, s.path)
+		}
+
+		hashed[sum] = APIKey{Name: entry.Name, Tenant: entry.Tenant, Scopes: entry.Scopes, Expires: entry.Expires}
+	}
+
+	s.store.replace(hashed)
+	s.modTime = modTime
+
+	return nil
+}
+
+func readAPIKeyFile(path string) ([]APIKeyEntry, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API key file %s: %s", path, err)
+	}
+
+	var entries []APIKeyEntry
+
+	// YAML is a superset of JSON, so both are read by the YAML decoder
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&entries); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse API key file %s: %s", path, err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,185 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryKeyStore(t *testing.T) {
+	store := NewMemoryKeyStore(map[string]APIKey{"secret": {Name: "ci"}})
+	ctx := context.Background()
+
+	key, err := store.Lookup(ctx, "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "ci", key.Name)
+
+	key, err = store.Lookup(ctx, "other")
+	assert.NoError(t, err)
+	assert.Nil(t, key)
+
+	assert.NoError(t, store.SetHashed(map[string]APIKey{HashAPIKey("rotated"): {Name: "ci"}}))
+
+	key, _ = store.Lookup(ctx, "secret")
+	assert.Nil(t, key)
+	key, _ = store.Lookup(ctx, "rotated")
+	assert.Equal(t, "ci", key.Name)
+
+	_, err = NewHashedKeyStore(map[string]APIKey{"abcd": {Name: "short"}})
+	assert.Error(t, err)
+	_, err = NewHashedKeyStore(map[string]APIKey{HashAPIKey("x") + "00": {Name: "long"}})
+	assert.Error(t, err)
+}
+
+func TestFileKeyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	ctx := context.Background()
+
+	assert.NoError(t, os.WriteFile(path, []byte(`
+- name: billing
+  sha256: `+HashAPIKey("hashed")+`
+  tenant: acme
+  scopes: [invoices.read, invoices.write]
+- name: ci
+  key: plain
+`), 0o600))
+
+	store, err := NewFileKeyStore(path)
+	assert.NoError(t, err)
+
+	key, _ := store.Lookup(ctx, "hashed")
+	assert.Equal(t, &APIKey{Name: "billing", Tenant: "acme", Scopes: []string{"invoices.read", "invoices.write"}}, key)
+	key, _ = store.Lookup(ctx, "plain")
+	assert.Equal(t, "ci", key.Name)
+
+	// The keys are refreshed in the background, so a lookup sees a change once the file is read
+	assert.NoError(t, os.WriteFile(path, []byte(`[{"name": "json", "key": "new"}]`), 0o600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+
+	assert.Eventually(t, func() bool {
+		key, _ = store.Lookup(ctx, "new")
+		return key != nil && key.Name == "json"
+	}, 3*time.Second, 10*time.Millisecond)
+
+	key, _ = store.Lookup(ctx, "plain")
+	assert.Nil(t, key)
+
+	// A broken file keeps the keys in effect and is logged once, a fixed one replaces them
+	fs := store.(*fileKeyStore)
+	fs.close()
+
+	logs := &bytes.Buffer{}
+	fs.logTo(&logrus.Logger{Out: logs, Formatter: &logrus.TextFormatter{}, Level: logrus.ErrorLevel})
+
+	assert.NoError(t, os.WriteFile(path, []byte(`- name: broken`), 0o600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	fs.refresh()
+
+	key, _ = store.Lookup(ctx, "new")
+	assert.Equal(t, "json", key.Name)
+	assert.Contains(t, logs.String(), "needs one of key and sha256")
+
+	fs.refresh()
+	assert.Equal(t, 1, strings.Count(logs.String(), "Failed to reload API keys"))
+
+	assert.NoError(t, os.WriteFile(path, []byte(`- name: ci
+  key: plain
+`), 0o600))
+	assert.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(3*time.Minute)))
+	fs.refresh()
+
+	key, _ = store.Lookup(ctx, "new")
+	assert.Nil(t, key)
+	key, _ = store.Lookup(ctx, "plain")
+	assert.Equal(t, "ci", key.Name)
+}
+
+func TestAPIKeyAuthHandler(t *testing.T) {
+	store := NewMemoryKeyStore(map[string]APIKey{
+		"valid":   {Name: "billing", Tenant: "acme", Scopes: []string{"read", "write"}},
+		"expired": {Name: "old", Expires: time.Now().Add(-time.Hour)},
+	})
+
+	o := newGatewayOption()
+	WithErrorHandler(ErrorHandle(codes.Unauthenticated, func(ctx context.Context, mux *runtime.ServeMux, w http.ResponseWriter, r *http.Request, s *status.Status) *ErrorResult {
+		return &ErrorResult{Message: structpb.NewStringValue("login required: " + s.Message())}
+	}))(o)
+
+	handler := APIKeyAuthHandler(store,
+		APIKeyFromHeader("X-API-Key"),
+		APIKeyFromQuery("api_key"),
+		APIKeyFromCookie("key"),
+		APIKeyExemptPaths("/ping/heartbeat"),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, ok := IdentityFromContext(r.Context()); ok {
+			md := identityMetadata(r.Context(), r)
+			w.Header().Set("X-Subject", id.Subject)
+			w.Header()["X-Tenant"] = md.Get(APIKeyTenantMeta)
+			w.Header()["X-Scopes"] = md.Get(APIKeyScopesMeta)
+		}
+
+		w.Header().Set("X-Query", r.URL.RawQuery)
+		w.Header()["X-Forged"] = r.Header.Values("Grpc-Metadata-" + APIKeyTenantMeta)
+	}), o)
+	handler = withServeMux(handler, runtime.NewServeMux(errorCapture(o)))
+
+	tests := []struct {
+		name    string
+		request func(r *http.Request)
+		path    string
+		status  int
+	}{
+		{"header", func(r *http.Request) { r.Header.Set("X-API-Key", "valid") }, "/v1/users", http.StatusOK},
+		{"query", nil, "/v1/users?api_key=valid&page=2", http.StatusOK},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "key", Value: "valid"}) }, "/v1/users", http.StatusOK},
+		{"unknown", func(r *http.Request) { r.Header.Set("X-API-Key", "other") }, "/v1/users", http.StatusUnauthorized},
+		{"expired", func(r *http.Request) { r.Header.Set("X-API-Key", "expired") }, "/v1/users", http.StatusUnauthorized},
+		{"missing", nil, "/v1/users", http.StatusUnauthorized},
+		{"exempt", nil, "/ping/heartbeat", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("Grpc-Metadata-"+APIKeyTenantMeta, "forged")
+
+			if tt.request != nil {
+				tt.request(r)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, w.Body.String(), "login required")
+				return
+			}
+
+			assert.Empty(t, w.Header().Values("X-Forged"))
+
+			if tt.path == "/v1/users" || tt.name == "query" {
+				assert.Equal(t, "billing", w.Header().Get("X-Subject"))
+				assert.Equal(t, []string{"acme"}, w.Header().Values("X-Tenant"))
+				assert.Equal(t, []string{"read", "write"}, w.Header().Values("X-Scopes"))
+			}
+
+			if tt.name == "query" {
+				assert.Equal(t, "page=2", w.Header().Get("X-Query"))
+			}
+		})
+	}
+}
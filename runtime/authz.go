@@ -0,0 +1,158 @@
+package runtime
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+// AuthorizationRule grants the calls to a gRPC method or the requests to an HTTP route. Method
+// takes the gRPC full method name, or "/package.Service/*" for every method of a service. Path
+// takes a route pattern with the syntax of WithRouteTimeout, for the HTTPMethod or any method when
+// empty. Calls are granted to the identities holding all Scopes and, when Roles is set, one of the
+// Roles. Public rules grant the calls to unauthenticated clients as well.
+type AuthorizationRule struct {
+	Method     string
+	HTTPMethod string
+	Path       string
+	Scopes     []string
+	Roles      []string
+	Public     bool
+}
+
+// AuthorizationPolicy is a list of AuthorizationRule values, the first matching rule deciding.
+// Without a matching rule the backend calls are allowed, or denied with DenyByDefault.
+type AuthorizationPolicy struct {
+	DenyByDefault bool
+	Rules         []AuthorizationRule
+}
+
+type routeGrantedKey struct{}
+
+// WithAuthorization is a GatewayOptionFunc that enforces policy on the identity authenticated by
+// the JWTAuthHandler or APIKeyAuthHandler of the chain. The route rules are evaluated in front of
+// the backends and the method rules for every backend call, so both must grant a request they
+// match. Requests without an identity are answered with Unauthenticated and those lacking
+// permission with PermissionDenied, through the error handler of the gateway.
+//
+// DenyByDefault applies to the backend calls matched by neither kind of rule; the path handlers of
+// the gateway, such as the health check, are subject to the route rules only.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithJWTAuth(JWKSFile("/etc/gateway/jwks.json")),
+//	    WithAuthorization(AuthorizationPolicy{
+//	        DenyByDefault: true,
+//	        Rules: []AuthorizationRule{
+//	            {Method: "/users.UserService/GetUser", Scopes: []string{"users.read"}},
+//	            {Method: "/users.UserService/*", Roles: []string{"admin"}},
+//	            {HTTPMethod: "GET", Path: "/v1/public/**", Public: true},
+//	        },
+//	    }),
+//	)
+func WithAuthorization(policy AuthorizationPolicy) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.handlers = append(opt.handlers, policy.handler)
+		opt.interceptors = append(opt.interceptors, policy.interceptor())
+		opt.policies = append(opt.policies, policy)
+	}
+}
+
+// handler evaluates the route rules before the request reaches the mux.
+func (p AuthorizationPolicy) handler(h http.Handler, _ *GatewayOption) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range p.Rules {
+			if rule.Path == "" || !rule.matchRoute(r) {
+				continue
+			}
+
+			if err := rule.authorize(r.Context()); err != nil {
+				httpError(w, r, err)
+				return
+			}
+
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), routeGrantedKey{}, true)))
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// authorizeMethod evaluates the method rules for a call of method with the identity of ctx.
+func (p AuthorizationPolicy) authorizeMethod(ctx context.Context, method string) error {
+	for _, rule := range p.Rules {
+		if rule.Method != "" && matchMethod(rule.Method, method) {
+			return rule.authorize(ctx)
+		}
+	}
+
+	if granted, _ := ctx.Value(routeGrantedKey{}).(bool); granted || !p.DenyByDefault {
+		return nil
+	}
+
+	return status.Errorf(codes.PermissionDenied, "access to %s denied", method)
+}
+
+// interceptor evaluates the method rules before the backend is called.
+func (p AuthorizationPolicy) interceptor() ClientInterceptor {
+	return ClientInterceptor{
+		Unary: func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			if err := p.authorizeMethod(ctx, method); err != nil {
+				return err
+			}
+
+			return invoker(ctx, method, req, reply, cc, opts...)
+		},
+		Stream: func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			if err := p.authorizeMethod(ctx, method); err != nil {
+				return nil, err
+			}
+
+			return streamer(ctx, desc, cc, method, opts...)
+		},
+	}
+}
+
+func (r AuthorizationRule) matchRoute(req *http.Request) bool {
+	if r.HTTPMethod != "" && r.HTTPMethod != "*" && !strings.EqualFold(r.HTTPMethod, req.Method) {
+		return false
+	}
+
+	return matchPath(r.Path, req.URL.Path)
+}
+
+// authorize grants the rule to the identity of ctx.
+func (r AuthorizationRule) authorize(ctx context.Context) error {
+	if r.Public {
+		return nil
+	}
+
+	id, ok := IdentityFromContext(ctx)
+
+	if !ok {
+		return status.Error(codes.Unauthenticated, "authentication required")
+	}
+
+	for _, scope := range r.Scopes {
+		if !contains(id.Scopes, scope) {
+			return status.Errorf(codes.PermissionDenied, "missing scope %q", scope)
+		}
+	}
+
+	if len(r.Roles) == 0 {
+		return nil
+	}
+
+	for _, role := range r.Roles {
+		if contains(id.Roles, role) {
+			return nil
+		}
+	}
+
+	return status.Error(codes.PermissionDenied, "missing role")
+}
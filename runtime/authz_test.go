@@ -0,0 +1,142 @@
+package runtime
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorizationPolicy_Interceptor(t *testing.T) {
+	policy := AuthorizationPolicy{
+		DenyByDefault: true,
+		Rules: []AuthorizationRule{
+			{Method: "/users.UserService/GetUser", Scopes: []string{"users.read"}},
+			{Method: "/users.UserService/ListPublic", Public: true},
+			{Method: "/users.UserService/*", Roles: []string{"admin", "ops"}},
+		},
+	}
+
+	interceptor := policy.interceptor()
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+
+	reader := &Identity{Subject: "reader", Scopes: []string{"users.read"}}
+	admin := &Identity{Subject: "admin", Roles: []string{"admin"}}
+
+	tests := []struct {
+		name   string
+		id     *Identity
+		route  bool
+		method string
+		code   codes.Code
+	}{
+		{"scope granted", reader, false, "/users.UserService/GetUser", codes.OK},
+		{"scope missing", admin, false, "/users.UserService/GetUser", codes.PermissionDenied},
+		{"unauthenticated", nil, false, "/users.UserService/GetUser", codes.Unauthenticated},
+		{"public", nil, false, "/users.UserService/ListPublic", codes.OK},
+		{"role granted", admin, false, "/users.UserService/DeleteUser", codes.OK},
+		{"role missing", reader, false, "/users.UserService/DeleteUser", codes.PermissionDenied},
+		{"deny by default", admin, false, "/billing.BillingService/Charge", codes.PermissionDenied},
+		{"granted by route", nil, true, "/billing.BillingService/Charge", codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if tt.id != nil {
+				ctx = context.WithValue(ctx, identityKey{}, tt.id)
+			}
+
+			if tt.route {
+				ctx = context.WithValue(ctx, routeGrantedKey{}, true)
+			}
+
+			err := interceptor.Unary(ctx, tt.method, nil, nil, nil, invoker)
+
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+
+	policy.DenyByDefault = false
+	err := policy.interceptor().Unary(context.Background(), "/billing.BillingService/Charge", nil, nil, nil, invoker)
+
+	assert.NoError(t, err)
+}
+
+func TestAuthorizationPolicy_Handler(t *testing.T) {
+	policy := AuthorizationPolicy{
+		DenyByDefault: true,
+		Rules: []AuthorizationRule{
+			{HTTPMethod: "GET", Path: "/v1/public/**", Public: true},
+			{Path: "/v1/admin/**", Roles: []string{"admin"}},
+		},
+	}
+
+	handler := policy.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		granted, _ := r.Context().Value(routeGrantedKey{}).(bool)
+
+		if granted {
+			w.Header().Set("X-Granted", "true")
+		}
+	}), newGatewayOption())
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		id      *Identity
+		status  int
+		granted bool
+	}{
+		{"public", "GET", "/v1/public/docs", nil, http.StatusOK, true},
+		{"public method only", "POST", "/v1/public/docs", nil, http.StatusOK, false},
+		{"role granted", "DELETE", "/v1/admin/users/1", &Identity{Roles: []string{"admin"}}, http.StatusOK, true},
+		{"role missing", "DELETE", "/v1/admin/users/1", &Identity{Roles: []string{"user"}}, http.StatusForbidden, false},
+		{"unauthenticated", "GET", "/v1/admin/users", nil, http.StatusUnauthorized, false},
+		{"left to the methods", "GET", "/v1/users", nil, http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+
+			if tt.id != nil {
+				r = withIdentity(r, tt.id)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.granted, w.Header().Get("X-Granted") == "true")
+		})
+	}
+}
+
+func TestAuthorizationConfig_Policy(t *testing.T) {
+	c := &AuthorizationConfig{
+		Default: "deny",
+		Rules: []AuthorizationRuleConfig{
+			{Method: "/users.UserService/*", Scopes: []string{"users.read"}},
+		},
+	}
+
+	policy, err := c.policy()
+
+	assert.NoError(t, err)
+	assert.True(t, policy.DenyByDefault)
+	assert.Equal(t, []AuthorizationRule{{Method: "/users.UserService/*", Scopes: []string{"users.read"}}}, policy.Rules)
+
+	_, err = (&AuthorizationConfig{Default: "maybe"}).policy()
+	assert.Error(t, err)
+
+	_, err = (&AuthorizationConfig{Rules: []AuthorizationRuleConfig{{Public: true}}}).policy()
+	assert.Error(t, err)
+}
@@ -0,0 +1,410 @@
+package runtime
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultBackend is the name of the backend set by WithBackend and WithBackendTLS,
+// which the endpoints added with WithEndpoint are registered against.
+const DefaultBackend = "default"
+
+// Backend is a named gRPC server the gateway forwards requests to.
+type Backend struct {
+	name     string
+	server   ServerInfo
+	tls      *BackendTLS
+	insecure bool
+	dialOpts []grpc.DialOption
+	source   addressSource
+	balancer BalancingPolicy
+	weights  map[string]uint32
+
+	interceptors []ClientInterceptor
+	retry        retrySettings
+	breaker      *BreakerPolicy
+}
+
+type BackendOption func(*Backend)
+
+type endpointBinding struct {
+	backend  string
+	endpoint GatewayEndpoint
+}
+
+// WithNamedBackend is a GatewayOptionFunc that adds the backend name, or replaces its address
+// when it exists. Endpoints are routed to it with WithBackendEndpoint.
+// Using DefaultBackend as name configures the backend of WithBackend.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithBackend("127.0.0.1", 8080),
+//	    WithNamedBackend("users", "users.internal", 9090,
+//	        UseBackendTLS(BackendCA("ca.pem")),
+//	    ),
+//	    WithEndpoint(gw.RegisterHelloWorldServiceHandlerFromEndpoint),
+//	    WithBackendEndpoint("users", users.RegisterUserServiceHandlerFromEndpoint),
+//	)
+func WithNamedBackend(name, host string, port uint, opts ...BackendOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		b := opt.backendOf(name)
+		b.server = ServerInfo{host, port}
+
+		for _, o := range opts {
+			o(b)
+		}
+	}
+}
+
+// WithBackendEndpoint is a GatewayOptionFunc that registers the GatewayEndpoints against the
+// backend name instead of the default backend.
+func WithBackendEndpoint(backend string, endpoints ...GatewayEndpoint) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		for _, endpoint := range endpoints {
+			opt.endpoints = append(opt.endpoints, endpointBinding{backend, endpoint})
+		}
+	}
+}
+
+// UseBackendTLS makes a named backend dial over TLS, as WithBackendTLS does for the default one.
+func UseBackendTLS(opts ...BackendTLSOption) BackendOption {
+	return func(b *Backend) {
+		b.tls = newBackendTLS(opts...)
+		b.insecure = false
+	}
+}
+
+// UseBackendInsecure makes a named backend dial in plaintext on purpose, as WithBackendInsecure
+// does for the default one.
+func UseBackendInsecure() BackendOption {
+	return func(b *Backend) {
+		b.tls = nil
+		b.insecure = true
+	}
+}
+
+// BackendDialOptions adds grpc.DialOption values used when dialing the backend.
+func BackendDialOptions(opts ...grpc.DialOption) BackendOption {
+	return func(b *Backend) {
+		b.dialOpts = append(b.dialOpts, opts...)
+	}
+}
+
+// backendOf returns the backend name, adding it with the default address when missing.
+func (o *GatewayOption) backendOf(name string) *Backend {
+	b, ok := o.backends[name]
+
+	if !ok {
+		b = &Backend{name: name, server: ServerInfo{"127.0.0.1", 8080}}
+		o.backends[name] = b
+	}
+
+	return b
+}
+
+// backendAddrs describes the backend addresses for the log messages.
+func (o *GatewayOption) backendAddrs() string {
+	values := make([]string, 0, len(o.backends))
+
+	for name, b := range o.backends {
+		addr := b.server.ToString()
+
+		if b.source != nil {
+			addr = b.source.String()
+		}
+
+		if name == DefaultBackend {
+			values = append(values, addr)
+		} else {
+			values = append(values, name+"="+addr)
+		}
+	}
+
+	sort.Strings(values)
+
+	return strings.Join(values, ", ")
+}
+
+// target returns the address the endpoints dial the backend at. Backends with an address source
+// are resolved by the resolver from dialOptions, balanced backends without one through DNS.
+func (b *Backend) target() (string, error) {
+	if b.source != nil {
+		return discoveryScheme + ":///" + b.name, nil
+	}
+
+	host, err := b.server.ValidToString()
+
+	if err != nil {
+		return "", err
+	}
+
+	if b.balancer != "" {
+		return "dns:///" + host, nil
+	}
+
+	return host, nil
+}
+
+// dialOptions returns the options the endpoints dial the backend with, applying the options
+// shared by every backend before the own ones of the backend. The retry settings of the backend
+// override the shared retry settings.
+func (b *Backend) dialOptions(retry retrySettings, shared ...grpc.DialOption) ([]grpc.DialOption, error) {
+	credential, err := b.tls.credentials()
+
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %s", b.name, err)
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(credential),
+	}
+
+	if b.source != nil {
+		opts = append(opts, grpc.WithResolvers(&discoveryBuilder{source: b.source, weights: b.weights}))
+
+		// The target only names the backend, so verify the certificates against the DNS name
+		if dns, ok := b.source.(*dnsSource); ok {
+			opts = append(opts, grpc.WithAuthority(dns.name))
+		}
+	}
+
+	config, err := b.serviceConfig(retry.merge(b.retry))
+
+	if err != nil {
+		return nil, fmt.Errorf("backend %s: %s", b.name, err)
+	}
+
+	if config != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(config))
+	}
+
+	opts = append(opts, shared...)
+	opts = append(opts, b.dialOpts...)
+
+	return append(opts, interceptorOptions(b.interceptors)...), nil
+}
+
+// serviceConfig returns the gRPC service config applied to the backend connections, combining the
+// balancing policy with the retry settings. Backends with an address source balance round robin
+// unless a policy is set.
+func (b *Backend) serviceConfig(retry retrySettings) (string, error) {
+	config := make(map[string]interface{})
+	policy := b.balancer
+
+	if policy == "" && b.source != nil {
+		policy = RoundRobin
+	}
+
+	if policy != "" {
+		lb, err := policy.config()
+
+		if err != nil {
+			return "", err
+		}
+
+		config["loadBalancingConfig"] = []json.RawMessage{json.RawMessage(lb)}
+	}
+
+	methods, err := retry.methodConfigs()
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(methods) > 0 {
+		config["methodConfig"] = methods
+	}
+
+	if retry.budget != nil {
+		if err := retry.budget.validate(); err != nil {
+			return "", err
+		}
+
+		config["retryThrottling"] = map[string]float64{
+			"maxTokens":  retry.budget.MaxTokens,
+			"tokenRatio": retry.budget.TokenRatio,
+		}
+	}
+
+	if len(config) == 0 {
+		return "", nil
+	}
+
+	buf, err := json.Marshal(config)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+// BackendTLS is the transport security used to dial the backend gRPC server.
+// It is independent of the TLS configuration of the gateway listener set by WithTLS.
+type BackendTLS struct {
+	ca         []string
+	serverName string
+	cert       string
+	key        string
+	insecure   bool
+}
+
+type BackendTLSOption func(*BackendTLS)
+
+// WithBackendTLS is a GatewayOptionFunc that makes the gateway dial the backend over TLS.
+// Without options the backend certificate is verified against the system roots.
+// When neither WithBackendTLS nor WithBackendInsecure is used the backend is dialed in plaintext,
+// and the gateway warns about it when it starts. With WithTLS the default backend is dialed over
+// TLS instead, with the certificate of the listener as its only CA, which only suits a backend
+// sharing that certificate; the gateway warns about this fallback once as well, so set
+// WithBackendTLS with the CA of the backend.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithBackendTLS(
+//	        BackendCA("ca.pem"),
+//	        BackendServerName("backend.internal"),
+//	        BackendClientCert("client.pem", "client-key.pem"),
+//	    ),
+//	)
+func WithBackendTLS(opts ...BackendTLSOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		UseBackendTLS(opts...)(opt.backendOf(DefaultBackend))
+	}
+}
+
+// WithBackendInsecure is a GatewayOptionFunc that makes the gateway dial the backend in plaintext
+// on purpose, such as over a loopback interface or inside a service mesh encrypting the traffic.
+// It silences the warning about a backend lacking TLS settings.
+func WithBackendInsecure() GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		UseBackendInsecure()(opt.backendOf(DefaultBackend))
+	}
+}
+
+// backendTLS returns the transport security the backend is dialed with. The default backend of a
+// gateway with WithTLS trusts the certificate of the listener when no backend mode is chosen.
+func (o *GatewayOption) backendTLS(b *Backend) *BackendTLS {
+	if o.trustsListener(b) {
+		return newBackendTLS(BackendCA(o.tls.cert))
+	}
+
+	return b.tls
+}
+
+// trustsListener reports whether the backend is dialed with the listener certificate as its CA.
+func (o *GatewayOption) trustsListener(b *Backend) bool {
+	return b.tls == nil && !b.insecure && b.name == DefaultBackend && o.tls != nil && o.tls.cert != ""
+}
+
+// plaintextBackends returns the sorted names of the backends dialed in plaintext without being
+// configured to.
+func (o *GatewayOption) plaintextBackends() []string {
+	names := make([]string, 0)
+
+	for name, b := range o.backends {
+		if o.backendTLS(b) == nil && !b.insecure {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func newBackendTLS(opts ...BackendTLSOption) *BackendTLS {
+	t := &BackendTLS{}
+
+	for _, o := range opts {
+		o(t)
+	}
+
+	return t
+}
+
+// BackendCA sets the PEM encoded CA bundles used to verify the backend certificate
+// instead of the system roots.
+func BackendCA(paths ...string) BackendTLSOption {
+	return func(t *BackendTLS) {
+		t.ca = append(t.ca, paths...)
+	}
+}
+
+// BackendServerName overrides the server name verified against the backend certificate,
+// which defaults to the backend host.
+func BackendServerName(name string) BackendTLSOption {
+	return func(t *BackendTLS) {
+		t.serverName = name
+	}
+}
+
+// BackendClientCert sets the client certificate and key presented to the backend for mutual TLS.
+func BackendClientCert(cert, key string) BackendTLSOption {
+	return func(t *BackendTLS) {
+		t.cert = cert
+		t.key = key
+	}
+}
+
+// BackendInsecureSkipVerify keeps TLS to the backend but disables the verification of its
+// certificate. It is meant for development against self-signed backends only.
+func BackendInsecureSkipVerify() BackendTLSOption {
+	return func(t *BackendTLS) {
+		t.insecure = true
+	}
+}
+
+// credentials returns the transport credentials for dialing the backend,
+// falling back to plaintext when t is nil.
+func (t *BackendTLS) credentials() (credentials.TransportCredentials, error) {
+	if t == nil {
+		return insecure.NewCredentials(), nil
+	}
+
+	config := &tls.Config{
+		ServerName:         t.serverName,
+		InsecureSkipVerify: t.insecure,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if len(t.ca) > 0 {
+		pool := x509.NewCertPool()
+
+		for _, path := range t.ca {
+			pem, err := os.ReadFile(path)
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to read backend CA file %s: %s", path, err)
+			}
+
+			if ok := pool.AppendCertsFromPEM(pem); !ok {
+				return nil, fmt.Errorf("no certificate found in backend CA file %s", path)
+			}
+		}
+
+		config.RootCAs = pool
+	}
+
+	if t.cert != "" || t.key != "" {
+		cert, err := tls.LoadX509KeyPair(t.cert, t.key)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to load backend client certificate %s: %s", t.cert, err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return credentials.NewTLS(config), nil
+}
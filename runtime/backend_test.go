@@ -0,0 +1,169 @@
+package runtime
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func recordEndpoint(hosts *[]string) GatewayEndpoint {
+	return func(_ context.Context, _ *runtime.ServeMux, host string, opts []grpc.DialOption) error {
+		*hosts = append(*hosts, host)
+		return nil
+	}
+}
+
+func TestWithBackendEndpoint(t *testing.T) {
+	hosts := make([]string, 0)
+
+	server, err := NewGateway(
+		WithSilent(true),
+		WithBackend("10.0.0.1", 8080),
+		WithNamedBackend("users", "10.0.0.2", 9090, BackendDialOptions(grpc.WithUserAgent("gateway"))),
+		WithEndpoint(recordEndpoint(&hosts)),
+		WithBackendEndpoint("users", recordEndpoint(&hosts)),
+	)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	gen, err := server.build()
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	gen.retire(0)
+
+	assert.Equal(t, []string{"10.0.0.1:8080", "10.0.0.2:9090"}, hosts)
+	assert.Len(t, server.backends["users"].dialOpts, 1)
+	assert.Equal(t, "10.0.0.1:8080, users=10.0.0.2:9090", server.backendAddrs())
+
+	server, err = NewGateway(
+		WithSilent(true),
+		WithBackendEndpoint("missing", recordEndpoint(&hosts)),
+	)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = server.build()
+	assert.ErrorContains(t, err, `unknown backend "missing"`)
+}
+
+func TestBackendTLS(t *testing.T) {
+	dir := t.TempDir()
+	serverCert, serverKey := writeTestKeyPair(t, dir, "backend", "localhost")
+	clientCert, clientKey := writeTestKeyPair(t, dir, "gateway")
+
+	pair, err := tls.LoadX509KeyPair(serverCert, serverKey)
+	assert.NoError(t, err)
+
+	clients := x509.NewCertPool()
+	pem, err := os.ReadFile(clientCert)
+	assert.NoError(t, err)
+	clients.AppendCertsFromPEM(pem)
+
+	// The backend requires the client certificate of the gateway
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{pair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clients,
+	})))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	defer server.Stop()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	tests := []struct {
+		name string
+		tls  *BackendTLS
+		ok   bool
+	}{
+		{"mutual TLS", newBackendTLS(BackendCA(serverCert), BackendClientCert(clientCert, clientKey)), true},
+		{"server name", newBackendTLS(BackendCA(serverCert), BackendServerName("localhost"), BackendClientCert(clientCert, clientKey)), true},
+		{"skip verify", newBackendTLS(BackendInsecureSkipVerify(), BackendClientCert(clientCert, clientKey)), true},
+		{"without client certificate", newBackendTLS(BackendCA(serverCert)), false},
+		{"system roots", newBackendTLS(BackendClientCert(clientCert, clientKey)), false},
+		{"wrong server name", newBackendTLS(BackendCA(serverCert), BackendServerName("other"), BackendClientCert(clientCert, clientKey)), false},
+		{"plaintext", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &Backend{name: DefaultBackend, tls: tt.tls}
+			opts, err := b.dialOptions(retrySettings{})
+			assert.NoError(t, err)
+
+			conn, err := grpc.NewClient("localhost:"+port, opts...)
+			assert.NoError(t, err)
+
+			defer func() {
+				_ = conn.Close()
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+			assert.Equal(t, tt.ok, err == nil, err)
+		})
+	}
+
+	b := &Backend{tls: newBackendTLS(BackendCA(filepath.Join(dir, "missing.crt")))}
+	_, err = b.dialOptions(retrySettings{})
+	assert.ErrorContains(t, err, "failed to read backend CA file")
+}
+
+func TestWithBackendInsecure(t *testing.T) {
+	o := newGatewayOption(
+		WithNamedBackend("users", "10.0.0.2", 9090),
+		WithNamedBackend("billing", "10.0.0.3", 9090, UseBackendTLS()),
+		WithNamedBackend("local", "127.0.0.1", 9090, UseBackendInsecure()),
+	)
+
+	assert.Equal(t, []string{"default", "users"}, o.plaintextBackends())
+
+	o = newGatewayOption(WithBackendInsecure())
+	assert.Empty(t, o.plaintextBackends())
+
+	// The last backend mode wins, so TLS after plaintext verifies the backend again
+	o = newGatewayOption(WithBackendInsecure(), WithBackendTLS())
+	assert.False(t, o.backends[DefaultBackend].insecure)
+	assert.NotNil(t, o.backendTLS(o.backends[DefaultBackend]))
+
+	// Without a backend mode the default backend of a TLS listener trusts its certificate
+	o = newGatewayOption(WithTLS("server.crt", "server.key"), WithNamedBackend("users", "10.0.0.2", 9090))
+	assert.Equal(t, []string{"server.crt"}, o.backendTLS(o.backends[DefaultBackend]).ca)
+	assert.True(t, o.trustsListener(o.backends[DefaultBackend]))
+	assert.False(t, o.trustsListener(o.backends["users"]))
+	assert.Equal(t, []string{"users"}, o.plaintextBackends())
+
+	o = newGatewayOption(WithTLS("server.crt", "server.key"), WithBackendInsecure())
+	assert.Nil(t, o.backendTLS(o.backends[DefaultBackend]))
+	assert.False(t, o.trustsListener(o.backends[DefaultBackend]))
+
+	_, err := BackendConfig{TLS: &BackendTLSConfig{}, Insecure: true}.option(DefaultBackend)
+	assert.ErrorContains(t, err, "both tls and insecure")
+}
@@ -0,0 +1,461 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BreakerPolicy configures the circuit breaker of a backend. The breaker opens when at least
+// FailureRatio of the calls counted in a Window fail, provided there were MinRequests of them.
+// While open, calls fail immediately with a CircuitOpenError. After OpenTimeout the breaker lets
+// HalfOpenProbes calls through and closes once they all succeed, or opens again on a failure.
+// Zero values take the defaults of DefaultBreakerPolicy.
+type BreakerPolicy struct {
+	FailureRatio   float64
+	MinRequests    int
+	Window         time.Duration
+	OpenTimeout    time.Duration
+	HalfOpenProbes int
+	// FailureCodes are the codes counted as failures.
+	FailureCodes []codes.Code
+	// PerMethod keeps a breaker for every gRPC method instead of one for the whole backend.
+	// Beyond 256 methods of a backend the further methods share the breaker of the whole backend,
+	// so that clients calling arbitrary method names cannot grow the breakers without bound.
+	PerMethod bool
+	// Result is the response written while the breaker is open, instead of 503 Service Unavailable.
+	Result *ErrorResult
+}
+
+// DefaultBreakerPolicy is the policy whose values fill in the zero fields of a BreakerPolicy.
+var DefaultBreakerPolicy = BreakerPolicy{
+	FailureRatio:   0.5,
+	MinRequests:    20,
+	Window:         10 * time.Second,
+	OpenTimeout:    30 * time.Second,
+	HalfOpenProbes: 1,
+	FailureCodes:   []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown},
+}
+
+// Circuit breaker states reported by BreakerStatus.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// CircuitOpenError is returned for the calls rejected by an open circuit breaker.
+type CircuitOpenError struct {
+	Backend string
+	Method  string
+	result  *ErrorResult
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of backend %s is open for %s", e.Backend, e.Method)
+}
+
+// GRPCStatus makes the error an Unavailable status for the error handlers.
+func (e *CircuitOpenError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, e.Error())
+}
+
+// BreakerStatus describes a circuit breaker on the status endpoint.
+type BreakerStatus struct {
+	Backend  string `json:"backend"`
+	Method   string `json:"method,omitempty"`
+	State    string `json:"state"`
+	Requests int    `json:"requests"`
+	Failures int    `json:"failures"`
+	OpenedAt string `json:"openedAt,omitempty"`
+}
+
+// WithCircuitBreaker is a GatewayOptionFunc that puts a circuit breaker in front of every backend.
+// A policy set with BackendCircuitBreaker takes precedence.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithCircuitBreaker(BreakerPolicy{
+//	        FailureRatio: 0.5,
+//	        MinRequests:  20,
+//	        OpenTimeout:  10 * time.Second,
+//	        PerMethod:    true,
+//	    }),
+//	)
+func WithCircuitBreaker(policy BreakerPolicy) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.breaker = &policy
+	}
+}
+
+// BackendCircuitBreaker puts a circuit breaker in front of the backend.
+func BackendCircuitBreaker(policy BreakerPolicy) BackendOption {
+	return func(b *Backend) {
+		b.breaker = &policy
+	}
+}
+
+// breakerOptions returns the interceptors of the circuit breaker of the backend, if any.
+// The breakers live in the gateway state, so that they keep their state across reloads.
+func (o *GatewayOption) breakerOptions(b *Backend) []grpc.DialOption {
+	policy := o.breaker
+
+	if b.breaker != nil {
+		policy = b.breaker
+	}
+
+	if policy == nil {
+		return nil
+	}
+
+	return interceptorOptions([]ClientInterceptor{o.state.breakers.interceptor(b.name, policy.withDefaults())})
+}
+
+func (p BreakerPolicy) withDefaults() BreakerPolicy {
+	d := DefaultBreakerPolicy
+
+	if p.FailureRatio <= 0 {
+		p.FailureRatio = d.FailureRatio
+	}
+
+	if p.MinRequests <= 0 {
+		p.MinRequests = d.MinRequests
+	}
+
+	if p.Window <= 0 {
+		p.Window = d.Window
+	}
+
+	if p.OpenTimeout <= 0 {
+		p.OpenTimeout = d.OpenTimeout
+	}
+
+	if p.HalfOpenProbes <= 0 {
+		p.HalfOpenProbes = d.HalfOpenProbes
+	}
+
+	if len(p.FailureCodes) == 0 {
+		p.FailureCodes = d.FailureCodes
+	}
+
+	return p
+}
+
+// canceled reports whether the call was given up by the caller. Unless counted as a failure, such
+// a call says nothing about the backend and is not recorded.
+func (p BreakerPolicy) canceled(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		err = status.FromContextError(err).Err()
+	}
+
+	return status.Code(err) == codes.Canceled && !p.failed(err)
+}
+
+func (p BreakerPolicy) failed(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	code := status.Code(err)
+
+	for _, c := range p.FailureCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+type breakerKey struct {
+	backend string
+	method  string
+}
+
+// maxMethodBreakers is the number of method breakers kept for a backend, see BreakerPolicy.PerMethod.
+const maxMethodBreakers = 256
+
+// breakerRegistry holds the circuit breakers of the gateway by backend and method.
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[breakerKey]*circuitBreaker
+	// methods counts the method breakers of every backend
+	methods map[string]int
+}
+
+// get returns the breaker for the key, applying policy to it. A method beyond maxMethodBreakers
+// gets the breaker of the whole backend.
+func (r *breakerRegistry) get(key breakerKey, policy BreakerPolicy) *circuitBreaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.breakers == nil {
+		r.breakers = make(map[breakerKey]*circuitBreaker)
+		r.methods = make(map[string]int)
+	}
+
+	b, ok := r.breakers[key]
+
+	if !ok && key.method != "" && r.methods[key.backend] >= maxMethodBreakers {
+		key.method = ""
+		b, ok = r.breakers[key]
+	}
+
+	if !ok {
+		b = &circuitBreaker{state: BreakerClosed}
+		r.breakers[key] = b
+
+		if key.method != "" {
+			r.methods[key.backend]++
+		}
+	}
+
+	b.mu.Lock()
+	b.policy = policy
+	b.mu.Unlock()
+
+	return b
+}
+
+func (r *breakerRegistry) interceptor(backend string, policy BreakerPolicy) ClientInterceptor {
+	breakerOf := func(method string) (*circuitBreaker, uint64, error) {
+		key := breakerKey{backend: backend}
+
+		if policy.PerMethod {
+			key.method = method
+		}
+
+		b := r.get(key, policy)
+		generation, ok := b.allow(time.Now())
+
+		if !ok {
+			return nil, 0, &CircuitOpenError{Backend: backend, Method: method, result: policy.Result}
+		}
+
+		return b, generation, nil
+	}
+
+	return ClientInterceptor{
+		Unary: func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			b, generation, err := breakerOf(method)
+
+			if err != nil {
+				return err
+			}
+
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			b.done(time.Now(), generation, err)
+
+			return err
+		},
+		Stream: func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			b, generation, err := breakerOf(method)
+
+			if err != nil {
+				return nil, err
+			}
+
+			stream, err := streamer(ctx, desc, cc, method, opts...)
+
+			if err != nil {
+				b.done(time.Now(), generation, err)
+				return nil, err
+			}
+
+			bs := &breakerStream{
+				ClientStream: stream,
+				single:       !desc.ServerStreams,
+				ended:        make(chan struct{}),
+				record: func(err error) {
+					b.done(time.Now(), generation, err)
+				},
+			}
+
+			// A stream given up by the caller ends with its context
+			go func() {
+				select {
+				case <-ctx.Done():
+					bs.end(status.FromContextError(ctx.Err()).Err())
+				case <-bs.ended:
+				}
+			}()
+
+			return bs, nil
+		},
+	}
+}
+
+// breakerStream records the result of a stream once it ends: at io.EOF, on the first error of
+// RecvMsg, or after the response of a stream without server streaming.
+type breakerStream struct {
+	grpc.ClientStream
+	single bool
+	once   sync.Once
+	ended  chan struct{}
+	record func(error)
+}
+
+func (s *breakerStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+
+	switch {
+	case errors.Is(err, io.EOF):
+		s.end(nil)
+	case err != nil:
+		s.end(err)
+	case s.single:
+		s.end(nil)
+	}
+
+	return err
+}
+
+func (s *breakerStream) end(err error) {
+	s.once.Do(func() {
+		close(s.ended)
+		s.record(err)
+	})
+}
+
+func (r *breakerRegistry) status() []BreakerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	values := make([]BreakerStatus, 0, len(r.breakers))
+
+	for key, b := range r.breakers {
+		values = append(values, b.status(key))
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Backend != values[j].Backend {
+			return values[i].Backend < values[j].Backend
+		}
+
+		return values[i].Method < values[j].Method
+	})
+
+	return values
+}
+
+type circuitBreaker struct {
+	mu       sync.Mutex
+	policy   BreakerPolicy
+	state    string
+	started  time.Time
+	requests int
+	failures int
+	openedAt time.Time
+	probes   int
+	// generation changes with every state change, so that the calls let through before it are not
+	// counted in the new state, such as calls of a closed breaker ending while it is half-open
+	generation uint64
+}
+
+// allow reports whether a call may go through, moving an open breaker to half-open once its
+// timeout has passed. It returns the generation the result of the call is recorded for.
+func (b *circuitBreaker) allow(now time.Time) (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.policy.OpenTimeout {
+			return 0, false
+		}
+
+		b.state = BreakerHalfOpen
+		b.generation++
+		b.probes = 0
+		b.requests, b.failures = 0, 0
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= b.policy.HalfOpenProbes {
+			return 0, false
+		}
+
+		b.probes++
+	default:
+		if now.Sub(b.started) >= b.policy.Window {
+			b.started = now
+			b.requests, b.failures = 0, 0
+		}
+	}
+
+	return b.generation, true
+}
+
+// done records the result of a call let through by allow in generation. Results of an earlier
+// generation are ignored, and a canceled call only hands its probe to the next call.
+func (b *circuitBreaker) done(now time.Time, generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+
+	if b.policy.canceled(err) {
+		if b.state == BreakerHalfOpen {
+			b.probes--
+		}
+
+		return
+	}
+
+	failed := b.policy.failed(err)
+	b.requests++
+
+	if failed {
+		b.failures++
+	}
+
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.open(now)
+		} else if b.requests-b.failures >= b.policy.HalfOpenProbes {
+			b.state = BreakerClosed
+			b.generation++
+			b.started = now
+			b.requests, b.failures = 0, 0
+		}
+	case BreakerClosed:
+		if failed && b.requests >= b.policy.MinRequests && float64(b.failures)/float64(b.requests) >= b.policy.FailureRatio {
+			b.open(now)
+		}
+	}
+}
+
+func (b *circuitBreaker) open(now time.Time) {
+	b.state = BreakerOpen
+	b.generation++
+	b.openedAt = now
+}
+
+func (b *circuitBreaker) status(key breakerKey) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := BreakerStatus{
+		Backend:  key.backend,
+		Method:   key.method,
+		State:    b.state,
+		Requests: b.requests,
+		Failures: b.failures,
+	}
+
+	if b.state != BreakerClosed {
+		s.OpenedAt = b.openedAt.Format(time.RFC3339)
+	}
+
+	return s
+}
@@ -0,0 +1,183 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	policy := BreakerPolicy{FailureRatio: 0.5, MinRequests: 4, Window: time.Minute, OpenTimeout: time.Second, HalfOpenProbes: 2}.withDefaults()
+	b := &circuitBreaker{policy: policy, state: BreakerClosed}
+	now := time.Now()
+	unavailable := status.Error(codes.Unavailable, "down")
+
+	call := func(at time.Time, err error) bool {
+		generation, ok := b.allow(at)
+
+		if !ok {
+			return false
+		}
+
+		b.done(at, generation, err)
+
+		return true
+	}
+
+	// Too few requests to trip
+	assert.True(t, call(now, unavailable))
+	assert.True(t, call(now, unavailable))
+	assert.True(t, call(now, nil))
+	assert.Equal(t, BreakerClosed, b.state)
+
+	slow, ok := b.allow(now)
+	assert.True(t, ok)
+
+	// 3 failures out of 4 requests
+	assert.True(t, call(now, unavailable))
+	assert.Equal(t, BreakerOpen, b.state)
+	assert.False(t, call(now.Add(500*time.Millisecond), nil))
+
+	// Half-open lets the probes through and opens again on a failure
+	assert.True(t, call(now.Add(time.Second), nil))
+	assert.Equal(t, BreakerHalfOpen, b.state)
+	assert.True(t, call(now.Add(time.Second), unavailable))
+	assert.Equal(t, BreakerOpen, b.state)
+
+	// Closes after all the probes succeeded, not counting a call let through before it opened
+	later := now.Add(3 * time.Second)
+	assert.True(t, call(later, nil))
+	b.done(later, slow, nil)
+	assert.Equal(t, BreakerHalfOpen, b.state)
+	assert.True(t, call(later, nil))
+	assert.Equal(t, BreakerClosed, b.state)
+
+	// Codes that are not failures do not count
+	assert.False(t, policy.failed(status.Error(codes.NotFound, "missing")))
+
+	// A canceled probe neither closes nor opens the breaker, and frees its probe
+	b.open(later)
+	reopened := later.Add(time.Second)
+	assert.True(t, call(reopened, status.Error(codes.Canceled, "gone")))
+	assert.True(t, call(reopened, context.Canceled))
+	assert.Equal(t, BreakerHalfOpen, b.state)
+	assert.True(t, call(reopened, nil))
+	assert.Equal(t, BreakerHalfOpen, b.state)
+	assert.True(t, call(reopened, nil))
+	assert.Equal(t, BreakerClosed, b.state)
+}
+
+func TestBreakerRegistry_Interceptor(t *testing.T) {
+	var registry breakerRegistry
+
+	result := &ErrorResult{Message: status.New(codes.Unavailable, "try later").Proto()}
+	result.HttpStatus(http.StatusTooManyRequests)
+
+	interceptor := registry.interceptor("users", BreakerPolicy{MinRequests: 1, PerMethod: true, Result: result}.withDefaults())
+	failing := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Error(codes.Unavailable, "down")
+	}
+
+	err := interceptor.Unary(context.Background(), "/users.UserService/GetUser", nil, nil, nil, failing)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	open := interceptor.Unary(context.Background(), "/users.UserService/GetUser", nil, nil, nil, failing)
+	assert.IsType(t, &CircuitOpenError{}, open)
+
+	// Other methods have their own breaker
+	err = interceptor.Unary(context.Background(), "/users.UserService/ListUsers", nil, nil, nil, failing)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.NotEqual(t, open.Error(), err.Error())
+
+	statuses := registry.status()
+
+	if assert.Len(t, statuses, 2) {
+		assert.Equal(t, "/users.UserService/GetUser", statuses[0].Method)
+		assert.Equal(t, BreakerOpen, statuses[0].State)
+	}
+
+	// The open error is answered with the configured result
+	mux := runtime.NewServeMux(errorCapture(newGatewayOption()))
+	w := httptest.NewRecorder()
+
+	runtime.HTTPError(context.Background(), mux, &runtime.JSONPb{}, w, httptest.NewRequest("GET", "/v1/users/1", nil), open)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "try later")
+}
+
+func TestBreakerRegistry_MethodLimit(t *testing.T) {
+	var registry breakerRegistry
+
+	policy := BreakerPolicy{PerMethod: true}.withDefaults()
+
+	for i := 0; i < maxMethodBreakers; i++ {
+		registry.get(breakerKey{"users", fmt.Sprintf("/users.UserService/Method%d", i)}, policy)
+	}
+
+	// Further methods share the breaker of the backend, while known methods keep their own
+	shared := registry.get(breakerKey{"users", "/users.UserService/Other"}, policy)
+	assert.Same(t, shared, registry.get(breakerKey{"users", "/users.UserService/Another"}, policy))
+	assert.Same(t, shared, registry.get(breakerKey{"users", ""}, policy))
+	assert.NotSame(t, shared, registry.get(breakerKey{"users", "/users.UserService/Method0"}, policy))
+	assert.Len(t, registry.status(), maxMethodBreakers+1)
+
+	// The limit is per backend
+	assert.NotSame(t, shared, registry.get(breakerKey{"billing", "/billing.BillingService/Charge"}, policy))
+}
+
+// testClientStream is a grpc.ClientStream answering RecvMsg with the errors in turn.
+type testClientStream struct {
+	grpc.ClientStream
+	errs []error
+}
+
+func (s *testClientStream) RecvMsg(_ interface{}) error {
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+
+	return err
+}
+
+func TestBreakerRegistry_Stream(t *testing.T) {
+	var registry breakerRegistry
+
+	interceptor := registry.interceptor("users", BreakerPolicy{MinRequests: 1}.withDefaults())
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	streamer := func(errs ...error) grpc.Streamer {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &testClientStream{errs: errs}, nil
+		}
+	}
+
+	// A stream failing after its first message opens the breaker once it fails
+	stream, err := interceptor.Stream(context.Background(), desc, nil, "/users.UserService/WatchUsers", streamer(nil, status.Error(codes.Unavailable, "down")))
+	assert.NoError(t, err)
+	assert.NoError(t, stream.RecvMsg(nil))
+	assert.Equal(t, BreakerClosed, registry.status()[0].State)
+	assert.Error(t, stream.RecvMsg(nil))
+	assert.Equal(t, BreakerOpen, registry.status()[0].State)
+
+	_, err = interceptor.Stream(context.Background(), desc, nil, "/users.UserService/WatchUsers", streamer(io.EOF))
+	assert.IsType(t, &CircuitOpenError{}, err)
+
+	// A stream given up by the caller is recorded with the end of its context
+	registry = breakerRegistry{}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	_, err = interceptor.Stream(ctx, desc, nil, "/users.UserService/WatchUsers", streamer())
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return registry.status()[0].State == BreakerOpen
+	}, time.Second, time.Millisecond)
+}
@@ -24,6 +24,14 @@ func (w gzipResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends the data compressed so far, so that the messages of a stream are not held back
+// until the response ends.
+func (w *gzipResponseWriter) Flush() {
+	if err := w.Writer.Flush(); err == nil {
+		flushResponse(w.ResponseWriter)
+	}
+}
+
 // GzipCompressHandler function
 //
 // GzipCompressHandler wraps an http.Handler with content compression middleware.
@@ -36,7 +44,8 @@ func (w gzipResponseWriter) Write(b []byte) (int, error) {
 // Finally, it calls ServeHTTP on the wrapped handler with the gzipResponseWriter and the original request.
 func GzipCompressHandler(h http.Handler, o *GatewayOption) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if ok := existContentEncoding(w); ok {
+		// gRPC compresses its messages itself
+		if ok := existContentEncoding(w); ok || isGRPC(r) {
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -72,7 +81,8 @@ func GzipCompressHandler(h http.Handler, o *GatewayOption) http.Handler {
 // Finally, it calls ServeHTTP on the wrapped handler with the brotliResponseWriter and the original request.
 func BrotliCompressHandler(h http.Handler, o *GatewayOption) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if ok := existContentEncoding(w); ok {
+		// gRPC compresses its messages itself
+		if ok := existContentEncoding(w); ok || isGRPC(r) {
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -98,7 +108,8 @@ func BrotliCompressHandler(h http.Handler, o *GatewayOption) http.Handler {
 
 func DeflateCompressHandler(h http.Handler, o *GatewayOption) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if ok := existContentEncoding(w); ok {
+		// gRPC compresses its messages itself
+		if ok := existContentEncoding(w); ok || isGRPC(r) {
 			h.ServeHTTP(w, r)
 			return
 		}
@@ -142,6 +153,13 @@ func (w *brotliResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends the data compressed so far, like gzipResponseWriter.Flush.
+func (w *brotliResponseWriter) Flush() {
+	if err := w.Writer.Flush(); err == nil {
+		flushResponse(w.ResponseWriter)
+	}
+}
+
 // deflateResponseWriter is a type that wraps an http.ResponseWriter
 // and a flate.Writer to provide deflate compression for the response.
 type deflateResponseWriter struct {
@@ -153,6 +171,18 @@ func (w *deflateResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Flush sends the data compressed so far, like gzipResponseWriter.Flush.
+func (w *deflateResponseWriter) Flush() {
+	if err := w.Writer.Flush(); err == nil {
+		flushResponse(w.ResponseWriter)
+	}
+}
+
+// flushResponse flushes w if it, or a writer it wraps, supports flushing.
+func flushResponse(w http.ResponseWriter) {
+	_ = http.NewResponseController(w).Flush()
+}
+
 func chunkValues(value string) []string {
 	var values []string
 
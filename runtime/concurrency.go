@@ -0,0 +1,293 @@
+package runtime
+
+import (
+	"container/list"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	goruntime "runtime"
+	"runtime/metrics"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConcurrencyLimit caps the requests served at once. Requests over MaxInFlight wait up to
+// QueueTimeout in a queue of MaxQueue entries, which defaults to MaxInFlight, and are shed with
+// 503 Service Unavailable when the queue is full or the wait times out. Without QueueTimeout they
+// are shed at once. Independently of the in-flight count, requests are shed while the process
+// runs more than MaxGoroutines goroutines or holds more than MaxHeapBytes of heap.
+//
+// With TargetLatency set the limit adapts between MinInFlight and MaxInFlight: it grows by one
+// for every request completing within the target and shrinks by a tenth when one takes longer.
+type ConcurrencyLimit struct {
+	MaxInFlight   int
+	MaxQueue      int
+	QueueTimeout  time.Duration
+	MaxGoroutines int
+	MaxHeapBytes  uint64
+	MinInFlight   int
+	TargetLatency time.Duration
+}
+
+type ConcurrencyOption func(*concurrencyLimiter)
+
+type concurrencyRoute struct {
+	method  string
+	pattern string
+	limiter *inFlightLimiter
+}
+
+type concurrencyLimiter struct {
+	limit  ConcurrencyLimit
+	global *inFlightLimiter
+	routes []concurrencyRoute
+	heap   heapSampler
+}
+
+// WithConcurrencyLimit is a GatewayOptionFunc that adds ConcurrencyLimitHandler to the handler chain.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithConcurrencyLimit(ConcurrencyLimit{
+//	        MaxInFlight:   512,
+//	        QueueTimeout:  50 * time.Millisecond,
+//	        MaxHeapBytes:  1 << 30,
+//	    }, ConcurrencyRoute("POST", "/v1/reports", 8)),
+//	)
+func WithConcurrencyLimit(limit ConcurrencyLimit, opts ...ConcurrencyOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.handlers = append(opt.handlers, ConcurrencyLimitHandler(limit, opts...))
+	}
+}
+
+// ConcurrencyLimitHandler returns a GatewayHandler enforcing limit, see ConcurrencyLimit.
+// Shed requests are answered with Unavailable through the error handler of the gateway.
+// The limiter is shared by the generations built with the handler, so that the requests in
+// flight across a Reload are counted together. The concurrency section of a configuration file
+// builds a new handler on every reload instead, whose limiter starts counting from zero.
+func ConcurrencyLimitHandler(limit ConcurrencyLimit, opts ...ConcurrencyOption) GatewayHandler {
+	l := &concurrencyLimiter{limit: limit}
+
+	if limit.MaxInFlight > 0 {
+		l.global = newInFlightLimiter(limit.MaxInFlight, limit)
+	}
+
+	for _, o := range opts {
+		o(l)
+	}
+
+	return func(h http.Handler, _ *GatewayOption) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if l.overloaded() {
+				shed(w, r)
+				return
+			}
+
+			limiters := make([]*inFlightLimiter, 0, 2)
+
+			if route := l.route(r); route != nil {
+				limiters = append(limiters, route)
+			}
+
+			if l.global != nil {
+				limiters = append(limiters, l.global)
+			}
+
+			for i, limiter := range limiters {
+				if !limiter.acquire(r.Context()) {
+					for _, acquired := range limiters[:i] {
+						acquired.release(0)
+					}
+
+					shed(w, r)
+					return
+				}
+			}
+
+			start := time.Now()
+			defer func() {
+				elapsed := time.Since(start)
+
+				for _, limiter := range limiters {
+					limiter.release(elapsed)
+				}
+			}()
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ConcurrencyRoute caps the requests in flight matching the HTTP method and path pattern, with the
+// pattern syntax of WithRouteTimeout. They count against the global limit as well. A maxInFlight
+// of zero or less leaves the route without a cap of its own.
+func ConcurrencyRoute(method, pattern string, maxInFlight int) ConcurrencyOption {
+	return func(l *concurrencyLimiter) {
+		if maxInFlight <= 0 {
+			return
+		}
+
+		limit := l.limit
+		limit.MinInFlight = 0
+		limit.TargetLatency = 0
+
+		l.routes = append(l.routes, concurrencyRoute{strings.ToUpper(method), pattern, newInFlightLimiter(maxInFlight, limit)})
+	}
+}
+
+func (l *concurrencyLimiter) route(r *http.Request) *inFlightLimiter {
+	for _, route := range l.routes {
+		if route.method != "" && route.method != "*" && route.method != r.Method {
+			continue
+		}
+
+		if matchPath(route.pattern, r.URL.Path) {
+			return route.limiter
+		}
+	}
+
+	return nil
+}
+
+// overloaded reports whether the goroutine count or the heap usage exceeds its threshold.
+func (l *concurrencyLimiter) overloaded() bool {
+	if l.limit.MaxGoroutines > 0 && goruntime.NumGoroutine() > l.limit.MaxGoroutines {
+		return true
+	}
+
+	return l.limit.MaxHeapBytes > 0 && l.heap.bytes() > l.limit.MaxHeapBytes
+}
+
+func shed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(RetryAfterHeader, "1")
+	httpError(w, r, status.Error(codes.Unavailable, "server overloaded"))
+}
+
+// inFlightLimiter is a semaphore whose waiters queue in arrival order.
+type inFlightLimiter struct {
+	mu       sync.Mutex
+	limit    int
+	inFlight int
+	queue    list.List
+	config   ConcurrencyLimit
+}
+
+func newInFlightLimiter(limit int, config ConcurrencyLimit) *inFlightLimiter {
+	return &inFlightLimiter{limit: limit, config: config}
+}
+
+// acquire takes a slot, waiting in the queue up to the queue timeout when none is free.
+func (l *inFlightLimiter) acquire(ctx context.Context) bool {
+	l.mu.Lock()
+
+	if l.inFlight < l.limit {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+
+	if l.config.QueueTimeout <= 0 || l.queue.Len() >= l.maxQueue() {
+		l.mu.Unlock()
+		return false
+	}
+
+	ready := make(chan struct{})
+	waiter := l.queue.PushBack(ready)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.config.QueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ready:
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-ready:
+		// The slot was handed over while timing out
+		return true
+	default:
+		l.queue.Remove(waiter)
+		return false
+	}
+}
+
+func (l *inFlightLimiter) maxQueue() int {
+	if l.config.MaxQueue > 0 {
+		return l.config.MaxQueue
+	}
+
+	return l.limit
+}
+
+// release frees the slot of a request that took elapsed, handing the free slots to the waiters,
+// more than one when the adaptive limit grew.
+func (l *inFlightLimiter) release(elapsed time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.adapt(elapsed)
+	l.inFlight--
+
+	for l.inFlight < l.limit {
+		front := l.queue.Front()
+
+		if front == nil {
+			break
+		}
+
+		close(l.queue.Remove(front).(chan struct{}))
+		l.inFlight++
+	}
+}
+
+// adapt moves the limit towards the target latency, additive increase and multiplicative decrease.
+func (l *inFlightLimiter) adapt(elapsed time.Duration) {
+	c := l.config
+
+	if c.TargetLatency <= 0 || elapsed <= 0 {
+		return
+	}
+
+	if elapsed > c.TargetLatency {
+		l.limit = max(max(c.MinInFlight, 1), l.limit*9/10)
+	} else if l.limit < c.MaxInFlight {
+		l.limit++
+	}
+}
+
+// heapSampler reads the heap usage at most every 100ms, since reading it on every request
+// would cost more than the requests themselves.
+type heapSampler struct {
+	mu      sync.Mutex
+	value   atomic.Uint64
+	sampled atomic.Int64
+}
+
+func (s *heapSampler) bytes() uint64 {
+	now := time.Now().UnixNano()
+
+	if now-s.sampled.Load() > int64(100*time.Millisecond) && s.mu.TryLock() {
+		sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+		metrics.Read(sample)
+
+		if sample[0].Value.Kind() == metrics.KindUint64 {
+			s.value.Store(sample[0].Value.Uint64())
+		}
+
+		s.sampled.Store(now)
+		s.mu.Unlock()
+	}
+
+	return s.value.Load()
+}
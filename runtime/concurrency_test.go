@@ -0,0 +1,155 @@
+package runtime
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInFlightLimiter(t *testing.T) {
+	l := newInFlightLimiter(1, ConcurrencyLimit{MaxQueue: 1, QueueTimeout: time.Second})
+	ctx := context.Background()
+
+	assert.True(t, l.acquire(ctx))
+
+	// The waiter gets the slot once it is released
+	acquired := make(chan bool)
+
+	go func() {
+		acquired <- l.acquire(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return l.queue.Len() == 1
+	}, time.Second, time.Millisecond)
+
+	// The queue is full
+	assert.False(t, l.acquire(ctx))
+
+	l.release(0)
+	assert.True(t, <-acquired)
+
+	// Times out in the queue
+	l.config.QueueTimeout = 10 * time.Millisecond
+	assert.False(t, l.acquire(ctx))
+
+	l.release(0)
+	assert.Equal(t, 0, l.inFlight)
+	assert.Equal(t, 0, l.queue.Len())
+}
+
+func TestInFlightLimiter_Adapt(t *testing.T) {
+	l := newInFlightLimiter(10, ConcurrencyLimit{MaxInFlight: 12, MinInFlight: 5, TargetLatency: 100 * time.Millisecond})
+
+	l.adapt(50 * time.Millisecond)
+	l.adapt(50 * time.Millisecond)
+	l.adapt(50 * time.Millisecond)
+	assert.Equal(t, 12, l.limit)
+
+	for i := 0; i < 10; i++ {
+		l.adapt(time.Second)
+	}
+
+	assert.Equal(t, 5, l.limit)
+}
+
+func TestInFlightLimiter_AdaptWakesWaiters(t *testing.T) {
+	l := newInFlightLimiter(1, ConcurrencyLimit{MaxInFlight: 3, MaxQueue: 2, QueueTimeout: time.Second, TargetLatency: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	assert.True(t, l.acquire(ctx))
+
+	acquired := make(chan bool, 2)
+
+	for i := 0; i < 2; i++ {
+		go func() {
+			acquired <- l.acquire(ctx)
+		}()
+	}
+
+	assert.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		return l.queue.Len() == 2
+	}, time.Second, time.Millisecond)
+
+	// A fast request raises the limit to two, so both waiters get a slot
+	l.release(50 * time.Millisecond)
+	assert.True(t, <-acquired)
+	assert.True(t, <-acquired)
+	assert.Equal(t, 2, l.inFlight)
+	assert.Equal(t, 0, l.queue.Len())
+}
+
+func TestConcurrencyLimitHandler(t *testing.T) {
+	block := make(chan struct{})
+	started := make(chan struct{}, 4)
+
+	handler := ConcurrencyLimitHandler(ConcurrencyLimit{MaxInFlight: 2},
+		ConcurrencyRoute("POST", "/v1/reports", 1),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-block
+	}), newGatewayOption())
+
+	serve := func(method, path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+		return w.Code
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusOK, serve("POST", "/v1/reports"))
+	}()
+
+	<-started
+
+	// The route is at its limit, other routes are not
+	assert.Equal(t, http.StatusServiceUnavailable, serve("POST", "/v1/reports"))
+
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusOK, serve("GET", "/v1/users"))
+	}()
+
+	<-started
+
+	// The global limit is reached
+	assert.Equal(t, http.StatusServiceUnavailable, serve("GET", "/v1/users"))
+
+	close(block)
+	wg.Wait()
+
+	// Shed by the goroutine threshold
+	handler = ConcurrencyLimitHandler(ConcurrencyLimit{MaxGoroutines: 1})(http.NotFoundHandler(), newGatewayOption())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/users", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get(RetryAfterHeader))
+
+	// A route without a positive cap is not limited on its own
+	handler = ConcurrencyLimitHandler(ConcurrencyLimit{}, ConcurrencyRoute("GET", "/v1/users", 0))(http.NotFoundHandler(), newGatewayOption())
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/users", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	_, err := (&ConcurrencyConfig{Routes: []ConcurrencyRouteConfig{{Method: "GET", Path: "/v1/users"}}}).handler()
+	assert.ErrorContains(t, err, "max_in_flight must be above 0")
+}
@@ -0,0 +1,1576 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/gorilla/handlers"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcencoding "google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConfigEnvPrefix is the prefix of the environment variables that override values read from a
+// configuration file. The variable name is built from the json keys of the Config fields,
+// e.g. GATEWAY_SERVER_PORT or GATEWAY_LOG_ACCESS.
+const ConfigEnvPrefix = "GATEWAY"
+
+// Config is the declarative form of the options set by the With* functions.
+// It can be read from a YAML, JSON or TOML file with LoadConfig.
+//
+// Example (YAML):
+//
+//	server:
+//	  host: 0.0.0.0
+//	  port: 8081
+//	backend:
+//	  host: 127.0.0.1
+//	  port: 8080
+//	backends:
+//	  users:
+//	    dns: {name: users.internal, port: 9090, interval: 30s}
+//	    balancer: least_request
+//	log:
+//	  access: access.log
+//	  error: error.log
+//	metadata:
+//	  - action: pass
+//	    type: request
+//	    keys: [Cookie]
+//	health_check_path: /ping/heartbeat
+//	status_path: /ping/status
+//	handlers: [common_log, gzip, brotli]
+type Config struct {
+	Server          ServerConfig             `json:"server" yaml:"server" toml:"server"`
+	Backend         BackendConfig            `json:"backend" yaml:"backend" toml:"backend"`
+	Backends        map[string]BackendConfig `json:"backends,omitempty" yaml:"backends,omitempty" toml:"backends,omitempty"`
+	Dial            *DialConfig              `json:"dial,omitempty" yaml:"dial,omitempty" toml:"dial,omitempty"`
+	Timeouts        *TimeoutConfig           `json:"timeouts,omitempty" yaml:"timeouts,omitempty" toml:"timeouts,omitempty"`
+	Retry           *RetryConfig             `json:"retry,omitempty" yaml:"retry,omitempty" toml:"retry,omitempty"`
+	CircuitBreaker  *BreakerConfig           `json:"circuit_breaker,omitempty" yaml:"circuit_breaker,omitempty" toml:"circuit_breaker,omitempty"`
+	RateLimit       *RateLimitConfig         `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty" toml:"rate_limit,omitempty"`
+	JWT             *JWTConfig               `json:"jwt,omitempty" yaml:"jwt,omitempty" toml:"jwt,omitempty"`
+	APIKey          *APIKeyConfig            `json:"api_key,omitempty" yaml:"api_key,omitempty" toml:"api_key,omitempty"`
+	Authorization   *AuthorizationConfig     `json:"authorization,omitempty" yaml:"authorization,omitempty" toml:"authorization,omitempty"`
+	OpenAPI         *OpenAPIConfig           `json:"openapi,omitempty" yaml:"openapi,omitempty" toml:"openapi,omitempty"`
+	DescriptorSets  []DescriptorSetConfig    `json:"descriptor_sets,omitempty" yaml:"descriptor_sets,omitempty" toml:"descriptor_sets,omitempty"`
+	Reflection      []ReflectionConfig       `json:"reflection,omitempty" yaml:"reflection,omitempty" toml:"reflection,omitempty"`
+	RPCProxy        *RPCProxyConfig          `json:"rpc_proxy,omitempty" yaml:"rpc_proxy,omitempty" toml:"rpc_proxy,omitempty"`
+	StreamFormats   *StreamFormatsConfig     `json:"stream_formats,omitempty" yaml:"stream_formats,omitempty" toml:"stream_formats,omitempty"`
+	Concurrency     *ConcurrencyConfig       `json:"concurrency,omitempty" yaml:"concurrency,omitempty" toml:"concurrency,omitempty"`
+	TLS             *TLSConfig               `json:"tls,omitempty" yaml:"tls,omitempty" toml:"tls,omitempty"`
+	Log             LogConfig                `json:"log" yaml:"log" toml:"log"`
+	CORS            *CORSConfig              `json:"cors,omitempty" yaml:"cors,omitempty" toml:"cors,omitempty"`
+	Metadata        []MetadataConfig         `json:"metadata,omitempty" yaml:"metadata,omitempty" toml:"metadata,omitempty"`
+	HealthCheckPath string                   `json:"health_check_path,omitempty" yaml:"health_check_path,omitempty" toml:"health_check_path,omitempty"`
+	StatusPath      string                   `json:"status_path,omitempty" yaml:"status_path,omitempty" toml:"status_path,omitempty"`
+	Handlers        []string                 `json:"handlers,omitempty" yaml:"handlers,omitempty" toml:"handlers,omitempty"`
+	Silent          bool                     `json:"silent,omitempty" yaml:"silent,omitempty" toml:"silent,omitempty"`
+	ShutdownTimeout Duration                 `json:"shutdown_timeout,omitempty" yaml:"shutdown_timeout,omitempty" toml:"shutdown_timeout,omitempty"`
+	Watch           bool                     `json:"watch,omitempty" yaml:"watch,omitempty" toml:"watch,omitempty"`
+	H2C             bool                     `json:"h2c,omitempty" yaml:"h2c,omitempty" toml:"h2c,omitempty"`
+	ServerLimits    *ServerLimitsConfig      `json:"server_limits,omitempty" yaml:"server_limits,omitempty" toml:"server_limits,omitempty"`
+}
+
+// ServerConfig is the configuration form of ServerInfo. Zero values keep the defaults.
+type ServerConfig struct {
+	Host string `json:"host,omitempty" yaml:"host,omitempty" toml:"host,omitempty"`
+	Port uint   `json:"port,omitempty" yaml:"port,omitempty" toml:"port,omitempty"`
+}
+
+// ServerLimitsConfig is the configuration form of ServerLimits.
+type ServerLimitsConfig struct {
+	ReadTimeout          Duration `json:"read_timeout,omitempty" yaml:"read_timeout,omitempty" toml:"read_timeout,omitempty"`
+	ReadHeaderTimeout    Duration `json:"read_header_timeout,omitempty" yaml:"read_header_timeout,omitempty" toml:"read_header_timeout,omitempty"`
+	WriteTimeout         Duration `json:"write_timeout,omitempty" yaml:"write_timeout,omitempty" toml:"write_timeout,omitempty"`
+	IdleTimeout          Duration `json:"idle_timeout,omitempty" yaml:"idle_timeout,omitempty" toml:"idle_timeout,omitempty"`
+	MaxHeaderBytes       int      `json:"max_header_bytes,omitempty" yaml:"max_header_bytes,omitempty" toml:"max_header_bytes,omitempty"`
+	MaxConcurrentStreams uint32   `json:"max_concurrent_streams,omitempty" yaml:"max_concurrent_streams,omitempty" toml:"max_concurrent_streams,omitempty"`
+}
+
+func (c *ServerLimitsConfig) limits() ServerLimits {
+	return ServerLimits{
+		ReadTimeout:          time.Duration(c.ReadTimeout),
+		ReadHeaderTimeout:    time.Duration(c.ReadHeaderTimeout),
+		WriteTimeout:         time.Duration(c.WriteTimeout),
+		IdleTimeout:          time.Duration(c.IdleTimeout),
+		MaxHeaderBytes:       c.MaxHeaderBytes,
+		MaxConcurrentStreams: c.MaxConcurrentStreams,
+	}
+}
+
+// CertificateConfig is an additional certificate selected by SNI, see Certificate.
+type CertificateConfig struct {
+	Cert string `json:"cert" yaml:"cert" toml:"cert"`
+	Key  string `json:"key" yaml:"key" toml:"key"`
+}
+
+// BackendConfig is the configuration of a backend gRPC server. The backend section configures
+// DefaultBackend, the entries of the backends section the backends named by their keys.
+//
+// Addresses, DNS and TargetFile are alternative address sources replacing Host and Port, see
+// BackendAddresses, BackendDNS and BackendTargetFile. Balancer takes the names accepted by
+// ParseBalancingPolicy.
+type BackendConfig struct {
+	Host       string            `json:"host,omitempty" yaml:"host,omitempty" toml:"host,omitempty"`
+	Port       uint              `json:"port,omitempty" yaml:"port,omitempty" toml:"port,omitempty"`
+	TLS        *BackendTLSConfig `json:"tls,omitempty" yaml:"tls,omitempty" toml:"tls,omitempty"`
+	Insecure   bool              `json:"insecure,omitempty" yaml:"insecure,omitempty" toml:"insecure,omitempty"`
+	Addresses  []string          `json:"addresses,omitempty" yaml:"addresses,omitempty" toml:"addresses,omitempty"`
+	DNS        *DNSConfig        `json:"dns,omitempty" yaml:"dns,omitempty" toml:"dns,omitempty"`
+	TargetFile string            `json:"target_file,omitempty" yaml:"target_file,omitempty" toml:"target_file,omitempty"`
+	Balancer   string            `json:"balancer,omitempty" yaml:"balancer,omitempty" toml:"balancer,omitempty"`
+	Weights    map[string]uint32 `json:"weights,omitempty" yaml:"weights,omitempty" toml:"weights,omitempty"`
+	Dial       *DialConfig       `json:"dial,omitempty" yaml:"dial,omitempty" toml:"dial,omitempty"`
+	Retry      *RetryConfig      `json:"retry,omitempty" yaml:"retry,omitempty" toml:"retry,omitempty"`
+	Breaker    *BreakerConfig    `json:"circuit_breaker,omitempty" yaml:"circuit_breaker,omitempty" toml:"circuit_breaker,omitempty"`
+}
+
+// DialConfig is the configuration form of the common WithDialOptions values. At the top level it
+// applies to every backend, in a backend section to that backend only.
+// Compression names a registered gRPC compressor such as "gzip".
+type DialConfig struct {
+	Keepalive      *KeepaliveConfig `json:"keepalive,omitempty" yaml:"keepalive,omitempty" toml:"keepalive,omitempty"`
+	MaxRecvMsgSize int              `json:"max_recv_msg_size,omitempty" yaml:"max_recv_msg_size,omitempty" toml:"max_recv_msg_size,omitempty"`
+	MaxSendMsgSize int              `json:"max_send_msg_size,omitempty" yaml:"max_send_msg_size,omitempty" toml:"max_send_msg_size,omitempty"`
+	Compression    string           `json:"compression,omitempty" yaml:"compression,omitempty" toml:"compression,omitempty"`
+}
+
+// KeepaliveConfig is the configuration form of keepalive.ClientParameters.
+type KeepaliveConfig struct {
+	Time                Duration `json:"time,omitempty" yaml:"time,omitempty" toml:"time,omitempty"`
+	Timeout             Duration `json:"timeout,omitempty" yaml:"timeout,omitempty" toml:"timeout,omitempty"`
+	PermitWithoutStream bool     `json:"permit_without_stream,omitempty" yaml:"permit_without_stream,omitempty" toml:"permit_without_stream,omitempty"`
+}
+
+// RetryConfig is the configuration form of WithRetryPolicy and WithRetryBudget, or of
+// BackendRetryPolicy and BackendRetryBudget in a backend section.
+type RetryConfig struct {
+	Policies []RetryPolicyConfig `json:"policies,omitempty" yaml:"policies,omitempty" toml:"policies,omitempty"`
+	Budget   *RetryBudgetConfig  `json:"budget,omitempty" yaml:"budget,omitempty" toml:"budget,omitempty"`
+}
+
+// RetryPolicyConfig is the configuration form of RetryPolicy. RetryableCodes takes gRPC code
+// names such as "UNAVAILABLE".
+type RetryPolicyConfig struct {
+	MaxAttempts       int      `json:"max_attempts,omitempty" yaml:"max_attempts,omitempty" toml:"max_attempts,omitempty"`
+	InitialBackoff    Duration `json:"initial_backoff,omitempty" yaml:"initial_backoff,omitempty" toml:"initial_backoff,omitempty"`
+	MaxBackoff        Duration `json:"max_backoff,omitempty" yaml:"max_backoff,omitempty" toml:"max_backoff,omitempty"`
+	BackoffMultiplier float64  `json:"backoff_multiplier,omitempty" yaml:"backoff_multiplier,omitempty" toml:"backoff_multiplier,omitempty"`
+	RetryableCodes    []string `json:"retryable_codes,omitempty" yaml:"retryable_codes,omitempty" toml:"retryable_codes,omitempty"`
+	Methods           []string `json:"methods,omitempty" yaml:"methods,omitempty" toml:"methods,omitempty"`
+}
+
+// RetryBudgetConfig is the configuration form of RetryBudget.
+type RetryBudgetConfig struct {
+	MaxTokens  float64 `json:"max_tokens" yaml:"max_tokens" toml:"max_tokens"`
+	TokenRatio float64 `json:"token_ratio" yaml:"token_ratio" toml:"token_ratio"`
+}
+
+// BreakerConfig is the configuration form of BreakerPolicy. FailureCodes takes gRPC code names
+// such as "UNAVAILABLE". Status and Message replace the 503 response written while the breaker is open.
+type BreakerConfig struct {
+	FailureRatio   float64  `json:"failure_ratio,omitempty" yaml:"failure_ratio,omitempty" toml:"failure_ratio,omitempty"`
+	MinRequests    int      `json:"min_requests,omitempty" yaml:"min_requests,omitempty" toml:"min_requests,omitempty"`
+	Window         Duration `json:"window,omitempty" yaml:"window,omitempty" toml:"window,omitempty"`
+	OpenTimeout    Duration `json:"open_timeout,omitempty" yaml:"open_timeout,omitempty" toml:"open_timeout,omitempty"`
+	HalfOpenProbes int      `json:"half_open_probes,omitempty" yaml:"half_open_probes,omitempty" toml:"half_open_probes,omitempty"`
+	FailureCodes   []string `json:"failure_codes,omitempty" yaml:"failure_codes,omitempty" toml:"failure_codes,omitempty"`
+	PerMethod      bool     `json:"per_method,omitempty" yaml:"per_method,omitempty" toml:"per_method,omitempty"`
+	Status         int      `json:"status,omitempty" yaml:"status,omitempty" toml:"status,omitempty"`
+	Message        string   `json:"message,omitempty" yaml:"message,omitempty" toml:"message,omitempty"`
+}
+
+// RateLimitConfig is the configuration form of RateLimitHandler. Key is one of "ip", "subject",
+// "api_key:<header>" or "header:<header>", and defaults to "ip"; the requests lacking the subject,
+// API key or header are keyed by their IP address. Algorithm takes "token_bucket" or
+// "sliding_window". The handler and its memory store are built again on every reload, so that the
+// requests are counted from zero afterwards.
+type RateLimitConfig struct {
+	Requests  int                    `json:"requests" yaml:"requests" toml:"requests"`
+	Period    Duration               `json:"period" yaml:"period" toml:"period"`
+	Burst     int                    `json:"burst,omitempty" yaml:"burst,omitempty" toml:"burst,omitempty"`
+	Algorithm string                 `json:"algorithm,omitempty" yaml:"algorithm,omitempty" toml:"algorithm,omitempty"`
+	Key       string                 `json:"key,omitempty" yaml:"key,omitempty" toml:"key,omitempty"`
+	Routes    []RateLimitRouteConfig `json:"routes,omitempty" yaml:"routes,omitempty" toml:"routes,omitempty"`
+}
+
+// RateLimitRouteConfig is the limit of the requests matching an HTTP method and path pattern.
+type RateLimitRouteConfig struct {
+	Method    string   `json:"method,omitempty" yaml:"method,omitempty" toml:"method,omitempty"`
+	Path      string   `json:"path" yaml:"path" toml:"path"`
+	Requests  int      `json:"requests" yaml:"requests" toml:"requests"`
+	Period    Duration `json:"period" yaml:"period" toml:"period"`
+	Burst     int      `json:"burst,omitempty" yaml:"burst,omitempty" toml:"burst,omitempty"`
+	Algorithm string   `json:"algorithm,omitempty" yaml:"algorithm,omitempty" toml:"algorithm,omitempty"`
+}
+
+// JWTConfig is the configuration form of JWTAuthHandler. One of JWKSFile and JWKSURL is required.
+// Claims maps claim names to the metadata keys they are forwarded as. The health check path is
+// always exempt.
+type JWTConfig struct {
+	JWKSFile    string            `json:"jwks_file,omitempty" yaml:"jwks_file,omitempty" toml:"jwks_file,omitempty"`
+	JWKSURL     string            `json:"jwks_url,omitempty" yaml:"jwks_url,omitempty" toml:"jwks_url,omitempty"`
+	Refresh     Duration          `json:"refresh,omitempty" yaml:"refresh,omitempty" toml:"refresh,omitempty"`
+	Issuers     []string          `json:"issuers,omitempty" yaml:"issuers,omitempty" toml:"issuers,omitempty"`
+	Audiences   []string          `json:"audiences,omitempty" yaml:"audiences,omitempty" toml:"audiences,omitempty"`
+	ClockSkew   Duration          `json:"clock_skew,omitempty" yaml:"clock_skew,omitempty" toml:"clock_skew,omitempty"`
+	Algorithms  []string          `json:"algorithms,omitempty" yaml:"algorithms,omitempty" toml:"algorithms,omitempty"`
+	ExemptPaths []string          `json:"exempt_paths,omitempty" yaml:"exempt_paths,omitempty" toml:"exempt_paths,omitempty"`
+	Claims      map[string]string `json:"claims,omitempty" yaml:"claims,omitempty" toml:"claims,omitempty"`
+}
+
+// APIKeyConfig is the configuration form of APIKeyAuthHandler with a NewFileKeyStore of KeysFile.
+// The key is read from the first of Header, Query and Cookie that are set, the X-API-Key header by
+// default. The health check path is always exempt.
+type APIKeyConfig struct {
+	KeysFile    string   `json:"keys_file" yaml:"keys_file" toml:"keys_file"`
+	Header      string   `json:"header,omitempty" yaml:"header,omitempty" toml:"header,omitempty"`
+	Query       string   `json:"query,omitempty" yaml:"query,omitempty" toml:"query,omitempty"`
+	Cookie      string   `json:"cookie,omitempty" yaml:"cookie,omitempty" toml:"cookie,omitempty"`
+	ExemptPaths []string `json:"exempt_paths,omitempty" yaml:"exempt_paths,omitempty" toml:"exempt_paths,omitempty"`
+	Optional    bool     `json:"optional,omitempty" yaml:"optional,omitempty" toml:"optional,omitempty"`
+}
+
+// AuthorizationConfig is the configuration form of WithAuthorization. Default is "allow", the
+// default, or "deny".
+//
+// Example (YAML):
+//
+//	authorization:
+//	  default: deny
+//	  rules:
+//	    - method: /users.UserService/GetUser
+//	      scopes: [users.read]
+//	    - http_method: GET
+//	      path: /v1/public/**
+//	      public: true
+type AuthorizationConfig struct {
+	Default string                    `json:"default,omitempty" yaml:"default,omitempty" toml:"default,omitempty"`
+	Rules   []AuthorizationRuleConfig `json:"rules,omitempty" yaml:"rules,omitempty" toml:"rules,omitempty"`
+}
+
+// AuthorizationRuleConfig is the configuration form of AuthorizationRule.
+type AuthorizationRuleConfig struct {
+	Method     string   `json:"method,omitempty" yaml:"method,omitempty" toml:"method,omitempty"`
+	HTTPMethod string   `json:"http_method,omitempty" yaml:"http_method,omitempty" toml:"http_method,omitempty"`
+	Path       string   `json:"path,omitempty" yaml:"path,omitempty" toml:"path,omitempty"`
+	Scopes     []string `json:"scopes,omitempty" yaml:"scopes,omitempty" toml:"scopes,omitempty"`
+	Roles      []string `json:"roles,omitempty" yaml:"roles,omitempty" toml:"roles,omitempty"`
+	Public     bool     `json:"public,omitempty" yaml:"public,omitempty" toml:"public,omitempty"`
+}
+
+// DescriptorSetConfig is the configuration form of WithBackendDescriptorSet. Backend names one of
+// the backends and defaults to the default backend.
+type DescriptorSetConfig struct {
+	Path     string   `json:"path" yaml:"path" toml:"path"`
+	Backend  string   `json:"backend,omitempty" yaml:"backend,omitempty" toml:"backend,omitempty"`
+	Services []string `json:"services,omitempty" yaml:"services,omitempty" toml:"services,omitempty"`
+}
+
+// ReflectionConfig is the configuration form of WithBackendReflection. Backend names one of the
+// backends and defaults to the default backend; without Refresh the services are read once.
+type ReflectionConfig struct {
+	Backend  string   `json:"backend,omitempty" yaml:"backend,omitempty" toml:"backend,omitempty"`
+	Refresh  Duration `json:"refresh,omitempty" yaml:"refresh,omitempty" toml:"refresh,omitempty"`
+	Services []string `json:"services,omitempty" yaml:"services,omitempty" toml:"services,omitempty"`
+}
+
+// RPCProxyConfig is the configuration form of WithGRPCWeb and WithConnect. Methods lists the
+// callable methods, see RPCProxyMethods, and Backends routes methods to named backends.
+type RPCProxyConfig struct {
+	GRPCWeb  bool                    `json:"grpc_web,omitempty" yaml:"grpc_web,omitempty" toml:"grpc_web,omitempty"`
+	Connect  bool                    `json:"connect,omitempty" yaml:"connect,omitempty" toml:"connect,omitempty"`
+	Methods  []string                `json:"methods,omitempty" yaml:"methods,omitempty" toml:"methods,omitempty"`
+	Backends []RPCProxyBackendConfig `json:"backends,omitempty" yaml:"backends,omitempty" toml:"backends,omitempty"`
+}
+
+// StreamFormatsConfig is the configuration form of WithStreamFormats.
+type StreamFormatsConfig struct {
+	Heartbeat Duration `json:"heartbeat,omitempty" yaml:"heartbeat,omitempty" toml:"heartbeat,omitempty"`
+}
+
+// RPCProxyBackendConfig is the configuration form of RPCProxyBackend.
+type RPCProxyBackendConfig struct {
+	Backend string   `json:"backend" yaml:"backend" toml:"backend"`
+	Methods []string `json:"methods" yaml:"methods" toml:"methods"`
+}
+
+func (c *RPCProxyConfig) options() ([]GatewayOptionFunc, error) {
+	if !c.GRPCWeb && !c.Connect {
+		return nil, errors.New("rpc_proxy enables neither grpc_web nor connect")
+	}
+
+	opts := []RPCProxyOption{RPCProxyMethods(c.Methods...)}
+
+	for _, b := range c.Backends {
+		if b.Backend == "" || len(b.Methods) == 0 {
+			return nil, errors.New("rpc_proxy backends need a backend and methods")
+		}
+
+		opts = append(opts, RPCProxyBackend(b.Backend, b.Methods...))
+	}
+
+	options := make([]GatewayOptionFunc, 0, 2)
+
+	if c.GRPCWeb {
+		options = append(options, WithGRPCWeb(opts...))
+	}
+
+	if c.Connect {
+		// The options are applied once, with the first of the protocols
+		if c.GRPCWeb {
+			opts = nil
+		}
+
+		options = append(options, WithConnect(opts...))
+	}
+
+	return options, nil
+}
+
+// OpenAPIConfig is the configuration form of WithOpenAPI. UI is "swagger" or "redoc", and the UI
+// is served at UIPath when it is set. UIIntegrity maps the files of the UI to their Subresource
+// Integrity hash.
+type OpenAPIConfig struct {
+	Path        string            `json:"path" yaml:"path" toml:"path"`
+	Files       []string          `json:"files,omitempty" yaml:"files,omitempty" toml:"files,omitempty"`
+	URLs        []string          `json:"urls,omitempty" yaml:"urls,omitempty" toml:"urls,omitempty"`
+	BasePath    string            `json:"base_path,omitempty" yaml:"base_path,omitempty" toml:"base_path,omitempty"`
+	Title       string            `json:"title,omitempty" yaml:"title,omitempty" toml:"title,omitempty"`
+	Version     string            `json:"version,omitempty" yaml:"version,omitempty" toml:"version,omitempty"`
+	Refresh     Duration          `json:"refresh,omitempty" yaml:"refresh,omitempty" toml:"refresh,omitempty"`
+	UIPath      string            `json:"ui_path,omitempty" yaml:"ui_path,omitempty" toml:"ui_path,omitempty"`
+	UI          string            `json:"ui,omitempty" yaml:"ui,omitempty" toml:"ui,omitempty"`
+	UIAssets    string            `json:"ui_assets,omitempty" yaml:"ui_assets,omitempty" toml:"ui_assets,omitempty"`
+	UIIntegrity map[string]string `json:"ui_integrity,omitempty" yaml:"ui_integrity,omitempty" toml:"ui_integrity,omitempty"`
+}
+
+// ConcurrencyConfig is the configuration form of ConcurrencyLimitHandler. The handler is built
+// again on every reload, so that the requests in flight are counted from zero afterwards.
+type ConcurrencyConfig struct {
+	MaxInFlight   int                      `json:"max_in_flight,omitempty" yaml:"max_in_flight,omitempty" toml:"max_in_flight,omitempty"`
+	MaxQueue      int                      `json:"max_queue,omitempty" yaml:"max_queue,omitempty" toml:"max_queue,omitempty"`
+	QueueTimeout  Duration                 `json:"queue_timeout,omitempty" yaml:"queue_timeout,omitempty" toml:"queue_timeout,omitempty"`
+	MaxGoroutines int                      `json:"max_goroutines,omitempty" yaml:"max_goroutines,omitempty" toml:"max_goroutines,omitempty"`
+	MaxHeapBytes  uint64                   `json:"max_heap_bytes,omitempty" yaml:"max_heap_bytes,omitempty" toml:"max_heap_bytes,omitempty"`
+	MinInFlight   int                      `json:"min_in_flight,omitempty" yaml:"min_in_flight,omitempty" toml:"min_in_flight,omitempty"`
+	TargetLatency Duration                 `json:"target_latency,omitempty" yaml:"target_latency,omitempty" toml:"target_latency,omitempty"`
+	Routes        []ConcurrencyRouteConfig `json:"routes,omitempty" yaml:"routes,omitempty" toml:"routes,omitempty"`
+}
+
+// ConcurrencyRouteConfig caps the requests in flight matching an HTTP method and path pattern.
+type ConcurrencyRouteConfig struct {
+	Method      string `json:"method,omitempty" yaml:"method,omitempty" toml:"method,omitempty"`
+	Path        string `json:"path" yaml:"path" toml:"path"`
+	MaxInFlight int    `json:"max_in_flight" yaml:"max_in_flight" toml:"max_in_flight"`
+}
+
+// TimeoutConfig is the configuration form of WithDefaultTimeout, WithMaxTimeout, WithRouteTimeout
+// and WithMethodTimeout.
+type TimeoutConfig struct {
+	Default Duration              `json:"default,omitempty" yaml:"default,omitempty" toml:"default,omitempty"`
+	Max     Duration              `json:"max,omitempty" yaml:"max,omitempty" toml:"max,omitempty"`
+	Routes  []RouteTimeoutConfig  `json:"routes,omitempty" yaml:"routes,omitempty" toml:"routes,omitempty"`
+	Methods []MethodTimeoutConfig `json:"methods,omitempty" yaml:"methods,omitempty" toml:"methods,omitempty"`
+}
+
+// RouteTimeoutConfig is the timeout of the requests matching an HTTP method and path pattern.
+type RouteTimeoutConfig struct {
+	Method  string   `json:"method,omitempty" yaml:"method,omitempty" toml:"method,omitempty"`
+	Path    string   `json:"path" yaml:"path" toml:"path"`
+	Timeout Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+// MethodTimeoutConfig is the timeout of the calls to a gRPC full method name.
+type MethodTimeoutConfig struct {
+	Method  string   `json:"method" yaml:"method" toml:"method"`
+	Timeout Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+}
+
+// DNSConfig is the configuration form of BackendDNS.
+type DNSConfig struct {
+	Name     string   `json:"name" yaml:"name" toml:"name"`
+	Port     uint     `json:"port" yaml:"port" toml:"port"`
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty" toml:"interval,omitempty"`
+}
+
+// BackendTLSConfig is the configuration form of WithBackendTLS.
+type BackendTLSConfig struct {
+	CA                 []string `json:"ca,omitempty" yaml:"ca,omitempty" toml:"ca,omitempty"`
+	ServerName         string   `json:"server_name,omitempty" yaml:"server_name,omitempty" toml:"server_name,omitempty"`
+	Cert               string   `json:"cert,omitempty" yaml:"cert,omitempty" toml:"cert,omitempty"`
+	Key                string   `json:"key,omitempty" yaml:"key,omitempty" toml:"key,omitempty"`
+	InsecureSkipVerify bool     `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty" toml:"insecure_skip_verify,omitempty"`
+}
+
+// TLSConfig is the configuration form of WithTLS. ClientAuth takes the names accepted by
+// ParseClientAuth.
+type TLSConfig struct {
+	Cert         string              `json:"cert" yaml:"cert" toml:"cert"`
+	Key          string              `json:"key" yaml:"key" toml:"key"`
+	Certificates []CertificateConfig `json:"certificates,omitempty" yaml:"certificates,omitempty" toml:"certificates,omitempty"`
+	ClientCA     []string            `json:"client_ca,omitempty" yaml:"client_ca,omitempty" toml:"client_ca,omitempty"`
+	ClientAuth   string              `json:"client_auth,omitempty" yaml:"client_auth,omitempty" toml:"client_auth,omitempty"`
+}
+
+// LogConfig is the configuration form of WithAccessLogOutput and WithErrorOutput.
+type LogConfig struct {
+	Access string `json:"access,omitempty" yaml:"access,omitempty" toml:"access,omitempty"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty" toml:"error,omitempty"`
+}
+
+// CORSConfig is the configuration form of WithCORS.
+type CORSConfig struct {
+	AllowedOrigins   []string `json:"allowed_origins,omitempty" yaml:"allowed_origins,omitempty" toml:"allowed_origins,omitempty"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty" yaml:"allowed_methods,omitempty" toml:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty" yaml:"allowed_headers,omitempty" toml:"allowed_headers,omitempty"`
+	ExposedHeaders   []string `json:"exposed_headers,omitempty" yaml:"exposed_headers,omitempty" toml:"exposed_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty" yaml:"allow_credentials,omitempty" toml:"allow_credentials,omitempty"`
+	MaxAge           int      `json:"max_age,omitempty" yaml:"max_age,omitempty" toml:"max_age,omitempty"`
+}
+
+// MetadataConfig is the configuration form of PassThrowMeta and DeleteMeta.
+// Action is either "pass" or "delete", Type is one of "request", "response" or "both".
+type MetadataConfig struct {
+	Action string   `json:"action" yaml:"action" toml:"action"`
+	Type   string   `json:"type" yaml:"type" toml:"type"`
+	Keys   []string `json:"keys" yaml:"keys" toml:"keys"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in configuration files.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+var (
+	handlerRegistryMu sync.RWMutex
+	handlerRegistry   = map[string]GatewayHandler{
+		"common_log": CommonLogHandler,
+		"gzip":       GzipCompressHandler,
+		"brotli":     BrotliCompressHandler,
+		"deflate":    DeflateCompressHandler,
+	}
+)
+
+// RegisterHandler makes a GatewayHandler available under name for the handlers list of a Config.
+// The built-in names are "common_log", "gzip", "brotli" and "deflate"; "cors", "rate_limit" and
+// "concurrency" refer to the handlers built from the configuration sections of the same name.
+func RegisterHandler(name string, handler GatewayHandler) {
+	handlerRegistryMu.Lock()
+	defer handlerRegistryMu.Unlock()
+
+	handlerRegistry[name] = handler
+}
+
+func lookupHandler(name string) (GatewayHandler, bool) {
+	handlerRegistryMu.RLock()
+	defer handlerRegistryMu.RUnlock()
+
+	h, ok := handlerRegistry[name]
+
+	return h, ok
+}
+
+// LoadConfig reads the configuration file at path, choosing the format from its extension
+// (.yaml, .yml, .json or .toml), and applies the GATEWAY_* environment variable overrides.
+// Unknown keys in the file are rejected.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %s", path, err)
+	}
+
+	c := &Config{}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse config file %s: %s", path, err)
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+
+		if err := decoder.Decode(c); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %s", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(data), c)
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %s", path, err)
+		}
+
+		if keys := meta.Undecoded(); len(keys) > 0 {
+			return nil, fmt.Errorf("failed to parse config file %s: unknown key %s", path, keys[0])
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q", ext)
+	}
+
+	if err := c.applyEnv(ConfigEnvPrefix, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// NewGatewayFromConfig creates a gateway from the configuration file at path.
+// The opts are applied after the configuration, so they can add the GatewayEndpoint
+// registrations and error handlers that cannot be expressed in a file.
+// Reload reads the file again and applies it together with the same opts.
+func NewGatewayFromConfig(path string, opts ...GatewayOptionFunc) (*GatewayOption, error) {
+	c, err := LoadConfig(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	options, err := c.Options()
+
+	if err != nil {
+		return nil, err
+	}
+
+	o, err := NewGateway(append(options, opts...)...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	o.config = c
+	o.configPath = path
+	o.configOpts = opts
+
+	return o, nil
+}
+
+// Options converts the configuration into the equivalent GatewayOptionFunc list.
+func (c *Config) Options() ([]GatewayOptionFunc, error) {
+	opts := []GatewayOptionFunc{
+		func(opt *GatewayOption) {
+			c.Server.apply(&opt.server)
+		},
+	}
+
+	backends := map[string]BackendConfig{DefaultBackend: c.Backend}
+
+	for name, backend := range c.Backends {
+		backends[name] = backend
+	}
+
+	for name, backend := range backends {
+		opt, err := backend.option(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, opt)
+	}
+
+	if c.Dial != nil {
+		dialOpts, err := c.Dial.options()
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, WithDialOptions(dialOpts...))
+	}
+
+	if c.Timeouts != nil {
+		opts = append(opts, c.Timeouts.options()...)
+	}
+
+	if c.CircuitBreaker != nil {
+		policy, err := c.CircuitBreaker.policy()
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, WithCircuitBreaker(policy))
+	}
+
+	if c.Retry != nil {
+		retry, err := c.Retry.settings()
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, WithRetryPolicy(retry.policies...))
+
+		if retry.budget != nil {
+			opts = append(opts, WithRetryBudget(*retry.budget))
+		}
+	}
+
+	if c.TLS != nil {
+		tlsOpts, err := c.TLS.options()
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, WithTLS(c.TLS.Cert, c.TLS.Key, tlsOpts...))
+	}
+
+	if c.Log.Access != "" {
+		opts = append(opts, WithAccessLogOutput(c.Log.Access))
+	}
+
+	if c.Log.Error != "" {
+		opts = append(opts, WithErrorOutput(c.Log.Error))
+	}
+
+	if c.Silent {
+		opts = append(opts, WithSilent(true))
+	}
+
+	if c.H2C {
+		opts = append(opts, WithH2C(true))
+	}
+
+	if c.ServerLimits != nil {
+		opts = append(opts, WithServerLimits(c.ServerLimits.limits()))
+	}
+
+	if c.ShutdownTimeout > 0 {
+		opts = append(opts, WithShutdownTimeout(time.Duration(c.ShutdownTimeout)))
+	}
+
+	if c.Watch {
+		opts = append(opts, WithConfigWatch(true))
+	}
+
+	if len(c.Metadata) > 0 {
+		metas := make([]WithMetaDataFunc, 0, len(c.Metadata))
+
+		for _, m := range c.Metadata {
+			meta, err := m.option()
+
+			if err != nil {
+				return nil, err
+			}
+
+			metas = append(metas, meta)
+		}
+
+		opts = append(opts, WithMetadata(metas...))
+	}
+
+	if c.HealthCheckPath != "" {
+		opts = append(opts, WithHealthCheckPathHandle(c.HealthCheckPath))
+	}
+
+	if c.StatusPath != "" {
+		opts = append(opts, WithStatusPathHandle(c.StatusPath))
+	}
+
+	for _, set := range c.DescriptorSets {
+		backend := set.Backend
+
+		if backend == "" {
+			backend = DefaultBackend
+		}
+
+		opts = append(opts, WithBackendDescriptorSet(backend, set.Path, set.Services...))
+	}
+
+	if c.RPCProxy != nil {
+		proxy, err := c.RPCProxy.options()
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, proxy...)
+	}
+
+	if c.StreamFormats != nil {
+		opts = append(opts, WithStreamFormats(time.Duration(c.StreamFormats.Heartbeat)))
+	}
+
+	for _, reflection := range c.Reflection {
+		backend := reflection.Backend
+
+		if backend == "" {
+			backend = DefaultBackend
+		}
+
+		opts = append(opts, WithBackendReflection(backend, time.Duration(reflection.Refresh), reflection.Services...))
+	}
+
+	if c.OpenAPI != nil {
+		openAPI, err := c.OpenAPI.option()
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, openAPI)
+	}
+
+	chain, err := c.handlerChain()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chain) > 0 {
+		opts = append(opts, WithHandler(chain...))
+	}
+
+	// The policy is evaluated inside the chain, once the authentication handlers have run
+	if c.Authorization != nil {
+		policy, err := c.Authorization.policy()
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, WithAuthorization(policy))
+	}
+
+	return opts, nil
+}
+
+// configSections are the handler names built from a configuration section.
+var configSections = map[string]bool{"api_key": true, "concurrency": true, "cors": true, "jwt": true, "rate_limit": true}
+
+// handlerChain resolves the handler names in order. The CORS handler is placed where "cors"
+// is listed, or in front of the chain when the cors section is set but not listed.
+func (c *Config) handlerChain() ([]GatewayHandler, error) {
+	// The handlers built from a configuration section, in the order they are added when not listed
+	sections := make([]string, 0, 5)
+	built := make(map[string]GatewayHandler)
+
+	// Shedding comes first, so that an overloaded gateway spends nothing else on the request
+	if c.Concurrency != nil {
+		sections = append(sections, "concurrency")
+		concurrency, err := c.Concurrency.handler()
+
+		if err != nil {
+			return nil, err
+		}
+
+		built["concurrency"] = concurrency
+	}
+
+	if c.CORS != nil {
+		sections = append(sections, "cors")
+		built["cors"] = c.CORS.handler()
+	}
+
+	// Authentication precedes the rate limit, so that clients can be keyed by their subject
+	if c.APIKey != nil {
+		h, err := c.APIKey.handler(c.HealthCheckPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sections = append(sections, "api_key")
+		built["api_key"] = h
+	}
+
+	if c.JWT != nil {
+		h, err := c.JWT.handler(c.HealthCheckPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sections = append(sections, "jwt")
+		built["jwt"] = h
+	}
+
+	if c.RateLimit != nil {
+		h, err := c.RateLimit.handler()
+
+		if err != nil {
+			return nil, err
+		}
+
+		sections = append(sections, "rate_limit")
+		built["rate_limit"] = h
+	}
+
+	chain := make([]GatewayHandler, 0, len(c.Handlers)+len(sections))
+	listed := make(map[string]bool)
+
+	for _, name := range c.Handlers {
+		if configSections[name] {
+			h, ok := built[name]
+
+			if !ok {
+				return nil, fmt.Errorf("handler %q requires the %s section", name, name)
+			}
+
+			chain = append(chain, h)
+			listed[name] = true
+			continue
+		}
+
+		h, ok := lookupHandler(name)
+
+		if !ok {
+			return nil, fmt.Errorf("unknown handler %q", name)
+		}
+
+		chain = append(chain, h)
+	}
+
+	prepend := make([]GatewayHandler, 0, len(sections))
+
+	for _, name := range sections {
+		if !listed[name] {
+			prepend = append(prepend, built[name])
+		}
+	}
+
+	return append(prepend, chain...), nil
+}
+
+func (s ServerConfig) apply(info *ServerInfo) {
+	if s.Host != "" {
+		info.host = s.Host
+	}
+
+	if s.Port != 0 {
+		info.port = s.Port
+	}
+}
+
+func (c BackendConfig) option(name string) (GatewayOptionFunc, error) {
+	opts := make([]BackendOption, 0)
+
+	switch {
+	case c.TLS != nil && c.Insecure:
+		return nil, fmt.Errorf("backend %s sets both tls and insecure", name)
+	case c.TLS != nil:
+		opts = append(opts, UseBackendTLS(c.TLS.options()...))
+	case c.Insecure:
+		opts = append(opts, UseBackendInsecure())
+	}
+
+	switch {
+	case len(c.Addresses) > 0:
+		opts = append(opts, BackendAddresses(c.Addresses...))
+	case c.DNS != nil:
+		opts = append(opts, BackendDNS(c.DNS.Name, c.DNS.Port, time.Duration(c.DNS.Interval)))
+	case c.TargetFile != "":
+		opts = append(opts, BackendTargetFile(c.TargetFile))
+	}
+
+	if c.Balancer != "" {
+		policy, err := ParseBalancingPolicy(c.Balancer)
+
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %s", name, err)
+		}
+
+		opts = append(opts, BackendBalancer(policy))
+	}
+
+	if len(c.Weights) > 0 {
+		opts = append(opts, BackendWeights(c.Weights))
+	}
+
+	if c.Dial != nil {
+		dialOpts, err := c.Dial.options()
+
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %s", name, err)
+		}
+
+		opts = append(opts, BackendDialOptions(dialOpts...))
+	}
+
+	if c.Retry != nil {
+		retry, err := c.Retry.settings()
+
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %s", name, err)
+		}
+
+		opts = append(opts, BackendRetryPolicy(retry.policies...))
+
+		if retry.budget != nil {
+			opts = append(opts, BackendRetryBudget(*retry.budget))
+		}
+	}
+
+	if c.Breaker != nil {
+		policy, err := c.Breaker.policy()
+
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %s", name, err)
+		}
+
+		opts = append(opts, BackendCircuitBreaker(policy))
+	}
+
+	return func(opt *GatewayOption) {
+		b := opt.backendOf(name)
+
+		ServerConfig{c.Host, c.Port}.apply(&b.server)
+
+		for _, o := range opts {
+			o(b)
+		}
+	}, nil
+}
+
+func (d *DialConfig) options() ([]grpc.DialOption, error) {
+	opts := make([]grpc.DialOption, 0)
+	callOpts := make([]grpc.CallOption, 0)
+
+	if d.Keepalive != nil {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                time.Duration(d.Keepalive.Time),
+			Timeout:             time.Duration(d.Keepalive.Timeout),
+			PermitWithoutStream: d.Keepalive.PermitWithoutStream,
+		}))
+	}
+
+	if d.MaxRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(d.MaxRecvMsgSize))
+	}
+
+	if d.MaxSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(d.MaxSendMsgSize))
+	}
+
+	if d.Compression != "" {
+		if grpcencoding.GetCompressor(d.Compression) == nil {
+			return nil, fmt.Errorf("unknown compressor %q", d.Compression)
+		}
+
+		callOpts = append(callOpts, grpc.UseCompressor(d.Compression))
+	}
+
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	return opts, nil
+}
+
+func (r *RetryConfig) settings() (retrySettings, error) {
+	settings := retrySettings{}
+
+	for _, p := range r.Policies {
+		if len(p.Methods) == 0 {
+			return settings, errors.New("retry policies need methods; name the idempotent methods to retry, or \"*\" for every method")
+		}
+
+		policy := RetryPolicy{
+			MaxAttempts:       p.MaxAttempts,
+			InitialBackoff:    time.Duration(p.InitialBackoff),
+			MaxBackoff:        time.Duration(p.MaxBackoff),
+			BackoffMultiplier: p.BackoffMultiplier,
+			Methods:           p.Methods,
+		}
+
+		retryable, err := parseCodes(p.RetryableCodes)
+
+		if err != nil {
+			return settings, err
+		}
+
+		policy.RetryableCodes = retryable
+		settings.policies = append(settings.policies, policy)
+	}
+
+	if r.Budget != nil {
+		settings.budget = &RetryBudget{MaxTokens: r.Budget.MaxTokens, TokenRatio: r.Budget.TokenRatio}
+
+		if err := settings.budget.validate(); err != nil {
+			return settings, err
+		}
+	}
+
+	return settings, nil
+}
+
+func (b *BreakerConfig) policy() (BreakerPolicy, error) {
+	failures, err := parseCodes(b.FailureCodes)
+
+	if err != nil {
+		return BreakerPolicy{}, err
+	}
+
+	policy := BreakerPolicy{
+		FailureRatio:   b.FailureRatio,
+		MinRequests:    b.MinRequests,
+		Window:         time.Duration(b.Window),
+		OpenTimeout:    time.Duration(b.OpenTimeout),
+		HalfOpenProbes: b.HalfOpenProbes,
+		FailureCodes:   failures,
+		PerMethod:      b.PerMethod,
+	}
+
+	if b.Status != 0 || b.Message != "" {
+		message := b.Message
+
+		if message == "" {
+			message = http.StatusText(b.Status)
+		}
+
+		policy.Result = &ErrorResult{Message: status.New(codes.Unavailable, message).Proto()}
+
+		if b.Status != 0 {
+			policy.Result.HttpStatus(b.Status)
+		}
+	}
+
+	return policy, nil
+}
+
+// parseCodes converts gRPC code names such as "UNAVAILABLE" into codes.
+func parseCodes(names []string) ([]codes.Code, error) {
+	values := make([]codes.Code, 0, len(names))
+
+	for _, name := range names {
+		var code codes.Code
+
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); err != nil {
+			return nil, fmt.Errorf("unknown status code %q", name)
+		}
+
+		values = append(values, code)
+	}
+
+	return values, nil
+}
+
+func (c *RateLimitConfig) handler() (GatewayHandler, error) {
+	limit, err := rateLimitOf(c.Requests, c.Period, c.Burst, c.Algorithm)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := make([]RateLimitOption, 0, len(c.Routes)+1)
+
+	if c.Key != "" {
+		kind, name, _ := strings.Cut(c.Key, ":")
+
+		switch {
+		case kind == "ip" && name == "":
+			opts = append(opts, RateLimitKey(KeyByIP()))
+		case kind == "subject" && name == "":
+			opts = append(opts, RateLimitKey(KeyBySubject()))
+		case kind == "api_key" && name != "":
+			opts = append(opts, RateLimitKey(KeyByAPIKey(name)))
+		case kind == "header" && name != "":
+			opts = append(opts, RateLimitKey(KeyByHeader(name)))
+		default:
+			return nil, fmt.Errorf("unknown rate limit key %q", c.Key)
+		}
+	}
+
+	for _, route := range c.Routes {
+		limit, err := rateLimitOf(route.Requests, route.Period, route.Burst, route.Algorithm)
+
+		if err != nil {
+			return nil, fmt.Errorf("rate limit route %s: %s", route.Path, err)
+		}
+
+		opts = append(opts, RateLimitRoute(route.Method, route.Path, limit))
+	}
+
+	return RateLimitHandler(limit, opts...), nil
+}
+
+func (c *OpenAPIConfig) option() (GatewayOptionFunc, error) {
+	if c.Path == "" {
+		return nil, errors.New("openapi: path is required")
+	}
+
+	if len(c.Files) == 0 && len(c.URLs) == 0 {
+		return nil, errors.New("openapi: files or urls are required")
+	}
+
+	opts := make([]OpenAPIOption, 0)
+
+	if len(c.Files) > 0 {
+		opts = append(opts, OpenAPIFile(c.Files...))
+	}
+
+	for _, url := range c.URLs {
+		opts = append(opts, OpenAPIURL(url))
+	}
+
+	if c.BasePath != "" {
+		opts = append(opts, OpenAPIBasePath(c.BasePath))
+	}
+
+	if c.Title != "" || c.Version != "" {
+		opts = append(opts, OpenAPIInfo(c.Title, c.Version))
+	}
+
+	if c.Refresh > 0 {
+		opts = append(opts, OpenAPIRefresh(time.Duration(c.Refresh)))
+	}
+
+	if c.UIPath != "" {
+		kind := SwaggerUI
+
+		if c.UI != "" {
+			kind = OpenAPIUIKind(strings.ToLower(c.UI))
+		}
+
+		if _, ok := openAPIUITemplates[kind]; !ok {
+			return nil, fmt.Errorf("openapi: unknown ui %q, expected one of %s", c.UI, openAPIUIKinds())
+		}
+
+		opts = append(opts, OpenAPIUI(c.UIPath, kind))
+	}
+
+	if c.UIAssets != "" {
+		opts = append(opts, OpenAPIUIAssets(c.UIAssets))
+	}
+
+	for file, hash := range c.UIIntegrity {
+		opts = append(opts, OpenAPIUIIntegrity(file, hash))
+	}
+
+	return WithOpenAPI(c.Path, opts...), nil
+}
+
+func (c *AuthorizationConfig) policy() (AuthorizationPolicy, error) {
+	policy := AuthorizationPolicy{Rules: make([]AuthorizationRule, 0, len(c.Rules))}
+
+	switch strings.ToLower(c.Default) {
+	case "", "allow":
+	case "deny":
+		policy.DenyByDefault = true
+	default:
+		return policy, fmt.Errorf("unknown authorization default %q", c.Default)
+	}
+
+	for i, rule := range c.Rules {
+		if rule.Method == "" && rule.Path == "" {
+			return policy, fmt.Errorf("authorization rule %d needs a method or a path", i+1)
+		}
+
+		policy.Rules = append(policy.Rules, AuthorizationRule(rule))
+	}
+
+	return policy, nil
+}
+
+func (c *APIKeyConfig) handler(healthCheckPath string) (GatewayHandler, error) {
+	if c.KeysFile == "" {
+		return nil, errors.New("api_key: keys_file is required")
+	}
+
+	store, err := NewFileKeyStore(c.KeysFile)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := make([]APIKeyOption, 0)
+
+	if c.Header != "" {
+		opts = append(opts, APIKeyFromHeader(c.Header))
+	}
+
+	if c.Query != "" {
+		opts = append(opts, APIKeyFromQuery(c.Query))
+	}
+
+	if c.Cookie != "" {
+		opts = append(opts, APIKeyFromCookie(c.Cookie))
+	}
+
+	if healthCheckPath != "" {
+		opts = append(opts, APIKeyExemptPaths(healthCheckPath))
+	}
+
+	opts = append(opts, APIKeyExemptPaths(c.ExemptPaths...))
+
+	if c.Optional {
+		opts = append(opts, APIKeyOptional())
+	}
+
+	return APIKeyAuthHandler(store, opts...), nil
+}
+
+func (c *JWTConfig) handler(healthCheckPath string) (GatewayHandler, error) {
+	opts := make([]JWTOption, 0)
+
+	switch {
+	case c.JWKSFile != "" && c.JWKSURL != "":
+		return nil, errors.New("jwt: jwks_file and jwks_url are exclusive")
+	case c.JWKSFile != "":
+		opts = append(opts, JWKSFile(c.JWKSFile))
+	case c.JWKSURL != "":
+		opts = append(opts, JWKSURL(c.JWKSURL, time.Duration(c.Refresh)))
+	default:
+		return nil, errors.New("jwt: jwks_file or jwks_url is required")
+	}
+
+	if len(c.Issuers) > 0 {
+		opts = append(opts, JWTIssuer(c.Issuers...))
+	}
+
+	if len(c.Audiences) > 0 {
+		opts = append(opts, JWTAudience(c.Audiences...))
+	}
+
+	if c.ClockSkew > 0 {
+		opts = append(opts, JWTClockSkew(time.Duration(c.ClockSkew)))
+	}
+
+	if len(c.Algorithms) > 0 {
+		opts = append(opts, JWTAlgorithms(c.Algorithms...))
+	}
+
+	if healthCheckPath != "" {
+		opts = append(opts, JWTExemptPaths(healthCheckPath))
+	}
+
+	opts = append(opts, JWTExemptPaths(c.ExemptPaths...))
+
+	for claim, key := range c.Claims {
+		opts = append(opts, JWTClaimMetadata(claim, key))
+	}
+
+	return JWTAuthHandler(opts...), nil
+}
+
+func (c *ConcurrencyConfig) handler() (GatewayHandler, error) {
+	opts := make([]ConcurrencyOption, 0, len(c.Routes))
+
+	for _, route := range c.Routes {
+		if route.MaxInFlight <= 0 {
+			return nil, fmt.Errorf("concurrency route %s %s: max_in_flight must be above 0", route.Method, route.Path)
+		}
+
+		opts = append(opts, ConcurrencyRoute(route.Method, route.Path, route.MaxInFlight))
+	}
+
+	return ConcurrencyLimitHandler(ConcurrencyLimit{
+		MaxInFlight:   c.MaxInFlight,
+		MaxQueue:      c.MaxQueue,
+		QueueTimeout:  time.Duration(c.QueueTimeout),
+		MaxGoroutines: c.MaxGoroutines,
+		MaxHeapBytes:  c.MaxHeapBytes,
+		MinInFlight:   c.MinInFlight,
+		TargetLatency: time.Duration(c.TargetLatency),
+	}, opts...), nil
+}
+
+func rateLimitOf(requests int, period Duration, burst int, algorithm string) (RateLimit, error) {
+	limit := RateLimit{Requests: requests, Period: time.Duration(period), Burst: burst, Algorithm: TokenBucket}
+
+	switch RateLimitAlgorithm(algorithm) {
+	case "", TokenBucket:
+	case SlidingWindow:
+		limit.Algorithm = SlidingWindow
+	default:
+		return limit, fmt.Errorf("unknown rate limit algorithm %q", algorithm)
+	}
+
+	if requests > 0 && period <= 0 {
+		return limit, fmt.Errorf("rate limit period must be positive")
+	}
+
+	return limit, nil
+}
+
+func (t *TimeoutConfig) options() []GatewayOptionFunc {
+	opts := make([]GatewayOptionFunc, 0)
+
+	if t.Default > 0 {
+		opts = append(opts, WithDefaultTimeout(time.Duration(t.Default)))
+	}
+
+	if t.Max > 0 {
+		opts = append(opts, WithMaxTimeout(time.Duration(t.Max)))
+	}
+
+	for _, r := range t.Routes {
+		opts = append(opts, WithRouteTimeout(r.Method, r.Path, time.Duration(r.Timeout)))
+	}
+
+	for _, m := range t.Methods {
+		opts = append(opts, WithMethodTimeout(m.Method, time.Duration(m.Timeout)))
+	}
+
+	return opts
+}
+
+func (t *TLSConfig) options() ([]ServerTLSOption, error) {
+	opts := make([]ServerTLSOption, 0)
+
+	for _, cert := range t.Certificates {
+		opts = append(opts, Certificate(cert.Cert, cert.Key))
+	}
+
+	if len(t.ClientCA) > 0 {
+		opts = append(opts, ClientCA(t.ClientCA...))
+	}
+
+	if t.ClientAuth != "" {
+		mode, err := ParseClientAuth(t.ClientAuth)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, ClientAuth(mode))
+	}
+
+	return opts, nil
+}
+
+func (t *BackendTLSConfig) options() []BackendTLSOption {
+	opts := make([]BackendTLSOption, 0)
+
+	if len(t.CA) > 0 {
+		opts = append(opts, BackendCA(t.CA...))
+	}
+
+	if t.ServerName != "" {
+		opts = append(opts, BackendServerName(t.ServerName))
+	}
+
+	if t.Cert != "" || t.Key != "" {
+		opts = append(opts, BackendClientCert(t.Cert, t.Key))
+	}
+
+	if t.InsecureSkipVerify {
+		opts = append(opts, BackendInsecureSkipVerify())
+	}
+
+	return opts
+}
+
+func (c *CORSConfig) handler() GatewayHandler {
+	options := make([]handlers.CORSOption, 0)
+
+	if len(c.AllowedOrigins) > 0 {
+		options = append(options, handlers.AllowedOrigins(c.AllowedOrigins))
+	}
+
+	if len(c.AllowedMethods) > 0 {
+		options = append(options, handlers.AllowedMethods(c.AllowedMethods))
+	}
+
+	if len(c.AllowedHeaders) > 0 {
+		options = append(options, handlers.AllowedHeaders(c.AllowedHeaders))
+	}
+
+	if len(c.ExposedHeaders) > 0 {
+		options = append(options, handlers.ExposedHeaders(c.ExposedHeaders))
+	}
+
+	if c.AllowCredentials {
+		options = append(options, handlers.AllowCredentials())
+	}
+
+	if c.MaxAge > 0 {
+		options = append(options, handlers.MaxAge(c.MaxAge))
+	}
+
+	return func(h http.Handler, _ *GatewayOption) http.Handler {
+		return handlers.CORS(options...)(h)
+	}
+}
+
+func (m MetadataConfig) option() (WithMetaDataFunc, error) {
+	var mode MetaType
+
+	switch strings.ToLower(m.Type) {
+	case "request", "":
+		mode = RequestMeta
+	case "response":
+		mode = ResponseMeta
+	case "both", "bidirectional":
+		mode = BidirectionalMeta
+	default:
+		return nil, fmt.Errorf("unknown metadata type %q", m.Type)
+	}
+
+	switch strings.ToLower(m.Action) {
+	case "pass", "":
+		return PassThrowMeta(m.Keys, mode), nil
+	case "delete":
+		return DeleteMeta(m.Keys, mode), nil
+	default:
+		return nil, fmt.Errorf("unknown metadata action %q", m.Action)
+	}
+}
+
+// applyEnv overrides the scalar and string list fields of the configuration with the
+// environment variables named after their json keys. String lists are comma separated.
+func (c *Config) applyEnv(prefix string, lookup func(string) (string, bool)) error {
+	return applyEnvValue(reflect.ValueOf(c).Elem(), prefix, lookup)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func applyEnvValue(v reflect.Value, name string, lookup func(string) (string, bool)) error {
+	if reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		if value, ok := lookup(name); ok {
+			if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("invalid value for %s: %s", name, err)
+			}
+		}
+
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+			if !field.IsExported() || key == "" || key == "-" {
+				continue
+			}
+
+			if err := applyEnvValue(v.Field(i), name+"_"+strings.ToUpper(key), lookup); err != nil {
+				return err
+			}
+		}
+	case reflect.Pointer:
+		if v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+
+		if v.IsNil() {
+			// Only allocate optional sections when one of their fields is overridden
+			value := reflect.New(v.Type().Elem())
+
+			if err := applyEnvValue(value.Elem(), name, lookup); err != nil {
+				return err
+			}
+
+			if !value.Elem().IsZero() {
+				v.Set(value)
+			}
+
+			return nil
+		}
+
+		return applyEnvValue(v.Elem(), name, lookup)
+	case reflect.Map:
+		// Only the entries present in the file can be overridden, e.g. GATEWAY_BACKENDS_USERS_HOST
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.Struct {
+			return nil
+		}
+
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(v.MapIndex(key))
+
+			if err := applyEnvValue(value, name+"_"+strings.ToUpper(key.String()), lookup); err != nil {
+				return err
+			}
+
+			v.SetMapIndex(key, value)
+		}
+	default:
+		value, ok := lookup(name)
+
+		if !ok {
+			return nil
+		}
+
+		if err := setEnvValue(v, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setEnvValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return err
+		}
+
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, v.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseUint(value, 10, v.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		v.SetUint(i)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+
+		if err != nil {
+			return err
+		}
+
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+
+		v.Set(reflect.ValueOf(chunkValues(value)))
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+package runtime
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			"yaml",
+			"gateway.yaml",
+			`
+server:
+  host: 127.0.0.1
+  port: 9091
+backend:
+  port: 9090
+metadata:
+  - action: pass
+    type: request
+    keys: [Cookie]
+handlers: [common_log, gzip]
+shutdown_timeout: 5s
+`,
+		},
+		{
+			"json",
+			"gateway.json",
+			`{
+  "server": {"host": "127.0.0.1", "port": 9091},
+  "backend": {"port": 9090},
+  "metadata": [{"action": "pass", "type": "request", "keys": ["Cookie"]}],
+  "handlers": ["common_log", "gzip"],
+  "shutdown_timeout": "5s"
+}`,
+		},
+		{
+			"toml",
+			"gateway.toml",
+			`
+handlers = ["common_log", "gzip"]
+shutdown_timeout = "5s"
+
+[server]
+host = "127.0.0.1"
+port = 9091
+
+[backend]
+port = 9090
+
+[[metadata]]
+action = "pass"
+type = "request"
+keys = ["Cookie"]
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadConfig(writeConfig(t, tt.file, tt.content))
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, ServerConfig{"127.0.0.1", 9091}, c.Server)
+			assert.Equal(t, BackendConfig{Port: 9090}, c.Backend)
+			assert.Equal(t, []string{"common_log", "gzip"}, c.Handlers)
+			assert.Equal(t, Duration(5*time.Second), c.ShutdownTimeout)
+			assert.Len(t, c.Metadata, 1)
+
+			options, err := c.Options()
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			o, err := NewGateway(append(options, WithSilent(true))...)
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, ServerInfo{"127.0.0.1", 9091}, o.server)
+			assert.Equal(t, ServerInfo{"127.0.0.1", 9090}, o.backends[DefaultBackend].server)
+			assert.Len(t, o.handlers, 2)
+			assert.Len(t, o.metas.req, 1)
+			assert.Equal(t, 5*time.Second, o.timeout)
+		})
+	}
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	_, err := LoadConfig(writeConfig(t, "gateway.yaml", "servers:\n  port: 1\n"))
+	assert.Error(t, err, "unknown keys must be rejected")
+
+	_, err = LoadConfig(writeConfig(t, "gateway.ini", ""))
+	assert.Error(t, err, "unknown formats must be rejected")
+
+	c, err := LoadConfig(writeConfig(t, "gateway.yaml", "handlers: [unknown]\n"))
+	assert.NoError(t, err)
+
+	_, err = c.Options()
+	assert.Error(t, err, "unknown handlers must be rejected")
+}
+
+func TestConfig_ApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"GATEWAY_SERVER_PORT":         "9000",
+		"GATEWAY_LOG_ACCESS":          "access.log",
+		"GATEWAY_TLS_CERT":            "server.crt",
+		"GATEWAY_HANDLERS":            "common_log, brotli",
+		"GATEWAY_SHUTDOWN_TIMEOUT":    "1m",
+		"GATEWAY_BACKENDS_USERS_PORT": "9443",
+	}
+
+	c := &Config{Backends: map[string]BackendConfig{"users": {Host: "users.internal", Port: 9090}}}
+
+	err := c.applyEnv(ConfigEnvPrefix, func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(9000), c.Server.Port)
+	assert.Equal(t, "access.log", c.Log.Access)
+	assert.Equal(t, &TLSConfig{Cert: "server.crt"}, c.TLS)
+	assert.Nil(t, c.CORS)
+	assert.Equal(t, []string{"common_log", "brotli"}, c.Handlers)
+	assert.Equal(t, Duration(time.Minute), c.ShutdownTimeout)
+	assert.Equal(t, BackendConfig{Host: "users.internal", Port: 9443}, c.Backends["users"])
+}
@@ -0,0 +1,404 @@
+package runtime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	connectStreamPrefix   = "application/connect+"
+	connectVersionHeader  = "Connect-Protocol-Version"
+	connectTimeoutHeader  = "Connect-Timeout-Ms"
+	connectEncodingHeader = "Connect-Content-Encoding"
+)
+
+// isConnect reports whether r is a call of the Connect protocol.
+func isConnect(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet:
+		return r.URL.Query().Get("connect") == "v1"
+	case http.MethodPost:
+		ct := mediaType(r)
+
+		if strings.HasPrefix(ct, connectStreamPrefix) {
+			return true
+		}
+
+		return (ct == "application/proto" || ct == "application/json") && r.Header.Get(connectVersionHeader) == "1"
+	}
+
+	return false
+}
+
+// serveConnect answers a unary or streaming call of the Connect protocol.
+func serveConnect(w http.ResponseWriter, r *http.Request, call *proxyCall) {
+	ctx := r.Context()
+
+	if v := r.Header.Get(connectTimeoutHeader); v != "" {
+		ms, err := strconv.ParseUint(v, 10, 63)
+
+		if err != nil || len(v) > 10 {
+			call.err = status.Errorf(codes.InvalidArgument, "invalid %s %q", connectTimeoutHeader, v)
+		} else {
+			var cancel context.CancelFunc
+
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(ms)*time.Millisecond)
+			defer cancel()
+		}
+	}
+
+	if strings.HasPrefix(mediaType(r), connectStreamPrefix) {
+		serveConnectStream(ctx, w, r, call)
+		return
+	}
+
+	if r.Method == http.MethodGet && call.err == nil && !connectGet(call.desc) {
+		buf, _ := json.Marshal(newConnectError(status.Newf(codes.Unimplemented, "%s must be called with POST", call.method)))
+
+		w.Header().Set("Allow", http.MethodPost)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		_, _ = w.Write(buf)
+
+		return
+	}
+
+	serveConnectUnary(ctx, w, r, call)
+}
+
+// connectGet reports whether method may be called with GET, which the Connect protocol allows for
+// the methods declared with idempotency_level = NO_SIDE_EFFECTS only.
+func connectGet(method protoreflect.MethodDescriptor) bool {
+	if method == nil {
+		return false
+	}
+
+	options, ok := method.Options().(*descriptorpb.MethodOptions)
+
+	return ok && options.GetIdempotencyLevel() == descriptorpb.MethodOptions_NO_SIDE_EFFECTS
+}
+
+func serveConnectUnary(ctx context.Context, w http.ResponseWriter, r *http.Request, call *proxyCall) {
+	var (
+		name, encoding string
+		payload        []byte
+		err            error
+	)
+
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		name, encoding = q.Get("encoding"), q.Get("compression")
+		payload = []byte(q.Get("message"))
+
+		if q.Get("base64") == "1" {
+			payload, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(q.Get("message"), "="))
+		}
+	} else {
+		name, encoding = strings.TrimPrefix(mediaType(r), "application/"), r.Header.Get("Content-Encoding")
+		payload, err = io.ReadAll(io.LimitReader(r.Body, maxProxyMessageSize+1))
+
+		if err == nil && len(payload) > maxProxyMessageSize {
+			err = status.Errorf(codes.ResourceExhausted, "message exceeds the limit of %d bytes", maxProxyMessageSize)
+		}
+	}
+
+	codec, cerr := newConnectCodec(name, call)
+
+	if err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		payload, err = decompress(encoding, payload)
+	}
+
+	if err == nil {
+		payload, err = codec.request(payload)
+	}
+
+	if err != nil && call.err == nil {
+		call.err = status.Convert(err).Err()
+	}
+
+	var out []byte
+
+	received := false
+	sent := false
+
+	next := func() ([]byte, error) {
+		if sent {
+			return nil, io.EOF
+		}
+
+		sent = true
+
+		return payload, nil
+	}
+
+	st := call.invoke(ctx, next, func(msg []byte) error {
+		if received {
+			return status.Error(codes.Unimplemented, "unary call answered with several messages")
+		}
+
+		out, received = msg, true
+
+		return nil
+	})
+
+	if st.Code() == codes.OK && !received {
+		st = status.New(codes.Unimplemented, "unary call answered without a message")
+	}
+
+	if st.Code() == codes.OK {
+		if out, err = codec.response(out); err != nil {
+			st = status.Convert(err)
+		}
+	}
+
+	writeMetadataHeaders(w.Header(), call.header, "")
+	writeMetadataHeaders(w.Header(), call.trailer, "Trailer-")
+
+	if st.Code() != codes.OK {
+		buf, _ := json.Marshal(newConnectError(st))
+		code := runtime.HTTPStatusFromCode(st.Code())
+
+		// The HTTP status of an ErrorResult applies as for the REST routes
+		if call.result != nil && call.result.status != nil {
+			code = *call.result.status
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_, _ = w.Write(buf)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/"+codec.name)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(out)
+}
+
+// connectEndStream is the message ending a Connect stream.
+type connectEndStream struct {
+	Error    *connectError       `json:"error,omitempty"`
+	Metadata map[string][]string `json:"metadata,omitempty"`
+}
+
+func serveConnectStream(ctx context.Context, w http.ResponseWriter, r *http.Request, call *proxyCall) {
+	ct := mediaType(r)
+	codec, err := newConnectCodec(strings.TrimPrefix(ct, connectStreamPrefix), call)
+
+	if err != nil && call.err == nil {
+		call.err = err
+	}
+
+	encoding := r.Header.Get(connectEncodingHeader)
+
+	next := func() ([]byte, error) {
+		flags, msg, err := readEnvelope(r.Body)
+
+		if err == nil && flags&envelopeCompressed != 0 {
+			msg, err = decompress(encoding, msg)
+		}
+
+		if err == nil {
+			msg, err = codec.request(msg)
+		}
+
+		if err != nil && err != io.EOF {
+			return nil, status.Convert(err).Err()
+		}
+
+		return msg, err
+	}
+
+	w.Header().Set("Content-Type", ct)
+
+	write := func(flags byte, msg []byte) {
+		_, _ = w.Write(appendEnvelope(nil, flags, msg))
+
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	started := false
+
+	start := func() {
+		if !started {
+			writeMetadataHeaders(w.Header(), call.header, "")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+
+	st := call.invoke(ctx, next, func(msg []byte) error {
+		out, err := codec.response(msg)
+
+		if err != nil {
+			return err
+		}
+
+		start()
+		write(0, out)
+
+		return nil
+	})
+
+	end := connectEndStream{}
+
+	if st.Code() != codes.OK {
+		end.Error = newConnectError(st)
+	}
+
+	if len(call.trailer) > 0 {
+		h := make(http.Header)
+		writeMetadataHeaders(h, call.trailer, "")
+		end.Metadata = h
+	}
+
+	buf, _ := json.Marshal(end)
+
+	start()
+	write(envelopeEndStream, buf)
+}
+
+// connectCodec converts the messages of a call between the codec of the client and the binary
+// encoding of the backend.
+type connectCodec struct {
+	name   string
+	method protoreflect.MethodDescriptor
+}
+
+// newConnectCodec returns the codec name for the method of call. The JSON codec needs the
+// descriptor of the method.
+func newConnectCodec(name string, call *proxyCall) (*connectCodec, error) {
+	switch name {
+	case "proto":
+		return &connectCodec{name: name}, nil
+	case "json":
+		if call.desc != nil {
+			return &connectCodec{name: name, method: call.desc}, nil
+		}
+
+		return &connectCodec{name: name}, status.Errorf(codes.Unimplemented, "the json codec needs the descriptor of %s", call.method)
+	}
+
+	return &connectCodec{name: "proto"}, status.Errorf(codes.Unimplemented, "unsupported codec %q", name)
+}
+
+func (c *connectCodec) request(buf []byte) ([]byte, error) {
+	if c.method == nil {
+		return buf, nil
+	}
+
+	msg := dynamicpb.NewMessage(c.method.Input())
+
+	if len(bytes.TrimSpace(buf)) > 0 {
+		if err := protojson.Unmarshal(buf, msg); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+
+	return proto.Marshal(msg)
+}
+
+func (c *connectCodec) response(buf []byte) ([]byte, error) {
+	if c.method == nil {
+		return buf, nil
+	}
+
+	msg := dynamicpb.NewMessage(c.method.Output())
+
+	if err := proto.Unmarshal(buf, msg); err != nil {
+		return nil, status.Errorf(codes.Internal, "%v", err)
+	}
+
+	return protojson.Marshal(msg)
+}
+
+// decompress decodes a message compressed with encoding, of which gzip is supported.
+func decompress(encoding string, buf []byte) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		return buf, nil
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(buf))
+
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+
+		out, err := io.ReadAll(io.LimitReader(zr, maxProxyMessageSize+1))
+
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+
+		if len(out) > maxProxyMessageSize {
+			return nil, status.Errorf(codes.ResourceExhausted, "message exceeds the limit of %d bytes", maxProxyMessageSize)
+		}
+
+		return out, nil
+	}
+
+	return nil, status.Errorf(codes.Unimplemented, "unsupported compression %q", encoding)
+}
+
+// connectError is the JSON form of an error in the Connect protocol.
+type connectError struct {
+	Code    string               `json:"code"`
+	Message string               `json:"message,omitempty"`
+	Details []connectErrorDetail `json:"details,omitempty"`
+}
+
+type connectErrorDetail struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func newConnectError(st *status.Status) *connectError {
+	e := &connectError{Code: connectCode(st.Code()), Message: st.Message()}
+
+	for _, detail := range st.Proto().GetDetails() {
+		e.Details = append(e.Details, connectErrorDetail{
+			Type:  strings.TrimPrefix(detail.GetTypeUrl(), "type.googleapis.com/"),
+			Value: base64.RawStdEncoding.EncodeToString(detail.GetValue()),
+		})
+	}
+
+	return e
+}
+
+// connectCode is the name of code in the Connect protocol, e.g. "invalid_argument".
+func connectCode(code codes.Code) string {
+	var b strings.Builder
+
+	for i, c := range code.String() {
+		if unicode.IsUpper(c) && i > 0 {
+			b.WriteByte('_')
+		}
+
+		b.WriteRune(unicode.ToLower(c))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,192 @@
+package runtime
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestConnectUnary(t *testing.T) {
+	handler := startRPCProxy(t, WithConnect(RPCProxyMethods("/test.v1.ItemService/*", "/grpc.health.v1.Health/*")))
+
+	request := testItemBytes(t, map[string]interface{}{"name": "book"})
+
+	var zipped bytes.Buffer
+	zw := gzip.NewWriter(&zipped)
+	_, _ = zw.Write(request)
+	assert.NoError(t, zw.Close())
+
+	query := url.Values{"connect": {"v1"}, "encoding": {"proto"}, "base64": {"1"}, "message": {base64.RawURLEncoding.EncodeToString(request)}}
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		ct       string
+		encoding string
+		body     []byte
+		status   int
+		want     string
+	}{
+		{"proto", "POST", "/test.v1.ItemService/GetItem", "application/proto", "", request, http.StatusOK, "book"},
+		{"gzip", "POST", "/test.v1.ItemService/GetItem", "application/proto", "gzip", zipped.Bytes(), http.StatusOK, "book"},
+		{"get", "GET", "/test.v1.ItemService/GetItem?" + query.Encode(), "", "", nil, http.StatusOK, "book"},
+		{"json", "POST", "/grpc.health.v1.Health/Check", "application/json", "", []byte(`{"service": ""}`), http.StatusOK, `{"status":"SERVING"}`},
+		{"get with side effects", "GET", "/test.v1.ItemService/CreateItem?" + query.Encode(), "", "", nil, http.StatusMethodNotAllowed, `"code":"unimplemented"`},
+		{"json without descriptor", "POST", "/test.v1.ItemService/LookupItem", "application/json", "", []byte(`{}`), http.StatusNotImplemented, `"code":"unimplemented"`},
+		{"unknown service", "POST", "/grpc.health.v1.Health/Check", "application/json", "", []byte(`{"service": "users"}`), http.StatusNotFound, `"code":"not_found"`},
+		{"not listed", "POST", "/test.v1.OrderService/GetOrder", "application/proto", "", nil, http.StatusNotImplemented, `"code":"unimplemented"`},
+		{"bad compression", "POST", "/test.v1.ItemService/GetItem", "application/proto", "br", request, http.StatusNotImplemented, `"code":"unimplemented"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, bytes.NewReader(tt.body))
+
+			if tt.method == "POST" {
+				r.Header.Set("Content-Type", tt.ct)
+				r.Header.Set(connectVersionHeader, "1")
+			}
+
+			if tt.encoding != "" {
+				r.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+
+			if tt.status == http.StatusOK && tt.ct != "application/json" {
+				assert.Equal(t, "application/proto", w.Header().Get("Content-Type"))
+				assert.Equal(t, tt.want, testItemName(t, w.Body.Bytes()))
+				return
+			}
+
+			assert.Contains(t, strings.ReplaceAll(w.Body.String(), " ", ""), tt.want)
+		})
+	}
+
+	// Requests without the protocol version are left to the REST routes
+	r := httptest.NewRequest("POST", "/test.v1.ItemService/GetItem", bytes.NewReader(request))
+	r.Header.Set("Content-Type", "application/proto")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestConnectStream(t *testing.T) {
+	handler := startRPCProxy(t, WithConnect(RPCProxyMethods("/test.v1.ItemService/*", "/grpc.health.v1.Health/*")))
+
+	request := testItemBytes(t, map[string]interface{}{"name": "book"})
+
+	tests := []struct {
+		name    string
+		path    string
+		ct      string
+		timeout string
+		body    []byte
+		items   int
+		code    string
+	}{
+		{"server stream", "/test.v1.ItemService/WatchItems", "application/connect+proto", "", appendEnvelope(nil, 0, request), 2, ""},
+		{"json", "/grpc.health.v1.Health/Watch", "application/connect+json", "100", appendEnvelope(nil, 0, []byte(`{}`)), 1, "deadline_exceeded"},
+		{"unsupported codec", "/test.v1.ItemService/WatchItems", "application/connect+thrift", "", appendEnvelope(nil, 0, request), 0, "unimplemented"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.path, bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.ct)
+
+			if tt.timeout != "" {
+				r.Header.Set(connectTimeoutHeader, tt.timeout)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.ct, w.Header().Get("Content-Type"))
+
+			reader := bytes.NewReader(w.Body.Bytes())
+			items := 0
+
+			for {
+				flags, msg, err := readEnvelope(reader)
+
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				if flags&envelopeEndStream == 0 {
+					items++
+					continue
+				}
+
+				var end connectEndStream
+				assert.NoError(t, json.Unmarshal(msg, &end))
+
+				if tt.code == "" {
+					assert.Nil(t, end.Error)
+				} else if assert.NotNil(t, end.Error) {
+					assert.Equal(t, tt.code, end.Error.Code)
+				}
+
+				break
+			}
+
+			assert.Equal(t, tt.items, items)
+		})
+	}
+}
+
+func TestConnectErrorHandler(t *testing.T) {
+	handler := startRPCProxy(t, WithConnect(RPCProxyMethods("/test.v1.ItemService/*", "/grpc.health.v1.Health/*")), WithErrorHandler(
+		ErrorHandle(codes.NotFound, func(_ context.Context, _ *runtime.ServeMux, _ http.ResponseWriter, _ *http.Request, s *status.Status) *ErrorResult {
+			result := &ErrorResult{Message: &spb.Status{Code: int32(s.Code()), Message: "custom"}}
+			result.HttpStatus(http.StatusGone)
+
+			return result
+		}),
+	))
+
+	r := httptest.NewRequest("POST", "/grpc.health.v1.Health/Check", strings.NewReader(`{"service": "users"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(connectVersionHeader, "1")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	// The ErrorResult sets the HTTP status and is added to the details of the error
+	assert.Equal(t, http.StatusGone, w.Code)
+
+	var e connectError
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &e))
+	assert.Equal(t, "not_found", e.Code)
+
+	if assert.Len(t, e.Details, 1) {
+		assert.Equal(t, "google.rpc.Status", e.Details[0].Type)
+	}
+}
+
+func TestConnectCode(t *testing.T) {
+	assert.Equal(t, "canceled", connectCode(codes.Canceled))
+	assert.Equal(t, "invalid_argument", connectCode(codes.InvalidArgument))
+	assert.Equal(t, "resource_exhausted", connectCode(codes.ResourceExhausted))
+	assert.Equal(t, "data_loss", connectCode(codes.DataLoss))
+}
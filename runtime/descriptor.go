@@ -0,0 +1,413 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/utilities"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WithDescriptorSet is a GatewayOptionFunc that registers DescriptorEndpoint against the default
+// backend. With WithConfigWatch the gateway reloads when the descriptor file changes, so that
+// routes can be updated by swapping the file.
+//
+// Example usage:
+//
+//	// buf build -o services.binpb
+//	server := NewGateway(
+//	    WithBackend("127.0.0.1", 8080),
+//	    WithDescriptorSet("services.binpb"),
+//	)
+func WithDescriptorSet(path string, services ...string) GatewayOptionFunc {
+	return WithBackendDescriptorSet(DefaultBackend, path, services...)
+}
+
+// WithBackendDescriptorSet registers DescriptorEndpoint against a named backend, as
+// WithDescriptorSet does for the default one.
+func WithBackendDescriptorSet(backend, path string, services ...string) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		WithBackendEndpoint(backend, DescriptorEndpoint(path, services...))(opt)
+		opt.watched = append(opt.watched, path)
+	}
+}
+
+// DescriptorEndpoint returns a GatewayEndpoint serving the methods annotated with google.api.http
+// in the FileDescriptorSet at path, as generated Register...HandlerFromEndpoint functions do but
+// without generated code: requests are transcoded to dynamic messages of the method types. The
+// set is read in the binary form written by "buf build" or "protoc --descriptor_set_out", or as
+// JSON for files ending in .json, and must include the imported files. Only the services named
+// are served when some are given. Client and bidirectional streaming methods are not supported.
+func DescriptorEndpoint(path string, services ...string) GatewayEndpoint {
+	return func(ctx context.Context, mux *runtime.ServeMux, host string, opts []grpc.DialOption) error {
+		_, err := serveDescriptors(ctx, mux, host, opts, "descriptor set "+path, services, func(context.Context, *grpc.ClientConn) (*protoregistry.Files, []string, error) {
+			files, err := readDescriptorSet(path)
+
+			return files, services, err
+		})
+
+		return err
+	}
+}
+
+// serveDescriptors dials host and registers the routes of the services load returns over the
+// connection, which is closed once ctx is canceled. source names the descriptors in errors.
+func serveDescriptors(
+	ctx context.Context, mux *runtime.ServeMux, host string, opts []grpc.DialOption, source string, services []string,
+	load func(context.Context, *grpc.ClientConn) (*protoregistry.Files, []string, error),
+) (_ *grpc.ClientConn, err error) {
+	conn, err := grpc.NewClient(host, opts...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			if cerr := conn.Close(); cerr != nil {
+				grpclog.Errorf("Failed to close conn to %s: %v", host, cerr)
+			}
+			return
+		}
+
+		go func() {
+			<-ctx.Done()
+			if cerr := conn.Close(); cerr != nil {
+				grpclog.Errorf("Failed to close conn to %s: %v", host, cerr)
+			}
+		}()
+	}()
+
+	files, services, err := load(ctx, conn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	routes, err := descriptorRoutes(files, services)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", source, err)
+	}
+
+	for _, route := range routes {
+		if err := mux.HandlePath(route.verb, route.pattern, route.handler(mux, conn)); err != nil {
+			return nil, fmt.Errorf("route %s %s of %s: %s", route.verb, route.pattern, route.method.FullName(), err)
+		}
+	}
+
+	return conn, nil
+}
+
+func readDescriptorSet(path string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read descriptor set %s: %s", path, err)
+	}
+
+	set := &descriptorpb.FileDescriptorSet{}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = protojson.Unmarshal(data, set)
+	} else {
+		err = proto.Unmarshal(data, set)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set %s: %s", path, err)
+	}
+
+	files, err := protodesc.NewFiles(set)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %s", path, err)
+	}
+
+	return files, nil
+}
+
+// descriptorRoute is an HTTP binding of a method.
+type descriptorRoute struct {
+	method       protoreflect.MethodDescriptor
+	verb         string
+	pattern      string
+	body         string
+	responseBody string
+}
+
+// descriptorRoutes lists the HTTP bindings of the methods of the services in files.
+func descriptorRoutes(files *protoregistry.Files, services []string) ([]descriptorRoute, error) {
+	routes := make([]descriptorRoute, 0)
+	found := make(map[string]bool)
+
+	var err error
+
+	files.RangeFiles(func(file protoreflect.FileDescriptor) bool {
+		for i := 0; i < file.Services().Len(); i++ {
+			service := file.Services().Get(i)
+
+			if len(services) > 0 && !contains(services, string(service.FullName())) {
+				continue
+			}
+
+			found[string(service.FullName())] = true
+
+			for j := 0; j < service.Methods().Len(); j++ {
+				method := service.Methods().Get(j)
+				options, ok := method.Options().(*descriptorpb.MethodOptions)
+
+				if !ok || !proto.HasExtension(options, annotations.E_Http) {
+					continue
+				}
+
+				if method.IsStreamingClient() {
+					grpclog.Warningf("Skipping client streaming method %s", method.FullName())
+					continue
+				}
+
+				rule := proto.GetExtension(options, annotations.E_Http).(*annotations.HttpRule)
+
+				for _, binding := range append([]*annotations.HttpRule{rule}, rule.GetAdditionalBindings()...) {
+					route, ok := newDescriptorRoute(method, binding)
+
+					if !ok {
+						err = fmt.Errorf("method %s has no HTTP pattern", method.FullName())
+						return false
+					}
+
+					routes = append(routes, route)
+				}
+			}
+		}
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, service := range services {
+		if !found[service] {
+			return nil, fmt.Errorf("unknown service %s", service)
+		}
+	}
+
+	return routes, nil
+}
+
+func newDescriptorRoute(method protoreflect.MethodDescriptor, rule *annotations.HttpRule) (descriptorRoute, bool) {
+	route := descriptorRoute{method: method, body: rule.GetBody(), responseBody: rule.GetResponseBody()}
+
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		route.verb, route.pattern = http.MethodGet, pattern.Get
+	case *annotations.HttpRule_Put:
+		route.verb, route.pattern = http.MethodPut, pattern.Put
+	case *annotations.HttpRule_Post:
+		route.verb, route.pattern = http.MethodPost, pattern.Post
+	case *annotations.HttpRule_Delete:
+		route.verb, route.pattern = http.MethodDelete, pattern.Delete
+	case *annotations.HttpRule_Patch:
+		route.verb, route.pattern = http.MethodPatch, pattern.Patch
+	case *annotations.HttpRule_Custom:
+		route.verb, route.pattern = pattern.Custom.GetKind(), pattern.Custom.GetPath()
+	default:
+		return route, false
+	}
+
+	return route, route.pattern != ""
+}
+
+// rpcName returns the gRPC full method name, e.g. "/package.Service/Method".
+func (route descriptorRoute) rpcName() string {
+	return fmt.Sprintf("/%s/%s", route.method.Parent().FullName(), route.method.Name())
+}
+
+// handler transcodes the requests to the route into calls over conn, like the generated handlers.
+func (route descriptorRoute) handler(mux *runtime.ServeMux, conn *grpc.ClientConn) runtime.HandlerFunc {
+	rpcName := route.rpcName()
+
+	return func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+
+		inboundMarshaler, outboundMarshaler := runtime.MarshalerForRequest(mux, req)
+
+		annotatedContext, err := runtime.AnnotateContext(ctx, mux, req, rpcName, runtime.WithHTTPPathPattern(route.pattern))
+
+		if err != nil {
+			runtime.HTTPError(ctx, mux, outboundMarshaler, w, req, err)
+			return
+		}
+
+		var md runtime.ServerMetadata
+
+		in, err := route.request(inboundMarshaler, req, pathParams)
+
+		if err != nil {
+			runtime.HTTPError(runtime.NewServerMetadataContext(annotatedContext, md), mux, outboundMarshaler, w, req, err)
+			return
+		}
+
+		if route.method.IsStreamingServer() {
+			stream, err := conn.NewStream(annotatedContext, &grpc.StreamDesc{ServerStreams: true}, rpcName, grpc.Header(&md.HeaderMD), grpc.Trailer(&md.TrailerMD))
+
+			if err == nil {
+				if err = stream.SendMsg(in); err == nil {
+					err = stream.CloseSend()
+				}
+			}
+
+			if err == nil {
+				md.HeaderMD, err = stream.Header()
+			}
+
+			annotatedContext = runtime.NewServerMetadataContext(annotatedContext, md)
+
+			if err != nil {
+				runtime.HTTPError(annotatedContext, mux, outboundMarshaler, w, req, err)
+				return
+			}
+
+			runtime.ForwardResponseStream(annotatedContext, mux, outboundMarshaler, w, req, func() (proto.Message, error) {
+				out := dynamicpb.NewMessage(route.method.Output())
+
+				if err := stream.RecvMsg(out); err != nil {
+					return nil, err
+				}
+
+				return route.response(out), nil
+			}, mux.GetForwardResponseOptions()...)
+
+			return
+		}
+
+		out := dynamicpb.NewMessage(route.method.Output())
+		err = conn.Invoke(annotatedContext, rpcName, in, out, grpc.Header(&md.HeaderMD), grpc.Trailer(&md.TrailerMD))
+		annotatedContext = runtime.NewServerMetadataContext(annotatedContext, md)
+
+		if err != nil {
+			runtime.HTTPError(annotatedContext, mux, outboundMarshaler, w, req, err)
+			return
+		}
+
+		runtime.ForwardResponseMessage(annotatedContext, mux, outboundMarshaler, w, req, route.response(out), mux.GetForwardResponseOptions()...)
+	}
+}
+
+// request builds the request message from the body, the path parameters and the query string.
+func (route descriptorRoute) request(marshaler runtime.Marshaler, req *http.Request, pathParams map[string]string) (proto.Message, error) {
+	in := dynamicpb.NewMessage(route.method.Input())
+	filter := make([][]string, 0, len(pathParams)+1)
+
+	switch route.body {
+	case "":
+	case "*":
+		if err := marshaler.NewDecoder(req.Body).Decode(in); err != nil && !errors.Is(err, io.EOF) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	default:
+		field := in.Descriptor().Fields().ByName(protoreflect.Name(route.body))
+
+		if field == nil || field.Message() == nil || field.IsList() || field.IsMap() {
+			return nil, status.Errorf(codes.Unimplemented, "unsupported body field %q", route.body)
+		}
+
+		body := in.Mutable(field).Message().Interface()
+
+		if err := marshaler.NewDecoder(req.Body).Decode(body); err != nil && !errors.Is(err, io.EOF) {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+
+		filter = append(filter, []string{route.body})
+	}
+
+	for name, value := range pathParams {
+		if err := runtime.PopulateFieldFromPath(in, name, value); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "type mismatch, parameter: %s, error: %v", name, err)
+		}
+
+		filter = append(filter, strings.Split(name, "."))
+	}
+
+	if route.body != "*" {
+		if err := req.ParseForm(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+
+		if err := runtime.PopulateQueryParameters(in, req.Form, utilities.NewDoubleArray(filter)); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+	}
+
+	return in, nil
+}
+
+// response selects the response_body field of out, if the route sets one.
+func (route descriptorRoute) response(out *dynamicpb.Message) proto.Message {
+	if route.responseBody == "" {
+		return out
+	}
+
+	field := out.Descriptor().Fields().ByName(protoreflect.Name(route.responseBody))
+
+	if field == nil {
+		return out
+	}
+
+	return &responseBodyMessage{out, field}
+}
+
+// responseBodyMessage makes ForwardResponseMessage marshal one field of the response, as the
+// generated response wrappers do.
+type responseBodyMessage struct {
+	*dynamicpb.Message
+	field protoreflect.FieldDescriptor
+}
+
+func (m *responseBodyMessage) XXX_ResponseBody() interface{} {
+	value := m.Get(m.field)
+
+	switch {
+	case m.field.IsList():
+		list := value.List()
+		items := make([]interface{}, list.Len())
+
+		for i := range items {
+			items[i] = protoValue(m.field, list.Get(i))
+		}
+
+		return items
+	case m.field.IsMap():
+		return value.Interface()
+	}
+
+	return protoValue(m.field, value)
+}
+
+func protoValue(field protoreflect.FieldDescriptor, value protoreflect.Value) interface{} {
+	if field.Message() != nil {
+		return value.Message().Interface()
+	}
+
+	return value.Interface()
+}
@@ -0,0 +1,224 @@
+package runtime
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testDescriptorSet describes test.v1.ItemService, whose methods echo their request as an Item.
+func testDescriptorSet() *descriptorpb.FileDescriptorSet {
+	field := func(name string, number int32, kind descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     kind.Enum(),
+		}
+
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+
+		return f
+	}
+
+	method := func(name, input string, rule *annotations.HttpRule, stream bool) *descriptorpb.MethodDescriptorProto {
+		options := &descriptorpb.MethodOptions{}
+		proto.SetExtension(options, annotations.E_Http, rule)
+
+		return &descriptorpb.MethodDescriptorProto{
+			Name:            proto.String(name),
+			InputType:       proto.String(input),
+			OutputType:      proto.String(".test.v1.Item"),
+			Options:         options,
+			ServerStreaming: proto.Bool(stream),
+		}
+	}
+
+	str, i32, msg := descriptorpb.FieldDescriptorProto_TYPE_STRING, descriptorpb.FieldDescriptorProto_TYPE_INT32, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test/v1/item.proto"),
+		Package: proto.String("test.v1"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{Name: proto.String("Item"), Field: []*descriptorpb.FieldDescriptorProto{field("name", 1, str, ""), field("page", 2, i32, ""), field("note", 3, str, "")}},
+			{Name: proto.String("GetItemRequest"), Field: []*descriptorpb.FieldDescriptorProto{field("name", 1, str, ""), field("page", 2, i32, "")}},
+			{Name: proto.String("CreateItemRequest"), Field: []*descriptorpb.FieldDescriptorProto{field("parent", 1, str, ""), field("item", 2, msg, ".test.v1.Item")}},
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("ItemService"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				method("GetItem", ".test.v1.GetItemRequest", &annotations.HttpRule{
+					Pattern: &annotations.HttpRule_Get{Get: "/v1/items/{name}"},
+					AdditionalBindings: []*annotations.HttpRule{
+						{Pattern: &annotations.HttpRule_Get{Get: "/v1/items/{name}/note"}, ResponseBody: "note"},
+					},
+				}, false),
+				method("CreateItem", ".test.v1.CreateItemRequest", &annotations.HttpRule{
+					Pattern: &annotations.HttpRule_Post{Post: "/v1/{parent=shelves/*}/items"},
+					Body:    "item",
+				}, false),
+				method("WatchItems", ".test.v1.GetItemRequest", &annotations.HttpRule{
+					Pattern: &annotations.HttpRule_Get{Get: "/v1/items/{name}:watch"},
+				}, true),
+			},
+		}},
+	}}}
+
+	// GetItem has no side effects, so that Connect clients may call it with GET
+	set.File[0].Service[0].Method[0].Options.IdempotencyLevel = descriptorpb.MethodOptions_NO_SIDE_EFFECTS.Enum()
+
+	return set
+}
+
+// startDescriptorServer serves the methods of set with descriptorEchoHandler. The register
+// functions add further services to the server.
+func startDescriptorServer(t *testing.T, set *descriptorpb.FileDescriptorSet, register ...func(*grpc.Server)) string {
+	server := grpc.NewServer(grpc.UnknownServiceHandler(descriptorEchoHandler(t, set)))
+
+	for _, r := range register {
+		r(server)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String()
+}
+
+// descriptorEchoHandler answers the methods of set with an Item built from the fields of the
+// request: name or parent, page, and the note of a nested item.
+func descriptorEchoHandler(t *testing.T, set *descriptorpb.FileDescriptorSet) grpc.StreamHandler {
+	files, err := protodesc.NewFiles(set)
+	assert.NoError(t, err)
+
+	return func(_ interface{}, stream grpc.ServerStream) error {
+		name, _ := grpc.MethodFromServerStream(stream)
+		d, err := files.FindDescriptorByName(protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(name, "/"), "/", ".")))
+
+		if err != nil {
+			return err
+		}
+
+		method := d.(protoreflect.MethodDescriptor)
+		in := dynamicpb.NewMessage(method.Input())
+
+		if err := stream.RecvMsg(in); err != nil {
+			return err
+		}
+
+		out := dynamicpb.NewMessage(method.Output())
+		fields := in.Descriptor().Fields()
+
+		for _, from := range []string{"name", "parent"} {
+			if f := fields.ByName(protoreflect.Name(from)); f != nil {
+				out.Set(out.Descriptor().Fields().ByName("name"), in.Get(f))
+			}
+		}
+
+		if f := fields.ByName("page"); f != nil {
+			out.Set(out.Descriptor().Fields().ByName("page"), in.Get(f))
+		}
+
+		if f := fields.ByName("item"); f != nil {
+			note := in.Get(f).Message().Get(f.Message().Fields().ByName("note"))
+			out.Set(out.Descriptor().Fields().ByName("note"), note)
+		}
+
+		if method.IsStreamingServer() {
+			for i := 0; i < 2; i++ {
+				if err := stream.SendMsg(out); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+
+		if note := out.Descriptor().Fields().ByName("note"); !out.Has(note) {
+			out.Set(note, protoreflect.ValueOfString("note:"+out.Get(out.Descriptor().Fields().ByName("name")).String()))
+		}
+
+		return stream.SendMsg(out)
+	}
+}
+
+func TestDescriptorEndpoint(t *testing.T) {
+	set := testDescriptorSet()
+	addr := startDescriptorServer(t, set)
+
+	data, err := proto.Marshal(set)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "services.binpb")
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dial := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	mux := runtime.NewServeMux()
+	err = DescriptorEndpoint(path)(ctx, mux, addr, dial)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{"path and query", "GET", "/v1/items/book?page=3", "", http.StatusOK, `{"name":"book","page":3,"note":"note:book"}`},
+		{"response body", "GET", "/v1/items/book/note", "", http.StatusOK, `"note:book"`},
+		{"body field", "POST", "/v1/shelves/1/items", `{"note":"hello"}`, http.StatusOK, `{"name":"shelves/1","page":0,"note":"hello"}`},
+		{"bad query", "GET", "/v1/items/book?page=x", "", http.StatusBadRequest, ""},
+		{"server stream", "GET", "/v1/items/book:watch?page=2", "", http.StatusOK, `{"result":{"name":"book","page":2,"note":""}}`},
+		{"unknown route", "GET", "/v1/shelves", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+
+			// protojson inserts random spaces, so that its output is not compared byte by byte
+			if tt.want != "" {
+				assert.Contains(t, strings.ReplaceAll(w.Body.String(), " ", ""), tt.want)
+			}
+		})
+	}
+
+	// Unknown services and unreadable sets are rejected
+	err = DescriptorEndpoint(path, "test.v1.OtherService")(ctx, runtime.NewServeMux(), addr, dial)
+	assert.ErrorContains(t, err, "unknown service")
+
+	err = DescriptorEndpoint(filepath.Join(t.TempDir(), "missing.binpb"))(ctx, runtime.NewServeMux(), addr, dial)
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package runtime
+
+import (
+	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+// ClientInterceptor is a pair of gRPC client interceptors installed on the backend connections.
+// Either of them may be nil.
+type ClientInterceptor struct {
+	Unary  grpc.UnaryClientInterceptor
+	Stream grpc.StreamClientInterceptor
+}
+
+// WithDialOptions is a GatewayOptionFunc that adds grpc.DialOption values used when dialing every
+// backend, such as keepalive parameters, message size limits or a default service config.
+// They are applied after the options the gateway derives from the backend settings, so they
+// take precedence over them, and before the options given to BackendDialOptions.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithDialOptions(
+//	        grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: 30 * time.Second}),
+//	        grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(16 << 20)),
+//	    ),
+//	)
+func WithDialOptions(opts ...grpc.DialOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.dialOpts = append(opt.dialOpts, opts...)
+	}
+}
+
+// WithClientInterceptors is a GatewayOptionFunc that adds client interceptors to the connections
+// of every backend. They run in the given order, before the interceptors of BackendInterceptors.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithClientInterceptors(ClientInterceptor{
+//	        Unary:  otelgrpc.UnaryClientInterceptor(),
+//	        Stream: otelgrpc.StreamClientInterceptor(),
+//	    }),
+//	)
+func WithClientInterceptors(interceptors ...ClientInterceptor) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.interceptors = append(opt.interceptors, interceptors...)
+	}
+}
+
+// BackendInterceptors adds client interceptors to the connections of the backend.
+func BackendInterceptors(interceptors ...ClientInterceptor) BackendOption {
+	return func(b *Backend) {
+		b.interceptors = append(b.interceptors, interceptors...)
+	}
+}
+
+// interceptorOptions chains the interceptors into dial options.
+func interceptorOptions(interceptors []ClientInterceptor) []grpc.DialOption {
+	unary := make([]grpc.UnaryClientInterceptor, 0, len(interceptors))
+	stream := make([]grpc.StreamClientInterceptor, 0, len(interceptors))
+
+	for _, i := range interceptors {
+		if i.Unary != nil {
+			unary = append(unary, i.Unary)
+		}
+
+		if i.Stream != nil {
+			stream = append(stream, i.Stream)
+		}
+	}
+
+	opts := make([]grpc.DialOption, 0, 2)
+
+	if len(unary) > 0 {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(unary...))
+	}
+
+	if len(stream) > 0 {
+		opts = append(opts, grpc.WithChainStreamInterceptor(stream...))
+	}
+
+	return opts
+}
+
+// clientOptions returns the dial options shared by every backend.
+func (o *GatewayOption) clientOptions() []grpc.DialOption {
+	opts := make([]grpc.DialOption, 0, len(o.dialOpts)+2)
+	opts = append(opts, o.dialOpts...)
+	interceptors := o.interceptors
+
+	if len(o.deadlines.methods) > 0 {
+		interceptors = append([]ClientInterceptor{o.deadlines.interceptor()}, interceptors...)
+	}
+
+	return append(opts, interceptorOptions(interceptors)...)
+}
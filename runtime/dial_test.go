@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"testing"
+	"time"
+)
+
+func recordInterceptor(calls *[]string, name string) ClientInterceptor {
+	return ClientInterceptor{
+		Unary: func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			*calls = append(*calls, name+" "+method)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		},
+	}
+}
+
+func TestWithClientInterceptors(t *testing.T) {
+	var count int32
+	calls := make([]string, 0)
+	addr := startHealthServer(t, &count)
+
+	server := newGatewayOption(
+		WithDialOptions(grpc.WithUserAgent("gateway")),
+		WithClientInterceptors(recordInterceptor(&calls, "first"), recordInterceptor(&calls, "second")),
+		WithNamedBackend("health", "127.0.0.1", 0,
+			BackendAddresses(addr),
+			BackendInterceptors(recordInterceptor(&calls, "backend")),
+		),
+	)
+
+	backend := server.backends["health"]
+	target, _ := backend.target()
+	opts, err := backend.dialOptions(server.retry, server.clientOptions()...)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	conn, err := grpc.NewClient(target, opts...)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"first /grpc.health.v1.Health/Check",
+		"second /grpc.health.v1.Health/Check",
+		"backend /grpc.health.v1.Health/Check",
+	}, calls)
+}
+
+func TestDialConfig_Options(t *testing.T) {
+	tests := []struct {
+		name   string
+		config DialConfig
+		count  int
+		err    string
+	}{
+		{"empty", DialConfig{}, 0, ""},
+		{"keepalive", DialConfig{Keepalive: &KeepaliveConfig{Time: Duration(30 * time.Second)}}, 1, ""},
+		{"call options", DialConfig{MaxRecvMsgSize: 1 << 20, MaxSendMsgSize: 1 << 20, Compression: "gzip"}, 1, ""},
+		{"unknown compressor", DialConfig{Compression: "lz4"}, 0, `unknown compressor "lz4"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := tt.config.options()
+
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Len(t, opts, tt.count)
+		})
+	}
+}
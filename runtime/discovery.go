@@ -0,0 +1,405 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	_ "google.golang.org/grpc/balancer/leastrequest"
+	"google.golang.org/grpc/resolver"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BalancingPolicy selects how calls are spread over the addresses of a backend.
+type BalancingPolicy string
+
+const (
+	// PickFirst sends every call to the first reachable address, which is the gRPC default.
+	PickFirst BalancingPolicy = "pick_first"
+	// RoundRobin rotates over the ready addresses.
+	RoundRobin BalancingPolicy = "round_robin"
+	// LeastRequest sends calls to the address with the fewest outstanding requests
+	// out of two picked at random.
+	LeastRequest BalancingPolicy = "least_request"
+	// Weighted rotates over the ready addresses in proportion to the weights set by
+	// BackendWeights or the target file.
+	Weighted BalancingPolicy = "weighted"
+)
+
+const (
+	discoveryScheme      = "gateway"
+	weightedBalancerName = "gateway_weighted_round_robin"
+)
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(weightedBalancerName, weightedPickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+// ParseBalancingPolicy validates the configuration name of a BalancingPolicy.
+func ParseBalancingPolicy(name string) (BalancingPolicy, error) {
+	switch policy := BalancingPolicy(strings.ToLower(name)); policy {
+	case PickFirst, RoundRobin, LeastRequest, Weighted:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown balancing policy %q", name)
+}
+
+func (p BalancingPolicy) config() (string, error) {
+	switch p {
+	case PickFirst, RoundRobin:
+		return fmt.Sprintf(`{%q:{}}`, string(p)), nil
+	case LeastRequest:
+		return `{"least_request_experimental":{"choiceCount":2}}`, nil
+	case Weighted:
+		return fmt.Sprintf(`{%q:{}}`, weightedBalancerName), nil
+	}
+
+	return "", fmt.Errorf("unknown balancing policy %q", string(p))
+}
+
+// BackendBalancer sets the balancing policy for the addresses of the backend.
+// Without an address source the backend host is then resolved through DNS,
+// so that every address it resolves to receives calls.
+func BackendBalancer(policy BalancingPolicy) BackendOption {
+	return func(b *Backend) {
+		b.balancer = policy
+	}
+}
+
+// BackendAddresses makes the backend a fixed list of "host:port" addresses.
+func BackendAddresses(addrs ...string) BackendOption {
+	return func(b *Backend) {
+		b.source = &staticSource{addrs: addrs}
+	}
+}
+
+// BackendDNS makes the backend the addresses name resolves to, looked up again every interval.
+func BackendDNS(name string, port uint, interval time.Duration) BackendOption {
+	return func(b *Backend) {
+		b.source = &dnsSource{name: name, port: port, interval: interval}
+	}
+}
+
+// BackendTargetFile makes the backend the addresses listed in the file at path, which is
+// watched for changes. Each line holds a "host:port" address optionally followed by its
+// weight; empty lines and lines starting with "#" are ignored.
+func BackendTargetFile(path string) BackendOption {
+	return func(b *Backend) {
+		b.source = &fileSource{path: path}
+	}
+}
+
+// BackendWeights sets the weights of the backend addresses for the Weighted policy.
+// Addresses without a weight have the weight 1.
+func BackendWeights(weights map[string]uint32) BackendOption {
+	return func(b *Backend) {
+		b.weights = weights
+	}
+}
+
+// addressSource discovers the addresses of a backend.
+type addressSource interface {
+	// watch reports the addresses, and later their changes, until ctx is canceled.
+	// refresh asks for an immediate lookup.
+	watch(ctx context.Context, refresh <-chan struct{}, update func([]weightedAddr, error))
+	String() string
+}
+
+type weightedAddr struct {
+	addr   string
+	weight uint32
+}
+
+type staticSource struct {
+	addrs []string
+}
+
+func (s *staticSource) watch(_ context.Context, _ <-chan struct{}, update func([]weightedAddr, error)) {
+	addrs := make([]weightedAddr, 0, len(s.addrs))
+
+	for _, addr := range s.addrs {
+		addrs = append(addrs, weightedAddr{addr: addr})
+	}
+
+	update(addrs, nil)
+}
+
+func (s *staticSource) String() string {
+	return strings.Join(s.addrs, ",")
+}
+
+type dnsSource struct {
+	name     string
+	port     uint
+	interval time.Duration
+}
+
+func (s *dnsSource) watch(ctx context.Context, refresh <-chan struct{}, update func([]weightedAddr, error)) {
+	interval := s.interval
+
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			hosts, err := net.DefaultResolver.LookupHost(ctx, s.name)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				update(nil, fmt.Errorf("failed to resolve %s: %s", s.name, err))
+			} else {
+				addrs := make([]weightedAddr, 0, len(hosts))
+
+				for _, host := range hosts {
+					addrs = append(addrs, weightedAddr{addr: net.JoinHostPort(host, strconv.Itoa(int(s.port)))})
+				}
+
+				update(addrs, nil)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-refresh:
+			}
+		}
+	}()
+}
+
+func (s *dnsSource) String() string {
+	return fmt.Sprintf("dns:%s:%d", s.name, s.port)
+}
+
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) watch(ctx context.Context, _ <-chan struct{}, update func([]weightedAddr, error)) {
+	read := func() {
+		addrs, err := readTargetFile(s.path)
+		update(addrs, err)
+	}
+
+	read()
+
+	stop, err := watchFiles([]string{s.path}, read, func(err error) {
+		update(nil, err)
+	})
+
+	if err != nil {
+		update(nil, err)
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+}
+
+func (s *fileSource) String() string {
+	return "file:" + s.path
+}
+
+func readTargetFile(path string) ([]weightedAddr, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target file %s: %s", path, err)
+	}
+
+	defer file.Close()
+
+	addrs := make([]weightedAddr, 0)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		addr := weightedAddr{addr: fields[0]}
+
+		if len(fields) > 1 {
+			weight, err := strconv.ParseUint(fields[1], 10, 32)
+
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight for %s in target file %s: %s", fields[0], path, err)
+			}
+
+			addr.weight = uint32(weight)
+		}
+
+		addrs = append(addrs, addr)
+	}
+
+	return addrs, scanner.Err()
+}
+
+// discoveryBuilder is a resolver.Builder handing the addresses of one backend to the
+// connections dialed for it. It is passed with grpc.WithResolvers, so nothing is registered
+// globally and every backend can use the same scheme.
+type discoveryBuilder struct {
+	source  addressSource
+	weights map[string]uint32
+}
+
+func (d *discoveryBuilder) Scheme() string {
+	return discoveryScheme
+}
+
+func (d *discoveryBuilder) Build(_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &discoveryResolver{cancel: cancel, refresh: make(chan struct{}, 1)}
+
+	d.source.watch(ctx, r.refresh, func(addrs []weightedAddr, err error) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Keep serving the last known addresses when a refresh fails
+		if err != nil {
+			if r.last == nil {
+				cc.ReportError(err)
+			}
+
+			return
+		}
+
+		state := d.state(addrs)
+
+		if r.last != nil && reflect.DeepEqual(r.last, state.Addresses) {
+			return
+		}
+
+		r.last = state.Addresses
+		_ = cc.UpdateState(state)
+	})
+
+	return r, nil
+}
+
+func (d *discoveryBuilder) state(addrs []weightedAddr) resolver.State {
+	sort.Slice(addrs, func(i, j int) bool {
+		return addrs[i].addr < addrs[j].addr
+	})
+
+	values := make([]resolver.Address, 0, len(addrs))
+
+	for _, addr := range addrs {
+		weight := addr.weight
+
+		if w, ok := d.weights[addr.addr]; ok && weight == 0 {
+			weight = w
+		}
+
+		if weight == 0 {
+			weight = 1
+		}
+
+		values = append(values, resolver.Address{
+			Addr:               addr.addr,
+			BalancerAttributes: attributes.New(weightKey{}, weight),
+		})
+	}
+
+	return resolver.State{Addresses: values}
+}
+
+type discoveryResolver struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	refresh chan struct{}
+	last    []resolver.Address
+}
+
+func (r *discoveryResolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.refresh <- struct{}{}:
+	default:
+	}
+}
+
+func (r *discoveryResolver) Close() {
+	r.cancel()
+}
+
+type weightKey struct{}
+
+// weightedPickerBuilder builds smooth weighted round robin pickers over the ready addresses.
+type weightedPickerBuilder struct{}
+
+func (weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &weightedPicker{}
+
+	for sc, sci := range info.ReadySCs {
+		weight, _ := sci.Address.BalancerAttributes.Value(weightKey{}).(uint32)
+
+		if weight == 0 {
+			weight = 1
+		}
+
+		p.items = append(p.items, &weightedItem{sc: sc, weight: int64(weight)})
+		p.total += int64(weight)
+	}
+
+	return p
+}
+
+type weightedItem struct {
+	sc      balancer.SubConn
+	weight  int64
+	current int64
+}
+
+type weightedPicker struct {
+	mu    sync.Mutex
+	items []*weightedItem
+	total int64
+}
+
+func (p *weightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *weightedItem
+
+	for _, item := range p.items {
+		item.current += item.weight
+
+		if best == nil || item.current > best.current {
+			best = item
+		}
+	}
+
+	best.current -= p.total
+
+	return balancer.PickResult{SubConn: best.sc}, nil
+}
@@ -0,0 +1,162 @@
+package runtime
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// startHealthServer serves the gRPC health service on a free port, counting the calls it receives.
+func startHealthServer(t *testing.T, calls *int32) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		atomic.AddInt32(calls, 1)
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String()
+}
+
+func TestBackend_Balancer(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy BalancingPolicy
+		weight uint32
+		expect [2]int32
+	}{
+		{"round robin", RoundRobin, 1, [2]int32{10, 10}},
+		{"least request", LeastRequest, 1, [2]int32{-1, -1}},
+		{"weighted", Weighted, 3, [2]int32{15, 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first, second int32
+
+			a, b := startHealthServer(t, &first), startHealthServer(t, &second)
+
+			backend := &Backend{name: "health"}
+			BackendAddresses(a, b)(backend)
+			BackendBalancer(tt.policy)(backend)
+			BackendWeights(map[string]uint32{a: tt.weight})(backend)
+
+			target, err := backend.target()
+			assert.NoError(t, err)
+			assert.Equal(t, "gateway:///health", target)
+
+			opts, err := backend.dialOptions(retrySettings{})
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			conn, err := grpc.NewClient(target, opts...)
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			defer conn.Close()
+
+			client := grpc_health_v1.NewHealthClient(conn)
+
+			// Warm up until both addresses are ready and have been picked once
+			for i := 0; i < 100 && (atomic.LoadInt32(&first) == 0 || atomic.LoadInt32(&second) == 0); i++ {
+				_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true))
+				assert.NoError(t, err)
+			}
+
+			atomic.StoreInt32(&first, 0)
+			atomic.StoreInt32(&second, 0)
+
+			for i := 0; i < 20; i++ {
+				_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true))
+				assert.NoError(t, err)
+			}
+
+			// Sequential calls leave no outstanding requests to compare
+			if tt.expect[0] < 0 {
+				assert.Equal(t, int32(20), first+second)
+				return
+			}
+
+			assert.InDelta(t, tt.expect[0], first, 2)
+			assert.InDelta(t, tt.expect[1], second, 2)
+		})
+	}
+}
+
+func TestReadTargetFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets")
+	content := "# backends\n10.0.0.1:9090 3\n\n10.0.0.2:9090\n"
+
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	addrs, err := readTargetFile(path)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []weightedAddr{{"10.0.0.1:9090", 3}, {"10.0.0.2:9090", 0}}, addrs)
+
+	if err := os.WriteFile(path, []byte("10.0.0.1:9090 heavy\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readTargetFile(path)
+	assert.ErrorContains(t, err, "invalid weight")
+}
+
+func TestBackend_ServiceConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []BackendOption
+		target string
+		config string
+	}{
+		{"single address", nil, "10.0.0.1:9090", ""},
+		{"dns balanced", []BackendOption{BackendBalancer(RoundRobin)}, "dns:///10.0.0.1:9090", `{"loadBalancingConfig":[{"round_robin":{}}]}`},
+		{"target file", []BackendOption{BackendTargetFile("targets")}, "gateway:///users", `{"loadBalancingConfig":[{"round_robin":{}}]}`},
+		{"least request", []BackendOption{BackendDNS("users.internal", 9090, 0), BackendBalancer(LeastRequest)}, "gateway:///users", `{"loadBalancingConfig":[{"least_request_experimental":{"choiceCount":2}}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &Backend{name: "users", server: ServerInfo{"10.0.0.1", 9090}}
+
+			for _, o := range tt.opts {
+				o(backend)
+			}
+
+			target, err := backend.target()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.target, target)
+
+			config, err := backend.serviceConfig(retrySettings{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.config, config)
+		})
+	}
+
+	_, err := ParseBalancingPolicy("random")
+	assert.ErrorContains(t, err, "unknown balancing policy")
+}
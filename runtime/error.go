@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"context"
+	"errors"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/grpclog"
@@ -25,13 +26,14 @@ func (e *ErrorResult) HttpStatus(status int) ErrorResult {
 	return *e
 }
 
+// WithErrorHandler is a GatewayOptionFunc that registers the ErrorHandleCallback values answering
+// the errors of the backends. The error handler is installed on every gateway, so the callbacks
+// can also be added later with further WithErrorHandler options.
 func WithErrorHandler(handles ...ErrorHandleReturn) GatewayOptionFunc {
 	return func(opt *GatewayOption) {
 		for _, handle := range handles {
 			handle(opt)
 		}
-
-		opt.muxOpts = append(opt.muxOpts, errorCapture(*opt))
 	}
 }
 
@@ -43,45 +45,98 @@ func ErrorHandle(code codes.Code, callback ErrorHandleCallback) ErrorHandleRetur
 	}
 }
 
-func errorCapture(opt GatewayOption) runtime.ServeMuxOption {
-	const fallback = `{"code": 13, "message": "failed to marshal error message"}`
-
+func errorCapture(opt *GatewayOption) runtime.ServeMuxOption {
 	return runtime.WithErrorHandler(func(ctx context.Context, mux *runtime.ServeMux, marshal runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
-		s, ok := status.FromError(err)
+		s, result := opt.errorResult(ctx, mux, w, r, err)
 
-		if !ok {
-			s = status.New(codes.Unknown, err.Error())
+		if result != nil {
+			writeErrorResult(marshal, w, s, result)
+			return
 		}
 
-		if callback := opt.errors[s.Code()]; callback != nil {
-			if r := callback(ctx, mux, w, r, s); r != nil {
-				contentType := marshal.ContentType(r)
-				w.Header().Set("Content-Type", contentType)
-
-				buf, err := marshal.Marshal(r.Message)
-				if err != nil {
-					grpclog.Errorf("Failed to marshal error message %q: %v", s, err)
-					w.WriteHeader(http.StatusInternalServerError)
-					if _, err := io.WriteString(w, fallback); err != nil {
-						grpclog.Errorf("Failed to write response: %v", err)
-					}
-					return
-				}
-
-				if r.status == nil {
-					w.WriteHeader(runtime.HTTPStatusFromCode(s.Code()))
-				} else {
-					w.WriteHeader(*r.status)
-				}
-
-				if _, err := w.Write(buf); err != nil {
-					grpclog.Errorf("Failed to write response: %v", err)
-				}
-
-				return
-			}
+		// Deadlines expiring in the gateway itself are reported as 504 like those of the backend
+		if _, ok := status.FromError(err); !ok {
+			err = s.Err()
 		}
 
 		runtime.DefaultHTTPErrorHandler(ctx, mux, marshal, w, r, err)
 	})
 }
+
+// errorResult returns the status of err and the ErrorResult answering it, that of an open circuit
+// breaker or of the ErrorHandleCallback of its code, or nil to answer the status as it is.
+func (o *GatewayOption) errorResult(ctx context.Context, mux *runtime.ServeMux, w http.ResponseWriter, r *http.Request, err error) (*status.Status, *ErrorResult) {
+	s, ok := status.FromError(err)
+
+	if !ok {
+		s = status.FromContextError(err)
+	}
+
+	var open *CircuitOpenError
+
+	if errors.As(err, &open) && open.result != nil {
+		return s, open.result
+	}
+
+	if callback := o.errors[s.Code()]; callback != nil {
+		return s, callback(ctx, mux, w, r, s)
+	}
+
+	return s, nil
+}
+
+type serveMuxKey struct{}
+
+// withServeMux makes the mux available to httpError for the requests served by h.
+func withServeMux(h http.Handler, mux *runtime.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), serveMuxKey{}, mux)))
+	})
+}
+
+// httpError answers err through the error handler of the mux serving r, so that the handlers in
+// front of the mux render their errors like those of the backends, ErrorHandleCallback included.
+// Native gRPC calls are answered with the gRPC status of err.
+func httpError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.ProtoMajor == 2 && isGRPC(r) {
+		grpcError(w, err)
+		return
+	}
+
+	mux, ok := r.Context().Value(serveMuxKey{}).(*runtime.ServeMux)
+
+	if !ok {
+		mux = runtime.NewServeMux()
+	}
+
+	_, marshal := runtime.MarshalerForRequest(mux, r)
+	runtime.HTTPError(r.Context(), mux, marshal, w, r, err)
+}
+
+// writeErrorResult writes r as the response to the error status s.
+func writeErrorResult(marshal runtime.Marshaler, w http.ResponseWriter, s *status.Status, r *ErrorResult) {
+	const fallback = `{"code": 13, "message": "failed to marshal error message"}`
+
+	contentType := marshal.ContentType(r)
+	w.Header().Set("Content-Type", contentType)
+
+	buf, err := marshal.Marshal(r.Message)
+	if err != nil {
+		grpclog.Errorf("Failed to marshal error message %q: %v", s, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		if _, err := io.WriteString(w, fallback); err != nil {
+			grpclog.Errorf("Failed to write response: %v", err)
+		}
+		return
+	}
+
+	if r.status == nil {
+		w.WriteHeader(runtime.HTTPStatusFromCode(s.Code()))
+	} else {
+		w.WriteHeader(*r.status)
+	}
+
+	if _, err := w.Write(buf); err != nil {
+		grpclog.Errorf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+)
+
+// isGRPCWeb reports whether r is a gRPC-Web call, in the binary or the base64 text format.
+func isGRPCWeb(r *http.Request) bool {
+	return r.Method == http.MethodPost && strings.HasPrefix(mediaType(r), grpcWebContentType)
+}
+
+// serveGRPCWeb answers a gRPC-Web call. The response always carries the status in a trailer
+// message, also for calls failing before any message is sent.
+func serveGRPCWeb(w http.ResponseWriter, r *http.Request, call *proxyCall) {
+	ctx := r.Context()
+
+	// Without route deadlines the Grpc-Timeout header is still in place
+	if timeout, ok := parseGrpcTimeout(r.Header.Get(grpcTimeoutHeader)); ok {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ct := mediaType(r)
+	text := strings.HasPrefix(ct, grpcWebTextContentType)
+
+	var body io.Reader = r.Body
+
+	// The body is bounded by maxProxyRequestSize, so that the text can be decoded at once
+	if text {
+		data, err := io.ReadAll(r.Body)
+
+		if err == nil {
+			data, err = decodeGRPCWebText(data)
+		}
+
+		if err != nil && call.err == nil {
+			call.err = readError(err)
+		}
+
+		body = bytes.NewReader(data)
+	}
+
+	next := func() ([]byte, error) {
+		for {
+			flags, msg, err := readEnvelope(body)
+
+			switch {
+			case err != nil:
+				return nil, err
+			case flags&envelopeCompressed != 0:
+				return nil, status.Error(codes.Unimplemented, "compressed gRPC-Web messages are not supported")
+			case flags&envelopeTrailer == 0:
+				return msg, nil
+			}
+		}
+	}
+
+	if !strings.Contains(ct, "+") {
+		ct += "+proto"
+	}
+
+	w.Header().Set("Content-Type", ct)
+
+	write := func(frame []byte) {
+		if text {
+			frame = []byte(base64.StdEncoding.EncodeToString(frame))
+		}
+
+		_, _ = w.Write(frame)
+
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	started := false
+
+	start := func() {
+		if !started {
+			writeMetadataHeaders(w.Header(), call.header, "")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+	}
+
+	st := call.invoke(ctx, next, func(msg []byte) error {
+		start()
+		write(appendEnvelope(nil, 0, msg))
+
+		return nil
+	})
+
+	start()
+	write(appendEnvelope(nil, envelopeTrailer, grpcWebTrailer(st, call.trailer)))
+}
+
+// grpcWebTrailer encodes the status and trailer of a call as the header block of a trailer message.
+func grpcWebTrailer(st *status.Status, trailer metadata.MD) []byte {
+	var buf bytes.Buffer
+
+	_, _ = fmt.Fprintf(&buf, "grpc-status: %d\r\n", st.Code())
+
+	if st.Message() != "" {
+		_, _ = fmt.Fprintf(&buf, "grpc-message: %s\r\n", url.PathEscape(st.Message()))
+	}
+
+	if len(st.Proto().GetDetails()) > 0 {
+		if bin, err := proto.Marshal(st.Proto()); err == nil {
+			_, _ = fmt.Fprintf(&buf, "grpc-status-details-bin: %s\r\n", base64.RawStdEncoding.EncodeToString(bin))
+		}
+	}
+
+	h := make(http.Header)
+	writeMetadataHeaders(h, trailer, "")
+
+	for key, values := range h {
+		for _, value := range values {
+			_, _ = fmt.Fprintf(&buf, "%s: %s\r\n", strings.ToLower(key), value)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// decodeGRPCWebText decodes a gRPC-Web text body, which clients may send as several base64
+// chunks with their own padding.
+func decodeGRPCWebText(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	out := make([]byte, 0, base64.StdEncoding.DecodedLen(len(data)))
+
+	for len(data) > 0 {
+		end := len(data)
+
+		// A padded chunk ends with the quantum holding the padding
+		if i := bytes.IndexByte(data, '='); i >= 0 {
+			end = i + (4-i%4)%4
+
+			if end == 0 || end > len(data) {
+				return nil, status.Error(codes.InvalidArgument, "invalid gRPC-Web text body: misplaced padding")
+			}
+		}
+
+		buf, err := base64.StdEncoding.DecodeString(string(data[:end]))
+
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid gRPC-Web text body: %v", err)
+		}
+
+		out = append(out, buf...)
+		data = data[end:]
+	}
+
+	return out, nil
+}
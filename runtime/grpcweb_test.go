@@ -0,0 +1,104 @@
+package runtime
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGRPCWeb(t *testing.T) {
+	handler := startRPCProxy(t, WithGRPCWeb(RPCProxyMethods("/test.v1.ItemService/*")))
+
+	request := testItemBytes(t, map[string]interface{}{"name": "book"})
+
+	tests := []struct {
+		name   string
+		method string
+		ct     string
+		body   []byte
+		items  []string
+		status string
+	}{
+		{"unary", "/test.v1.ItemService/GetItem", "application/grpc-web+proto", appendEnvelope(nil, 0, request), []string{"book"}, "0"},
+		{"text", "/test.v1.ItemService/GetItem", "application/grpc-web-text", appendEnvelope(nil, 0, request), []string{"book"}, "0"},
+		{"server stream", "/test.v1.ItemService/WatchItems", "application/grpc-web", appendEnvelope(nil, 0, request), []string{"book", "book"}, "0"},
+		{"backend error", "/test.v1.ItemService/DeleteItem", "application/grpc-web", appendEnvelope(nil, 0, request), nil, "2"},
+		{"not allowed", "/grpc.health.v1.Health/Check", "application/grpc-web", appendEnvelope(nil, 0, nil), nil, "12"},
+		{"compressed", "/test.v1.ItemService/GetItem", "application/grpc-web", appendEnvelope(nil, envelopeCompressed, request), nil, "12"},
+		{"truncated", "/test.v1.ItemService/GetItem", "application/grpc-web", appendEnvelope(nil, 0, request)[:4], nil, "3"},
+		{"truncated after a message", "/test.v1.ItemService/WatchItems", "application/grpc-web", append(appendEnvelope(nil, 0, request), 0, 0, 0), nil, "3"},
+		{"too large", "/test.v1.ItemService/GetItem", "application/grpc-web-text", bytes.Repeat(appendEnvelope(nil, 0, make([]byte, 1<<20)), 25), nil, "8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := tt.body
+
+			if tt.ct == "application/grpc-web-text" {
+				// Text clients may send the messages as separately padded chunks
+				half := len(body) / 2
+				body = []byte(base64.StdEncoding.EncodeToString(body[:half]) + base64.StdEncoding.EncodeToString(body[half:]))
+			}
+
+			r := httptest.NewRequest("POST", tt.method, bytes.NewReader(body))
+			r.Header.Set("Content-Type", tt.ct)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+
+			out := w.Body.Bytes()
+
+			if tt.ct == "application/grpc-web-text" {
+				assert.Equal(t, "application/grpc-web-text+proto", w.Header().Get("Content-Type"))
+
+				var err error
+				out, err = decodeGRPCWebText(out)
+				assert.NoError(t, err)
+			}
+
+			reader := bytes.NewReader(out)
+			items := make([]string, 0)
+
+			for {
+				flags, msg, err := readEnvelope(reader)
+
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				if flags&envelopeTrailer != 0 {
+					assert.Contains(t, string(msg), "grpc-status: "+tt.status+"\r\n")
+					break
+				}
+
+				items = append(items, testItemName(t, msg))
+			}
+
+			assert.ElementsMatch(t, tt.items, items)
+			assert.Equal(t, 0, reader.Len())
+		})
+	}
+
+	// Other requests are left to the REST routes
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/test.v1.ItemService/GetItem", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDecodeGRPCWebText(t *testing.T) {
+	buf, err := decodeGRPCWebText([]byte("YQ==YmM=ZGVm"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef", string(buf))
+
+	_, err = decodeGRPCWebText([]byte("=YWJj"))
+	assert.Error(t, err)
+
+	_, err = decodeGRPCWebText([]byte("YW*j"))
+	assert.Error(t, err)
+}
@@ -7,7 +7,7 @@ import (
 	"time"
 )
 
-var startTime time.Time
+var startTime = time.Now()
 
 // HealthCheckResponse は死活状況のレスポンス構造体
 type HealthCheckResponse struct {
@@ -17,24 +17,34 @@ type HealthCheckResponse struct {
 }
 
 type StatusResponse struct {
-	Alloc        uint64 `json:"alloc"`
-	TotalAlloc   uint64 `json:"totalAlloc"`
-	Sys          uint64 `json:"sys"`
-	NumGC        uint32 `json:"numGC"`
-	NumGoroutine int    `json:"numProcess"`
-	RequestCount int    `json:"requestCount"`
+	Alloc        uint64              `json:"alloc"`
+	TotalAlloc   uint64              `json:"totalAlloc"`
+	Sys          uint64              `json:"sys"`
+	NumGC        uint32              `json:"numGC"`
+	NumGoroutine int                 `json:"numProcess"`
+	RequestCount int                 `json:"requestCount"`
+	Certificates []CertificateStatus `json:"certificates,omitempty"`
+	Breakers     []BreakerStatus     `json:"breakers,omitempty"`
+}
+
+// CertificateStatus describes a certificate served by the TLS listener,
+// so that its expiry can be alerted on before it happens.
+type CertificateStatus struct {
+	Subject   string   `json:"subject"`
+	DNSNames  []string `json:"dnsNames,omitempty"`
+	NotAfter  string   `json:"notAfter"`
+	ExpiresIn float64  `json:"expiresIn"`
 }
 
 func WithHealthCheckPathHandle(endpoint string) GatewayOptionFunc {
 	return func(opt *GatewayOption) {
-		startTime = time.Now()
 		opt.paths = append(opt.paths, PathHandler{"GET", endpoint, HealthCheckPathHandle})
 	}
 }
 
 func WithStatusPathHandle(endpoint string) GatewayOptionFunc {
 	return func(opt *GatewayOption) {
-		opt.paths = append(opt.paths, PathHandler{"GET", endpoint, StatusCheckPathHandle})
+		opt.paths = append(opt.paths, PathHandler{"GET", endpoint, opt.statusPathHandle})
 	}
 }
 
@@ -57,10 +67,27 @@ func HealthCheckPathHandle(w http.ResponseWriter, _ *http.Request, _ map[string]
 }
 
 func StatusCheckPathHandle(w http.ResponseWriter, _ *http.Request, _ map[string]string) {
+	writeStatus(w, newStatusResponse())
+}
+
+// statusPathHandle extends the process status with the state of the running gateway.
+func (o *GatewayOption) statusPathHandle(w http.ResponseWriter, _ *http.Request, _ map[string]string) {
+	response := newStatusResponse()
+
+	if certs := o.state.certs.Load(); certs != nil {
+		response.Certificates = certs.status()
+	}
+
+	response.Breakers = o.state.breakers.status()
+
+	writeStatus(w, response)
+}
+
+func newStatusResponse() StatusResponse {
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
 
-	response := StatusResponse{
+	return StatusResponse{
 		Alloc:        memStats.Alloc,
 		TotalAlloc:   memStats.TotalAlloc,
 		Sys:          memStats.Sys,
@@ -68,7 +95,9 @@ func StatusCheckPathHandle(w http.ResponseWriter, _ *http.Request, _ map[string]
 		NumGoroutine: runtime.NumGoroutine(),
 		RequestCount: 0,
 	}
+}
 
+func writeStatus(w http.ResponseWriter, response StatusResponse) {
 	w.Header().Set("Content-Type", "application/json")
 
 	err := json.NewEncoder(w).Encode(response)
@@ -0,0 +1,96 @@
+package runtime
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+// Identity is the authenticated client of a request, set by the authentication handlers.
+type Identity struct {
+	// Subject identifies the client, e.g. the sub claim of a JWT or the name of an API key.
+	Subject string
+	// Scheme is the authentication scheme, "jwt" or "api_key".
+	Scheme string
+	// Scopes are the permissions granted to the client.
+	Scopes []string
+	// Roles are the roles of the client.
+	Roles []string
+	// Claims are the JWT claims or the attributes of the API key.
+	Claims map[string]interface{}
+
+	// metadata is forwarded to the backends
+	metadata metadata.MD
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the Identity authenticated for the request of ctx.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+
+	return id, ok
+}
+
+func withIdentity(r *http.Request, id *Identity) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+}
+
+// identityMetadata forwards the metadata of the authenticated Identity to the backend.
+func identityMetadata(ctx context.Context, _ *http.Request) metadata.MD {
+	if id, ok := IdentityFromContext(ctx); ok && id.metadata != nil {
+		return id.metadata
+	}
+
+	return metadata.MD{}
+}
+
+// stripMetadataHeaders removes the headers through which a client could set the metadata keys
+// forwarded from the identity itself.
+func stripMetadataHeaders(r *http.Request, keys []string) {
+	for _, key := range keys {
+		r.Header.Del(key)
+		r.Header.Del("Grpc-Metadata-" + key)
+	}
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+
+	return strings.TrimSpace(token)
+}
+
+// exempted reports whether the path of r matches one of the patterns, see WithRouteTimeout.
+func exempted(r *http.Request, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matchPath(pattern, r.URL.Path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// unauthenticated answers Unauthenticated with the challenge in the WWW-Authenticate header,
+// which the error handler of grpc-gateway would set to the status message otherwise.
+func unauthenticated(w http.ResponseWriter, r *http.Request, challenge, message string) {
+	httpError(&challengeWriter{ResponseWriter: w, challenge: challenge}, r, status.Error(codes.Unauthenticated, message))
+}
+
+type challengeWriter struct {
+	http.ResponseWriter
+	challenge string
+}
+
+func (w *challengeWriter) WriteHeader(code int) {
+	w.Header().Set("WWW-Authenticate", w.challenge)
+	w.ResponseWriter.WriteHeader(code)
+}
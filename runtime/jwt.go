@@ -0,0 +1,545 @@
+package runtime
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultJWTAlgorithms are the signing algorithms accepted unless JWTAlgorithms is given.
+var DefaultJWTAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+	"HS256", "HS384", "HS512",
+}
+
+type JWTOption func(*jwtAuth)
+
+type jwtAuth struct {
+	keys       *jwksSource
+	issuers    []string
+	audiences  []string
+	skew       time.Duration
+	algorithms []string
+	exempt     []string
+	claims     map[string]string
+}
+
+// WithJWTAuth is a GatewayOptionFunc that adds JWTAuthHandler to the handler chain.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithJWTAuth(
+//	        JWKSURL("https://auth.example.com/.well-known/jwks.json", 10*time.Minute),
+//	        JWTIssuer("https://auth.example.com/"),
+//	        JWTAudience("api"),
+//	        JWTExemptPaths("/ping/heartbeat"),
+//	        JWTClaimMetadata("sub", "x-user-id"),
+//	    ),
+//	)
+func WithJWTAuth(opts ...JWTOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.handlers = append(opt.handlers, JWTAuthHandler(opts...))
+	}
+}
+
+// JWTAuthHandler returns a GatewayHandler that requires a valid bearer JWT on every request but
+// those to the exempt paths, and makes its claims available with IdentityFromContext.
+// The token must be signed by one of the keys of the JWKS, be within its validity period and, when
+// set, name one of the issuers and audiences. Other requests are answered with Unauthenticated,
+// that is 401 Unauthorized, through the error handler of the gateway. Requests already
+// authenticated by a previous handler of the chain are passed through.
+func JWTAuthHandler(opts ...JWTOption) GatewayHandler {
+	a := &jwtAuth{
+		skew:       time.Minute,
+		algorithms: DefaultJWTAlgorithms,
+		claims:     make(map[string]string),
+	}
+
+	for _, o := range opts {
+		o(a)
+	}
+
+	keys := make([]string, 0, len(a.claims))
+
+	for _, key := range a.claims {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return func(h http.Handler, _ *GatewayOption) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			stripMetadataHeaders(r, keys)
+
+			if _, ok := IdentityFromContext(r.Context()); ok || exempted(r, a.exempt) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			token := bearerToken(r)
+
+			if token == "" {
+				unauthenticated(w, r, "Bearer", "missing bearer token")
+				return
+			}
+
+			id, err := a.authenticate(r.Context(), token)
+
+			if err != nil {
+				unauthenticated(w, r, `Bearer error="invalid_token"`, "invalid bearer token")
+				return
+			}
+
+			h.ServeHTTP(w, withIdentity(r, id))
+		})
+	}
+}
+
+// JWKSFile reads the keys verifying the tokens from a JWKS file, which is read again every 10
+// seconds or when a token names an unknown key.
+func JWKSFile(path string) JWTOption {
+	return func(a *jwtAuth) {
+		a.keys = newJWKSSource(func(context.Context) ([]byte, error) {
+			return os.ReadFile(path)
+		}, 10*time.Second)
+	}
+}
+
+// JWKSURL fetches the keys verifying the tokens from a JWKS URL, again after refresh or when a
+// token names an unknown key, so that rotated keys are picked up. The last keys fetched are kept
+// while the URL fails.
+func JWKSURL(url string, refresh time.Duration) JWTOption {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return func(a *jwtAuth) {
+		a.keys = newJWKSSource(func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			res, err := client.Do(req)
+
+			if err != nil {
+				return nil, err
+			}
+
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("failed to fetch JWKS %s: %s", url, res.Status)
+			}
+
+			return io.ReadAll(io.LimitReader(res.Body, 1<<20))
+		}, refresh)
+	}
+}
+
+// JWTIssuer accepts the tokens issued by one of issuers only.
+func JWTIssuer(issuers ...string) JWTOption {
+	return func(a *jwtAuth) {
+		a.issuers = append(a.issuers, issuers...)
+	}
+}
+
+// JWTAudience accepts the tokens intended for one of audiences only.
+func JWTAudience(audiences ...string) JWTOption {
+	return func(a *jwtAuth) {
+		a.audiences = append(a.audiences, audiences...)
+	}
+}
+
+// JWTClockSkew sets the tolerance of the expiry and not-before checks, one minute by default.
+func JWTClockSkew(skew time.Duration) JWTOption {
+	return func(a *jwtAuth) {
+		a.skew = skew
+	}
+}
+
+// JWTAlgorithms restricts the accepted signing algorithms.
+func JWTAlgorithms(algorithms ...string) JWTOption {
+	return func(a *jwtAuth) {
+		a.algorithms = algorithms
+	}
+}
+
+// JWTExemptPaths lets the requests matching the path patterns through without a token, with the
+// pattern syntax of WithRouteTimeout.
+func JWTExemptPaths(patterns ...string) JWTOption {
+	return func(a *jwtAuth) {
+		a.exempt = append(a.exempt, patterns...)
+	}
+}
+
+// JWTClaimMetadata forwards the value of claim to the backend as the metadata key. Headers of the
+// request setting the key are dropped, so that clients cannot forge it.
+func JWTClaimMetadata(claim, key string) JWTOption {
+	return func(a *jwtAuth) {
+		a.claims[claim] = strings.ToLower(key)
+	}
+}
+
+func (a *jwtAuth) authenticate(ctx context.Context, token string) (*Identity, error) {
+	if a.keys == nil {
+		return nil, errors.New("no JWKS configured")
+	}
+
+	claims := jwt.MapClaims{}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(a.algorithms),
+		jwt.WithLeeway(a.skew),
+		jwt.WithExpirationRequired(),
+	)
+
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.key(ctx, kid)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(a.issuers) > 0 {
+		issuer, _ := claims.GetIssuer()
+
+		if !contains(a.issuers, issuer) {
+			return nil, fmt.Errorf("unexpected issuer %q", issuer)
+		}
+	}
+
+	if len(a.audiences) > 0 {
+		audiences, _ := claims.GetAudience()
+		found := false
+
+		for _, audience := range audiences {
+			found = found || contains(a.audiences, audience)
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unexpected audience %q", audiences)
+		}
+	}
+
+	subject, _ := claims.GetSubject()
+	id := &Identity{
+		Subject:  subject,
+		Scheme:   "jwt",
+		Scopes:   append(claimStrings(claims["scope"]), claimStrings(claims["scp"])...),
+		Roles:    claimStrings(claims["roles"]),
+		Claims:   claims,
+		metadata: metadata.MD{},
+	}
+
+	for claim, key := range a.claims {
+		switch v := claims[claim].(type) {
+		case nil:
+		case []interface{}:
+			for _, item := range v {
+				id.metadata.Append(key, fmt.Sprint(item))
+			}
+		case float64:
+			id.metadata.Append(key, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			id.metadata.Append(key, fmt.Sprint(v))
+		}
+	}
+
+	return id, nil
+}
+
+// claimStrings reads a claim given either as a space separated string or as an array of strings.
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+
+		return values
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// jwksRetry is the least time between two loads of a JWKS, so that failing loads or tokens naming
+// unknown keys do not load it on every request.
+const jwksRetry = 10 * time.Second
+
+// jwksTimeout bounds a load of a JWKS, which does not end with the request that started it.
+const jwksTimeout = 10 * time.Second
+
+// jwksSource caches the keys of a JWKS and loads them again once they are older than refresh,
+// or when a token names a key it does not know, at most every jwksRetry. The keys are loaded
+// outside of the lock, and the current keys are served until a load succeeds.
+type jwksSource struct {
+	load    func(context.Context) ([]byte, error)
+	refresh time.Duration
+
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	loaded  time.Time
+	tried   time.Time
+	loading chan struct{} // closed once the load in progress is done
+	lastErr error
+}
+
+func newJWKSSource(load func(context.Context) ([]byte, error), refresh time.Duration) *jwksSource {
+	if refresh <= 0 {
+		refresh = 5 * time.Minute
+	}
+
+	return &jwksSource{load: load, refresh: refresh}
+}
+
+// key returns the key kid, or the only key of the set when the token names none.
+func (s *jwksSource) key(ctx context.Context, kid string) (interface{}, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	key, ok := s.lookup(kid)
+	stale := s.keys == nil || now.Sub(s.loaded) > s.refresh
+	s.mu.Unlock()
+
+	if ok && !stale {
+		return key, nil
+	}
+
+	// A stale key is served while another request loads the set; an unknown one waits for it
+	s.reload(ctx, now, !ok)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+
+	if s.keys == nil && s.lastErr != nil {
+		return nil, s.lastErr
+	}
+
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (s *jwksSource) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+
+	key, ok := s.keys[kid]
+
+	return key, ok
+}
+
+// reload replaces the keys, keeping the current ones when the JWKS cannot be loaded. It does
+// nothing when the keys were tried within jwksRetry, and waits for a load in progress when wait
+// is set.
+func (s *jwksSource) reload(ctx context.Context, now time.Time, wait bool) {
+	s.mu.Lock()
+
+	if loading := s.loading; loading != nil {
+		s.mu.Unlock()
+
+		if wait {
+			select {
+			case <-loading:
+			case <-ctx.Done():
+			}
+		}
+
+		return
+	}
+
+	if !s.tried.IsZero() && now.Sub(s.tried) < jwksRetry {
+		s.mu.Unlock()
+		return
+	}
+
+	loading := make(chan struct{})
+	s.tried = now
+	s.loading = loading
+	s.mu.Unlock()
+
+	// A client canceling its request must not fail the load, which blocks the retries for jwksRetry
+	loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksTimeout)
+	data, err := s.load(loadCtx)
+	cancel()
+
+	var keys map[string]interface{}
+
+	if err == nil {
+		keys, err = parseJWKS(data)
+	}
+
+	s.mu.Lock()
+
+	if err == nil {
+		s.keys = keys
+		s.loaded = now
+	}
+
+	s.lastErr = err
+	s.loading = nil
+	s.mu.Unlock()
+
+	close(loading)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// parseJWKS converts the signature keys of a JSON Web Key Set by key id.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %s", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %s", jwk.Kid, err)
+		}
+
+		keys[jwk.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+
+		if err != nil {
+			return nil, err
+		}
+
+		e, err := decodeBigInt(k.E)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{
+			"P-256": elliptic.P256(),
+			"P-384": elliptic.P384(),
+			"P-521": elliptic.P521(),
+		}
+
+		curve, ok := curves[k.Crv]
+
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+
+		if err != nil {
+			return nil, err
+		}
+
+		y, err := decodeBigInt(k.Y)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("invalid symmetric key")
+		}
+
+		return secret, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	buf, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil || len(buf) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+
+	return new(big.Int).SetBytes(buf), nil
+}
@@ -0,0 +1,317 @@
+package runtime
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testJWTKeys struct {
+	rsa   *rsa.PrivateKey
+	ec    *ecdsa.PrivateKey
+	ed    ed25519.PrivateKey
+	hmac  []byte
+	jwks  string
+	other *rsa.PrivateKey
+}
+
+func newTestJWTKeys(t *testing.T) *testJWTKeys {
+	k := &testJWTKeys{hmac: []byte("0123456789abcdef0123456789abcdef")}
+
+	var err error
+
+	k.rsa, err = rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	k.other, err = rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	k.ec, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	_, k.ed, err = ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+
+	b64 := func(buf []byte) string {
+		return base64.RawURLEncoding.EncodeToString(buf)
+	}
+
+	set := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": b64(k.rsa.N.Bytes()), "e": b64(big.NewInt(int64(k.rsa.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(k.ec.X.Bytes()), "y": b64(k.ec.Y.Bytes())},
+			{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(k.ed.Public().(ed25519.PublicKey))},
+			{"kty": "oct", "kid": "hmac", "k": b64(k.hmac)},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": b64(k.other.N.Bytes()), "e": "AQAB"},
+		},
+	}
+
+	buf, err := json.Marshal(set)
+	assert.NoError(t, err)
+
+	k.jwks = filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(k.jwks, buf, 0o600))
+
+	return k
+}
+
+func (k *testJWTKeys) sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+
+	return signed
+}
+
+func TestParseJWKS(t *testing.T) {
+	k := newTestJWTKeys(t)
+	buf, err := os.ReadFile(k.jwks)
+	assert.NoError(t, err)
+
+	keys, err := parseJWKS(buf)
+
+	assert.NoError(t, err)
+	assert.Len(t, keys, 4)
+	assert.True(t, k.rsa.PublicKey.Equal(keys["rsa"]))
+	assert.True(t, k.ec.PublicKey.Equal(keys["ec"]))
+	assert.True(t, k.ed.Public().(ed25519.PublicKey).Equal(keys["ed"]))
+	assert.Equal(t, k.hmac, keys["hmac"])
+
+	_, err = parseJWKS([]byte(`{"keys":[{"kty":"EC","kid":"x","crv":"P-192"}]}`))
+	assert.Error(t, err)
+}
+
+func TestJWTAuthHandler(t *testing.T) {
+	k := newTestJWTKeys(t)
+	now := time.Now()
+
+	claims := func(edit func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"iss":   "https://auth.example.com/",
+			"aud":   []string{"api"},
+			"sub":   "user-1",
+			"exp":   now.Add(time.Hour).Unix(),
+			"roles": []string{"admin", "ops"},
+			"level": 1.5,
+			"tier":  3,
+		}
+
+		if edit != nil {
+			edit(c)
+		}
+
+		return c
+	}
+
+	handler := JWTAuthHandler(
+		JWKSFile(k.jwks),
+		JWTIssuer("https://auth.example.com/"),
+		JWTAudience("api", "admin"),
+		JWTClockSkew(30*time.Second),
+		JWTExemptPaths("/ping/**"),
+		JWTClaimMetadata("sub", "X-User-Id"),
+		JWTClaimMetadata("roles", "x-roles"),
+		JWTClaimMetadata("level", "x-level"),
+		JWTClaimMetadata("tier", "x-tier"),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := IdentityFromContext(r.Context())
+
+		if ok {
+			md := identityMetadata(r.Context(), r)
+			w.Header().Set("X-Subject", id.Subject)
+			w.Header()["X-Roles"] = md.Get("x-roles")
+			w.Header()["X-User-Id"] = md.Get("x-user-id")
+			w.Header()["X-Level"] = md.Get("x-level")
+			w.Header()["X-Tier"] = md.Get("x-tier")
+		}
+
+		w.Header()["X-Forwarded-Roles"] = r.Header.Values("Grpc-Metadata-X-Roles")
+		w.WriteHeader(http.StatusOK)
+	}), newGatewayOption())
+
+	tests := []struct {
+		name   string
+		path   string
+		token  string
+		status int
+	}{
+		{"rsa", "/v1/users", k.sign(t, jwt.SigningMethodRS256, "rsa", k.rsa, claims(nil)), http.StatusOK},
+		{"ecdsa", "/v1/users", k.sign(t, jwt.SigningMethodES256, "ec", k.ec, claims(nil)), http.StatusOK},
+		{"eddsa", "/v1/users", k.sign(t, jwt.SigningMethodEdDSA, "ed", k.ed, claims(nil)), http.StatusOK},
+		{"hmac", "/v1/users", k.sign(t, jwt.SigningMethodHS256, "hmac", k.hmac, claims(nil)), http.StatusOK},
+		{"within skew", "/v1/users", k.sign(t, jwt.SigningMethodRS256, "rsa", k.rsa, claims(func(c jwt.MapClaims) {
+			c["exp"] = now.Add(-10 * time.Second).Unix()
+		})), http.StatusOK},
+		{"expired", "/v1/users", k.sign(t, jwt.SigningMethodRS256, "rsa", k.rsa, claims(func(c jwt.MapClaims) {
+			c["exp"] = now.Add(-time.Minute).Unix()
+		})), http.StatusUnauthorized},
+		{"no expiry", "/v1/users", k.sign(t, jwt.SigningMethodRS256, "rsa", k.rsa, claims(func(c jwt.MapClaims) {
+			delete(c, "exp")
+		})), http.StatusUnauthorized},
+		{"wrong audience", "/v1/users", k.sign(t, jwt.SigningMethodRS256, "rsa", k.rsa, claims(func(c jwt.MapClaims) {
+			c["aud"] = "web"
+		})), http.StatusUnauthorized},
+		{"wrong issuer", "/v1/users", k.sign(t, jwt.SigningMethodRS256, "rsa", k.rsa, claims(func(c jwt.MapClaims) {
+			c["iss"] = "https://evil.example.com/"
+		})), http.StatusUnauthorized},
+		{"unknown signer", "/v1/users", k.sign(t, jwt.SigningMethodRS256, "rsa", k.other, claims(nil)), http.StatusUnauthorized},
+		{"encryption key", "/v1/users", k.sign(t, jwt.SigningMethodRS256, "enc", k.other, claims(nil)), http.StatusUnauthorized},
+		{"missing", "/v1/users", "", http.StatusUnauthorized},
+		{"exempt", "/ping/heartbeat", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.Header.Set("Grpc-Metadata-X-Roles", "forged")
+
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+
+			if tt.status == http.StatusUnauthorized {
+				assert.Contains(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+				return
+			}
+
+			assert.Empty(t, w.Header().Values("X-Forwarded-Roles"))
+
+			if tt.token != "" {
+				assert.Equal(t, "user-1", w.Header().Get("X-Subject"))
+				assert.Equal(t, []string{"user-1"}, w.Header().Values("X-User-Id"))
+				assert.Equal(t, []string{"admin", "ops"}, w.Header().Values("X-Roles"))
+				assert.Equal(t, []string{"1.5"}, w.Header().Values("X-Level"))
+				assert.Equal(t, []string{"3"}, w.Header().Values("X-Tier"))
+			}
+		})
+	}
+}
+
+func TestJWKSSource(t *testing.T) {
+	loads := 0
+	keys := `{"keys":[{"kty":"oct","kid":"a","k":"c2VjcmV0"}]}`
+
+	source := newJWKSSource(func(context.Context) ([]byte, error) {
+		loads++
+		return []byte(keys), nil
+	}, time.Hour)
+
+	key, err := source.key(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key)
+
+	// Rotated keys are loaded for an unknown key id, at most every 10 seconds
+	keys = `{"keys":[{"kty":"oct","kid":"b","k":"cm90YXRlZA"}]}`
+	source.tried = time.Now().Add(-time.Minute)
+
+	key, err = source.key(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("rotated"), key)
+
+	_, err = source.key(context.Background(), "c")
+	assert.Error(t, err)
+	assert.Equal(t, 2, loads)
+
+	// A request canceled by its client does not cancel the load it started
+	source = newJWKSSource(func(ctx context.Context) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		return []byte(keys), nil
+	}, time.Hour)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _ = source.key(canceled, "b")
+
+	key, err = source.key(context.Background(), "b")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("rotated"), key)
+}
+
+func TestJWKSSource_Stale(t *testing.T) {
+	var loads atomic.Int32
+	var fail atomic.Bool
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	block := false
+
+	source := newJWKSSource(func(context.Context) ([]byte, error) {
+		loads.Add(1)
+
+		if block {
+			started <- struct{}{}
+			<-release
+		}
+
+		if fail.Load() {
+			return nil, errors.New("unavailable")
+		}
+
+		return []byte(`{"keys":[{"kty":"oct","kid":"a","k":"c2VjcmV0"}]}`), nil
+	}, time.Minute)
+
+	_, err := source.key(context.Background(), "a")
+	assert.NoError(t, err)
+
+	// A failing load keeps serving the stale keys and is not retried on every request
+	fail.Store(true)
+	source.loaded = time.Now().Add(-time.Hour)
+	source.tried = source.loaded
+
+	for i := 0; i < 3; i++ {
+		key, err := source.key(context.Background(), "a")
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("secret"), key)
+	}
+
+	assert.Equal(t, int32(2), loads.Load())
+
+	// The stale keys are served while another request loads the set
+	block = true
+	source.tried = source.loaded
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_, _ = source.key(context.Background(), "a")
+	}()
+
+	<-started
+
+	key, err := source.key(context.Background(), "a")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key)
+
+	close(release)
+	<-done
+	assert.Equal(t, int32(3), loads.Load())
+}
+
+func TestClaimStrings(t *testing.T) {
+	assert.Equal(t, []string{"users.read", "users.write"}, claimStrings("users.read  users.write"))
+	assert.Equal(t, []string{"admin", "ops"}, claimStrings([]interface{}{"admin", 1, "ops"}))
+	assert.Nil(t, claimStrings(nil))
+}
@@ -85,3 +85,7 @@ func (w *logResponseWriter) Write(b []byte) (int, error) {
 	w.size += size
 	return size, err
 }
+
+func (w *logResponseWriter) Flush() {
+	flushResponse(w.ResponseWriter)
+}
@@ -21,27 +21,38 @@ func WithErrorOutput(path string) GatewayOptionFunc {
 }
 
 func (o *GatewayOption) initLog() error {
-	aws := make([]io.Writer, 0)
-	ews := make([]io.Writer, 0)
-
 	o.log = logrus.New()
 	o.err = logrus.New()
 
 	o.err.SetFormatter(&errorLogFormatter{})
 
+	return o.openLog()
+}
+
+// openLog opens the access and error log files and points the loggers at them.
+// The files opened by a previous call are closed once the loggers have been switched,
+// so it can be called again to reopen log files that were rotated away.
+func (o *GatewayOption) openLog() error {
+	aws := make([]io.Writer, 0)
+	ews := make([]io.Writer, 0)
+	files := make([]*os.File, 0)
+
 	if o.logs.access != nil {
 		if file, err := os.OpenFile(*o.logs.access, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666); err != nil {
 			return fmt.Errorf("failed to open access log file %s for output: %s", *o.logs.access, err)
 		} else {
 			aws = append(aws, file)
+			files = append(files, file)
 		}
 	}
 
 	if o.logs.error != nil {
 		if file, err := os.OpenFile(*o.logs.error, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666); err != nil {
+			closeLogFiles(files)
 			return fmt.Errorf("failed to open error log file %s for output: %s", *o.logs.error, err)
 		} else {
 			ews = append(ews, file)
+			files = append(files, file)
 		}
 	}
 
@@ -53,9 +64,21 @@ func (o *GatewayOption) initLog() error {
 	o.log.SetOutput(io.MultiWriter(aws...))
 	o.err.SetOutput(io.MultiWriter(ews...))
 
+	o.mu.Lock()
+	files, o.files = o.files, files
+	o.mu.Unlock()
+
+	closeLogFiles(files)
+
 	return nil
 }
 
+func closeLogFiles(files []*os.File) {
+	for _, file := range files {
+		_ = file.Close()
+	}
+}
+
 type errorLogFormatter struct{}
 
 func (f *errorLogFormatter) Format(e *logrus.Entry) ([]byte, error) {
@@ -4,7 +4,6 @@ import (
 	"context"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc/metadata"
-	"log"
 	"net/http"
 	"regexp"
 	"strings"
@@ -29,8 +28,10 @@ const (
 type mdValue struct {
 	key     string
 	pattern *regexp.Regexp
-	value   *string
-	action  MetaAction
+	// header matches the key case-insensitively, for the response headers of the RPC proxy
+	header *regexp.Regexp
+	value  *string
+	action MetaAction
 }
 
 type mdValues []mdValue
@@ -61,6 +62,36 @@ func (d mdValues) match(values map[string][]string, prefix string) metadata.MD {
 	return md
 }
 
+// allows reports whether the header is let through: the first rule matching it decides, and the
+// headers no rule matches are kept.
+func (d mdValues) allows(header string) bool {
+	for _, i := range d {
+		if i.header != nil && i.header.MatchString(header) {
+			return i.action != MetaDeleteAction
+		}
+	}
+
+	return true
+}
+
+// response returns md without the keys whose header, named prefix+key as the REST routes write
+// it, the rules delete. It applies the ResponseMeta rules to the calls of the RPC proxy.
+func (d mdValues) response(md metadata.MD, prefix string) metadata.MD {
+	if len(d) == 0 {
+		return md
+	}
+
+	out := make(metadata.MD, len(md))
+
+	for k, v := range md {
+		if d.allows(prefix + k) {
+			out[k] = v
+		}
+	}
+
+	return out
+}
+
 type metadataInfo struct {
 	req mdValues
 	res mdValues
@@ -90,9 +121,7 @@ func WithMetadata(options ...WithMetaDataFunc) GatewayOptionFunc {
 		}
 
 		opt.metas = info
-		opt.muxOpts = append(opt.muxOpts, metadataMuxFunc(opt))
-
-		log.Printf("%+o\n", info)
+		opt.muxOpts = append(opt.muxOpts, metadataMuxFunc(info))
 	}
 }
 
@@ -102,6 +131,7 @@ func PassThrowMeta(keys []string, mode MetaType) WithMetaDataFunc {
 			info.add(mdValue{
 				key:     key,
 				pattern: regexCompile(key),
+				header:  headerCompile(key),
 				action:  MetaPathThrowAction,
 			}, mode)
 		}
@@ -116,6 +146,7 @@ func DeleteMeta(keys []string, mode MetaType) WithMetaDataFunc {
 			info.add(mdValue{
 				key:     key,
 				pattern: regexCompile(key),
+				header:  headerCompile(key),
 				action:  MetaDeleteAction,
 			}, mode)
 		}
@@ -124,10 +155,10 @@ func DeleteMeta(keys []string, mode MetaType) WithMetaDataFunc {
 	}
 }
 
-func metadataMuxFunc(opt *GatewayOption) runtime.ServeMuxOption {
+func metadataMuxFunc(info metadataInfo) runtime.ServeMuxOption {
 	return runtime.WithMetadata(func(ctx context.Context, req *http.Request) metadata.MD {
-		if len(opt.metas.req) > 0 {
-			return opt.metas.req.match(req.Header, "")
+		if len(info.req) > 0 {
+			return info.req.match(req.Header, "")
 		}
 
 		return make(metadata.MD)
@@ -143,3 +174,8 @@ func regexCompile(key string) *regexp.Regexp {
 
 	return regexp.MustCompile(p)
 }
+
+// headerCompile is regexCompile matching case-insensitively, as HTTP header names compare.
+func headerCompile(key string) *regexp.Regexp {
+	return regexp.MustCompile("(?i)" + regexCompile(key).String())
+}
@@ -0,0 +1,596 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenAPIUIKind selects the documentation UI served by OpenAPIUI.
+type OpenAPIUIKind string
+
+const (
+	SwaggerUI OpenAPIUIKind = "swagger"
+	Redoc     OpenAPIUIKind = "redoc"
+)
+
+type OpenAPIOption func(*openAPIDocs)
+
+type openAPISource struct {
+	name string
+	load func(ctx context.Context) ([]byte, error)
+}
+
+type openAPIDocs struct {
+	sources  []openAPISource
+	basePath string
+	title    string
+	version  string
+	refresh  time.Duration
+	uiPath   string
+	uiKind   OpenAPIUIKind
+	uiAssets string
+	uiHashes map[string]string
+
+	mu      sync.Mutex
+	merged  map[string]interface{}
+	loaded  time.Time
+	loading chan struct{} // closed once the load in progress is done
+	lastErr error
+}
+
+// WithOpenAPI is a GatewayOptionFunc that serves the OpenAPI document of the gateway at path, as
+// generated by protoc-gen-openapiv2 for the registered endpoints. Several documents, one per
+// service or backend, are merged into one; they must all be Swagger 2.0 or all be OpenAPI 3.
+// The host, schemes and servers of the document are rewritten to the ServerInfo of the gateway,
+// or to the host of the request when the server listens on every address.
+//
+// The documents are loaded on the first request and again once OpenAPIRefresh has passed; a
+// document that fails to load keeps the previous merge in effect.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithOpenAPI("/openapi.json",
+//	        OpenAPIFile("openapi/*.swagger.json"),
+//	        OpenAPIURL("http://billing.internal:8081/openapi.json"),
+//	        OpenAPIInfo("Example API", "1.4.0"),
+//	        OpenAPIUI("/docs", SwaggerUI),
+//	    ),
+//	)
+func WithOpenAPI(path string, opts ...OpenAPIOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		docs := &openAPIDocs{refresh: time.Minute, uiKind: SwaggerUI}
+
+		for _, o := range opts {
+			o(docs)
+		}
+
+		opt.paths = append(opt.paths, PathHandler{"GET", path, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			docs.serve(w, r, opt)
+		}})
+
+		if docs.uiPath != "" {
+			opt.paths = append(opt.paths, PathHandler{"GET", docs.uiPath, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+				docs.serveUI(w, r, path)
+			}})
+		}
+	}
+}
+
+// OpenAPIFile adds the documents matching the glob patterns.
+func OpenAPIFile(patterns ...string) OpenAPIOption {
+	return func(d *openAPIDocs) {
+		for _, pattern := range patterns {
+			pattern := pattern
+
+			d.sources = append(d.sources, openAPISource{pattern, func(context.Context) ([]byte, error) {
+				paths, err := filepath.Glob(pattern)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if len(paths) == 0 {
+					return nil, fmt.Errorf("no OpenAPI document matches %s", pattern)
+				}
+
+				// Several files matched by one pattern are merged in name order, as a JSON array
+				docs := make([]json.RawMessage, 0, len(paths))
+
+				for _, path := range paths {
+					data, err := os.ReadFile(path)
+
+					if err != nil {
+						return nil, err
+					}
+
+					docs = append(docs, data)
+				}
+
+				return json.Marshal(docs)
+			}})
+		}
+	}
+}
+
+// OpenAPIDocument adds a document held in memory, e.g. embedded with go:embed.
+func OpenAPIDocument(data []byte) OpenAPIOption {
+	return func(d *openAPIDocs) {
+		d.sources = append(d.sources, openAPISource{"document", func(context.Context) ([]byte, error) {
+			return data, nil
+		}})
+	}
+}
+
+// OpenAPIURL adds the document served at url, e.g. by the gateway of another backend.
+func OpenAPIURL(url string) OpenAPIOption {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return func(d *openAPIDocs) {
+		d.sources = append(d.sources, openAPISource{url, func(ctx context.Context) ([]byte, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+			if err != nil {
+				return nil, err
+			}
+
+			res, err := client.Do(req)
+
+			if err != nil {
+				return nil, err
+			}
+
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("unexpected status %s", res.Status)
+			}
+
+			return io.ReadAll(io.LimitReader(res.Body, 16<<20))
+		}})
+	}
+}
+
+// OpenAPIBasePath sets the basePath of a Swagger 2.0 document, or the path of the server URL of an
+// OpenAPI 3 document, for a gateway served under a path prefix.
+func OpenAPIBasePath(path string) OpenAPIOption {
+	return func(d *openAPIDocs) {
+		d.basePath = path
+	}
+}
+
+// OpenAPIInfo sets the title and version of the merged document, those of the first document
+// otherwise. Empty values are left unchanged.
+func OpenAPIInfo(title, version string) OpenAPIOption {
+	return func(d *openAPIDocs) {
+		d.title = title
+		d.version = version
+	}
+}
+
+// OpenAPIRefresh sets how long the merged document is served before the documents are loaded again.
+func OpenAPIRefresh(refresh time.Duration) OpenAPIOption {
+	return func(d *openAPIDocs) {
+		d.refresh = refresh
+	}
+}
+
+// OpenAPIUI serves a Swagger UI or Redoc page rendering the document at path. Its scripts are
+// loaded from a pinned release on the public CDN, unless OpenAPIUIAssets is set; set
+// OpenAPIUIIntegrity too so that the browser checks their content.
+func OpenAPIUI(path string, kind OpenAPIUIKind) OpenAPIOption {
+	return func(d *openAPIDocs) {
+		d.uiPath = path
+		d.uiKind = kind
+	}
+}
+
+// OpenAPIUIAssets loads the scripts and styles of the UI from baseURL instead of the public CDN,
+// for networks without internet access. baseURL must hold the files of the swagger-ui-dist or
+// redoc package.
+func OpenAPIUIAssets(baseURL string) OpenAPIOption {
+	return func(d *openAPIDocs) {
+		d.uiAssets = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// OpenAPIUIIntegrity sets the Subresource Integrity hash of a file of the UI, e.g.
+// "swagger-ui-bundle.js", so that the browser refuses the file once its content changes. hash is
+// the value of the integrity attribute, e.g. "sha384-...".
+func OpenAPIUIIntegrity(file, hash string) OpenAPIOption {
+	return func(d *openAPIDocs) {
+		if d.uiHashes == nil {
+			d.uiHashes = make(map[string]string)
+		}
+
+		d.uiHashes[file] = hash
+	}
+}
+
+func (d *openAPIDocs) serve(w http.ResponseWriter, r *http.Request, o *GatewayOption) {
+	doc, err := d.document(r.Context())
+
+	if err != nil && o.err != nil {
+		o.err.Errorf("Failed to load OpenAPI document: %v", err)
+	}
+
+	if doc == nil {
+		httpError(w, r, status.Error(codes.Unavailable, "OpenAPI document unavailable"))
+		return
+	}
+
+	doc = rewriteOpenAPI(doc, d.serverHost(r, o.server), scheme(r), d.basePath)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		return
+	}
+}
+
+// document returns the merged document, loading the sources again once it is older than refresh.
+// When they fail to load the previous merge is returned along with the error, if there is one.
+// The sources are loaded outside of the lock, and the previous merge is served meanwhile.
+func (d *openAPIDocs) document(ctx context.Context) (map[string]interface{}, error) {
+	d.mu.Lock()
+
+	if d.merged != nil && time.Since(d.loaded) < d.refresh {
+		defer d.mu.Unlock()
+		return d.merged, nil
+	}
+
+	if loading := d.loading; loading != nil {
+		merged := d.merged
+		d.mu.Unlock()
+
+		if merged != nil {
+			return merged, nil
+		}
+
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		return d.merged, d.lastErr
+	}
+
+	loading := make(chan struct{})
+	d.loading = loading
+	d.mu.Unlock()
+
+	merged, err := d.load(ctx)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.loading = nil
+	d.lastErr = err
+	close(loading)
+
+	if err != nil {
+		if d.merged != nil {
+			// Try again after the next interval rather than on every request
+			d.loaded = time.Now()
+		}
+
+		return d.merged, err
+	}
+
+	d.merged = merged
+	d.loaded = time.Now()
+
+	return merged, nil
+}
+
+func (d *openAPIDocs) load(ctx context.Context) (map[string]interface{}, error) {
+	docs := make([]map[string]interface{}, 0, len(d.sources))
+
+	for _, source := range d.sources {
+		data, err := source.load(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("OpenAPI source %s: %s", source.name, err)
+		}
+
+		parsed, err := parseOpenAPI(data)
+
+		if err != nil {
+			return nil, fmt.Errorf("OpenAPI source %s: %s", source.name, err)
+		}
+
+		docs = append(docs, parsed...)
+	}
+
+	merged, err := mergeOpenAPI(docs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, _ := merged["info"].(map[string]interface{})
+
+	if info == nil {
+		info = make(map[string]interface{})
+		merged["info"] = info
+	}
+
+	if d.title != "" {
+		info["title"] = d.title
+	}
+
+	if d.version != "" {
+		info["version"] = d.version
+	}
+
+	return merged, nil
+}
+
+// parseOpenAPI reads a document, or a JSON array of documents.
+func parseOpenAPI(data []byte) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+
+	if err := json.Unmarshal(data, &docs); err == nil {
+		return docs, nil
+	}
+
+	var doc map[string]interface{}
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI document: %s", err)
+	}
+
+	return []map[string]interface{}{doc}, nil
+}
+
+// openAPIVersion returns "2" for Swagger 2.0 documents and "3" for OpenAPI 3 documents.
+func openAPIVersion(doc map[string]interface{}) string {
+	if v, ok := doc["openapi"].(string); ok && strings.HasPrefix(v, "3.") {
+		return "3"
+	}
+
+	if v, ok := doc["swagger"].(string); ok && v == "2.0" {
+		return "2"
+	}
+
+	return ""
+}
+
+// mergeOpenAPI merges the paths, definitions, components and tags of docs into the first one.
+// An operation or definition already present is kept, so that the first document wins.
+func mergeOpenAPI(docs []map[string]interface{}) (map[string]interface{}, error) {
+	if len(docs) == 0 {
+		return nil, fmt.Errorf("no OpenAPI document configured")
+	}
+
+	merged := docs[0]
+	version := openAPIVersion(merged)
+
+	if version == "" {
+		return nil, fmt.Errorf("unsupported OpenAPI document version")
+	}
+
+	// The entries of the sections are merged one by one, those of components by kind
+	sections := []string{"definitions", "parameters", "responses", "securityDefinitions"}
+	depth := 1
+
+	if version == "3" {
+		sections = []string{"components"}
+		depth = 2
+	}
+
+	for _, doc := range docs[1:] {
+		if openAPIVersion(doc) != version {
+			return nil, fmt.Errorf("cannot merge OpenAPI documents of different versions")
+		}
+
+		// The paths are merged by operation
+		mergeMaps(merged, doc, "paths", 2)
+
+		for _, section := range sections {
+			mergeMaps(merged, doc, section, depth)
+		}
+
+		merged["tags"] = mergeTags(merged["tags"], doc["tags"])
+	}
+
+	return merged, nil
+}
+
+// mergeMaps copies the entries of src[key] missing in dst[key], descending depth levels.
+func mergeMaps(dst, src map[string]interface{}, key string, depth int) {
+	from, ok := src[key].(map[string]interface{})
+
+	if !ok {
+		return
+	}
+
+	to, ok := dst[key].(map[string]interface{})
+
+	if !ok {
+		dst[key] = from
+		return
+	}
+
+	for k, v := range from {
+		existing, ok := to[k]
+
+		if !ok {
+			to[k] = v
+			continue
+		}
+
+		if depth > 1 {
+			if _, ok := existing.(map[string]interface{}); ok {
+				mergeMaps(to, from, k, depth-1)
+			}
+		}
+	}
+}
+
+// mergeTags appends the tags of src not named in dst.
+func mergeTags(dst, src interface{}) interface{} {
+	to, _ := dst.([]interface{})
+	from, _ := src.([]interface{})
+	names := make(map[interface{}]bool, len(to))
+
+	for _, tag := range to {
+		if t, ok := tag.(map[string]interface{}); ok {
+			names[t["name"]] = true
+		}
+	}
+
+	for _, tag := range from {
+		if t, ok := tag.(map[string]interface{}); ok && !names[t["name"]] {
+			to = append(to, tag)
+			names[t["name"]] = true
+		}
+	}
+
+	if to == nil {
+		return nil
+	}
+
+	return to
+}
+
+// rewriteOpenAPI returns a copy of doc served from host with scheme and basePath.
+func rewriteOpenAPI(doc map[string]interface{}, host, scheme, basePath string) map[string]interface{} {
+	out := make(map[string]interface{}, len(doc)+3)
+
+	for k, v := range doc {
+		out[k] = v
+	}
+
+	if openAPIVersion(doc) == "3" {
+		out["servers"] = []interface{}{
+			map[string]interface{}{"url": scheme + "://" + host + strings.TrimSuffix(basePath, "/")},
+		}
+
+		return out
+	}
+
+	out["host"] = host
+	out["schemes"] = []interface{}{scheme}
+
+	if basePath != "" {
+		out["basePath"] = basePath
+	}
+
+	return out
+}
+
+// serverHost returns the address of the gateway, or the host of the request when the gateway
+// listens on every address and its own would not be reachable.
+func (d *openAPIDocs) serverHost(r *http.Request, server ServerInfo) string {
+	if ip := net.ParseIP(server.host); server.host == "" || (ip != nil && ip.IsUnspecified()) {
+		return r.Host
+	}
+
+	return server.ToString()
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
+
+var openAPIUITemplates = map[OpenAPIUIKind]*template.Template{
+	SwaggerUI: template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>API documentation</title>
+  <link rel="stylesheet" href="{{.Assets}}/swagger-ui.css"{{with index .Integrity "swagger-ui.css"}} integrity="{{.}}" crossorigin="anonymous"{{end}}>
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.Assets}}/swagger-ui-bundle.js"{{with index .Integrity "swagger-ui-bundle.js"}} integrity="{{.}}" crossorigin="anonymous"{{end}}></script>
+  <script>
+    window.onload = function () {
+      window.ui = SwaggerUIBundle({url: {{.Document}}, dom_id: "#swagger-ui", deepLinking: true});
+    };
+  </script>
+</body>
+</html>
+`)),
+	Redoc: template.Must(template.New("redoc").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>API documentation</title>
+</head>
+<body>
+  <redoc spec-url="{{.Document}}"></redoc>
+  <script src="{{.Assets}}/redoc.standalone.js"{{with index .Integrity "redoc.standalone.js"}} integrity="{{.}}" crossorigin="anonymous"{{end}}></script>
+</body>
+</html>
+`)),
+}
+
+// openAPIUIAssets pins the exact release of the UI loaded from the public CDN, so that the page
+// does not change with the releases of the packages.
+var openAPIUIAssets = map[OpenAPIUIKind]string{
+	SwaggerUI: "https://unpkg.com/swagger-ui-dist@5.17.14",
+	Redoc:     "https://unpkg.com/redoc@2.1.5/bundles",
+}
+
+func (d *openAPIDocs) serveUI(w http.ResponseWriter, r *http.Request, document string) {
+	tmpl, ok := openAPIUITemplates[d.uiKind]
+
+	if !ok {
+		httpError(w, r, status.Errorf(codes.Internal, "unknown OpenAPI UI %q", d.uiKind))
+		return
+	}
+
+	assets := d.uiAssets
+
+	if assets == "" {
+		assets = openAPIUIAssets[d.uiKind]
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	data := struct {
+		Assets, Document string
+		Integrity        map[string]string
+	}{assets, document, d.uiHashes}
+
+	if err := tmpl.Execute(w, data); err != nil {
+		return
+	}
+}
+
+// openAPIUIKinds lists the supported UI kinds, for the error messages of the configuration.
+func openAPIUIKinds() string {
+	kinds := make([]string, 0, len(openAPIUITemplates))
+
+	for kind := range openAPIUITemplates {
+		kinds = append(kinds, string(kind))
+	}
+
+	sort.Strings(kinds)
+
+	return strings.Join(kinds, ", ")
+}
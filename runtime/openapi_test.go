@@ -0,0 +1,180 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testUsersSwagger = `{
+		"swagger": "2.0",
+		"info": {"title": "users.proto", "version": "1"},
+		"tags": [{"name": "UserService"}],
+		"paths": {"/v1/users": {"get": {"operationId": "ListUsers"}}},
+		"definitions": {"v1User": {"type": "object"}, "rpcStatus": {"type": "object"}}
+	}`
+	testBillingSwagger = `{
+		"swagger": "2.0",
+		"info": {"title": "billing.proto", "version": "1"},
+		"tags": [{"name": "BillingService"}, {"name": "UserService"}],
+		"paths": {
+			"/v1/users": {"post": {"operationId": "CreateUser"}},
+			"/v1/invoices": {"get": {"operationId": "ListInvoices"}}
+		},
+		"definitions": {"v1Invoice": {"type": "object"}, "rpcStatus": {"type": "string"}}
+	}`
+)
+
+func TestMergeOpenAPI(t *testing.T) {
+	docs, err := parseOpenAPI([]byte("[" + testUsersSwagger + "," + testBillingSwagger + "]"))
+	assert.NoError(t, err)
+
+	merged, err := mergeOpenAPI(docs)
+	assert.NoError(t, err)
+
+	paths := merged["paths"].(map[string]interface{})
+	assert.Len(t, paths, 2)
+	assert.Len(t, paths["/v1/users"], 2)
+
+	definitions := merged["definitions"].(map[string]interface{})
+	assert.Len(t, definitions, 3)
+	assert.Equal(t, "object", definitions["rpcStatus"].(map[string]interface{})["type"])
+	assert.Len(t, merged["tags"], 2)
+
+	v3, err := parseOpenAPI([]byte(`{"openapi": "3.0.3", "paths": {}}`))
+	assert.NoError(t, err)
+
+	_, err = mergeOpenAPI(append(docs, v3...))
+	assert.Error(t, err)
+
+	// The components of OpenAPI 3 are merged by kind
+	docs, err = parseOpenAPI([]byte(`[
+		{"openapi": "3.0.3", "components": {"schemas": {"A": {}}}},
+		{"openapi": "3.1.0", "components": {"schemas": {"B": {}}, "securitySchemes": {"bearer": {}}}}
+	]`))
+	assert.NoError(t, err)
+
+	merged, err = mergeOpenAPI(docs)
+	assert.NoError(t, err)
+
+	components := merged["components"].(map[string]interface{})
+	assert.Len(t, components["schemas"], 2)
+	assert.Len(t, components["securitySchemes"], 1)
+}
+
+func TestRewriteOpenAPI(t *testing.T) {
+	v2 := map[string]interface{}{"swagger": "2.0", "host": "localhost"}
+	out := rewriteOpenAPI(v2, "api.example.com", "https", "/api")
+
+	assert.Equal(t, "api.example.com", out["host"])
+	assert.Equal(t, []interface{}{"https"}, out["schemes"])
+	assert.Equal(t, "/api", out["basePath"])
+	assert.Equal(t, "localhost", v2["host"])
+
+	v3 := map[string]interface{}{"openapi": "3.0.3"}
+	out = rewriteOpenAPI(v3, "127.0.0.1:8081", "http", "/api/")
+
+	assert.Equal(t, []interface{}{map[string]interface{}{"url": "http://127.0.0.1:8081/api"}}, out["servers"])
+}
+
+func TestWithOpenAPI(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "users.swagger.json"), []byte(testUsersSwagger), 0o600))
+
+	billing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testBillingSwagger))
+	}))
+	defer billing.Close()
+
+	o := newGatewayOption()
+	WithServer("0.0.0.0", 8081)(o)
+	WithOpenAPI("/openapi.json",
+		OpenAPIFile(filepath.Join(dir, "*.swagger.json")),
+		OpenAPIURL(billing.URL),
+		OpenAPIInfo("Example API", ""),
+		OpenAPIUI("/docs", Redoc),
+		OpenAPIUIIntegrity("redoc.standalone.js", "sha384-test"),
+	)(o)
+
+	mux := runtime.NewServeMux()
+	assert.NoError(t, o.attachPathHandle(mux))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "http://gateway.example.com/openapi.json", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var doc map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	assert.Equal(t, "gateway.example.com", doc["host"])
+	assert.Equal(t, map[string]interface{}{"title": "Example API", "version": "1"}, doc["info"])
+	assert.Len(t, doc["paths"], 2)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/docs", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `spec-url="/openapi.json"`)
+	assert.Contains(t, w.Body.String(), `src="https://unpkg.com/redoc@2.1.5/bundles/redoc.standalone.js" integrity="sha384-test" crossorigin="anonymous"`)
+
+	// Without a loadable document the request fails
+	o = newGatewayOption()
+	WithOpenAPI("/openapi.json", OpenAPIFile(filepath.Join(dir, "*.yaml")))(o)
+
+	mux = runtime.NewServeMux()
+	assert.NoError(t, o.attachPathHandle(mux))
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestOpenAPIDocs_Document(t *testing.T) {
+	var block atomic.Bool
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	d := &openAPIDocs{refresh: time.Minute}
+	d.sources = []openAPISource{{"billing", func(context.Context) ([]byte, error) {
+		if block.Load() {
+			started <- struct{}{}
+			<-release
+		}
+
+		return []byte(testBillingSwagger), nil
+	}}}
+
+	first, err := d.document(context.Background())
+	assert.NoError(t, err)
+
+	// The previous merge is served while another request loads the sources again
+	d.loaded = time.Now().Add(-time.Hour)
+	block.Store(true)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		_, _ = d.document(context.Background())
+	}()
+
+	<-started
+
+	doc, err := d.document(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, first, doc)
+
+	close(release)
+	<-done
+
+	assert.WithinDuration(t, time.Now(), d.loaded, time.Second)
+}
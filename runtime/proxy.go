@@ -0,0 +1,501 @@
+package runtime
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// maxProxyMessageSize bounds a message read from a gRPC-Web or Connect request, as grpc-go does
+// by default for the messages a server receives.
+const maxProxyMessageSize = 4 << 20
+
+// maxProxyRequestSize bounds the body of a gRPC-Web or Connect request, base64 text included.
+const maxProxyRequestSize = 32 << 20
+
+// RPCProxyOption configures the proxy of WithGRPCWeb and WithConnect.
+type RPCProxyOption func(*rpcProxy)
+
+// rpcProxy passes the calls of gRPC-Web and Connect clients through to the backends as they
+// are, without transcoding them like the REST routes.
+type rpcProxy struct {
+	grpcWeb bool
+	connect bool
+	methods []string
+	routes  []proxyRoute
+	// files resolves the descriptors of the methods, those of the generated code by default
+	files interface {
+		FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+	}
+}
+
+type proxyRoute struct {
+	backend string
+	methods []string
+}
+
+// WithGRPCWeb is a GatewayOptionFunc that serves gRPC-Web clients on the listener of the gateway,
+// next to the REST routes. Calls to "/package.Service/Method" sent as application/grpc-web or
+// application/grpc-web-text are passed through to the default backend, or to the backend set
+// with RPCProxyBackend, over the same dial options as the endpoints. The handler chain applies
+// as for the REST routes, and so do the request headers forwarded as metadata, the ResponseMeta
+// rules of WithMetadata and the ErrorHandleCallback values, whose ErrorResult message is added to
+// the details of the status. A request body is read up to 32 MiB, and its messages are sent to
+// the backend as they are read.
+//
+// Browser clients reading the response headers need them exposed with WithCORS.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithBackend("127.0.0.1", 8080),
+//	    WithGRPCWeb(RPCProxyMethods("/skelton.v1.HelloWorldService/*")),
+//	)
+func WithGRPCWeb(opts ...RPCProxyOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.rpcProxy().grpcWeb = true
+		opt.rpcProxy().apply(opts)
+	}
+}
+
+// WithConnect is a GatewayOptionFunc that serves clients of the Connect protocol on the listener
+// of the gateway as WithGRPCWeb does for gRPC-Web: unary calls sent as application/proto or
+// application/json with a Connect-Protocol-Version header, or as GET requests, and streaming
+// calls sent as application/connect+proto or application/connect+json.
+//
+// The JSON codec needs the descriptors of the called method, which are found in the generated
+// code linked into the gateway. Calls to other methods must use the binary codec. GET requests
+// are answered with 405 unless the descriptor of the method declares
+// idempotency_level = NO_SIDE_EFFECTS, as the Connect protocol requires.
+func WithConnect(opts ...RPCProxyOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.rpcProxy().connect = true
+		opt.rpcProxy().apply(opts)
+	}
+}
+
+// RPCProxyBackend passes the calls to the methods to the backend name instead of the default
+// backend. Methods are patterns such as "/package.Service/*", see WithMethodTimeout.
+func RPCProxyBackend(backend string, methods ...string) RPCProxyOption {
+	return func(p *rpcProxy) {
+		p.routes = append(p.routes, proxyRoute{backend, methods})
+	}
+}
+
+// RPCProxyMethods lets the proxy pass the calls to the methods through, given as patterns such
+// as "/package.Service/*". The proxy only calls the methods listed here or with RPCProxyBackend,
+// and those of the services of WithService; calls to other methods fail with Unimplemented, so
+// that clients cannot reach backend methods the gateway does not expose.
+func RPCProxyMethods(methods ...string) RPCProxyOption {
+	return func(p *rpcProxy) {
+		p.methods = append(p.methods, methods...)
+	}
+}
+
+func (o *GatewayOption) rpcProxy() *rpcProxy {
+	if o.proxy == nil {
+		o.proxy = &rpcProxy{files: protoregistry.GlobalFiles}
+	}
+
+	return o.proxy
+}
+
+func (p *rpcProxy) apply(opts []RPCProxyOption) {
+	for _, opt := range opts {
+		opt(p)
+	}
+}
+
+// backend returns the name of the backend serving method.
+func (p *rpcProxy) backend(method string) string {
+	for _, route := range p.routes {
+		for _, pattern := range route.methods {
+			if matchMethod(pattern, method) {
+				return route.backend
+			}
+		}
+	}
+
+	return DefaultBackend
+}
+
+// allowed reports whether the calls to method are passed through, see RPCProxyMethods.
+func (p *rpcProxy) allowed(method string, local *localServer) bool {
+	for _, pattern := range p.methods {
+		if matchMethod(pattern, method) {
+			return true
+		}
+	}
+
+	for _, route := range p.routes {
+		for _, pattern := range route.methods {
+			if matchMethod(pattern, method) {
+				return true
+			}
+		}
+	}
+
+	return local.serves(method)
+}
+
+// descriptor returns the descriptor of method, or nil when it is unknown.
+func (p *rpcProxy) descriptor(method string) protoreflect.MethodDescriptor {
+	d, err := p.files.FindDescriptorByName(protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(method, "/"), "/", ".")))
+
+	if m, ok := d.(protoreflect.MethodDescriptor); err == nil && ok {
+		return m
+	}
+
+	return nil
+}
+
+// attachProxy dials the backends of the proxy and returns a handler serving its calls in front
+// of next. The metadata and the errors of the calls are handled as those of the routes of mux.
+// The connections are closed once ctx is canceled.
+func (o *GatewayOption) attachProxy(ctx context.Context, next http.Handler, mux *runtime.ServeMux, local *localServer) (http.Handler, error) {
+	conns := make(map[string]*grpc.ClientConn)
+	shared := o.clientOptions()
+
+	backends := []string{DefaultBackend}
+
+	if local != nil {
+		backends = append(backends, InProcessBackend)
+	}
+
+	for _, route := range o.proxy.routes {
+		backends = append(backends, route.backend)
+	}
+
+	for _, name := range backends {
+		if _, ok := conns[name]; ok {
+			continue
+		}
+
+		host, opts, err := o.backendTarget(name, shared, local)
+
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := grpc.NewClient(host, opts...)
+
+		if err != nil {
+			return nil, err
+		}
+
+		conns[name] = conn
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		for _, conn := range conns {
+			if err := conn.Close(); err != nil {
+				grpclog.Errorf("Failed to close conn to %s: %v", conn.Target(), err)
+			}
+		}
+	}()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var serve func(http.ResponseWriter, *http.Request, *proxyCall)
+
+		switch {
+		case o.proxy.grpcWeb && isGRPCWeb(r):
+			serve = serveGRPCWeb
+		case o.proxy.connect && isConnect(r):
+			serve = serveConnect
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.URL.Path
+		backend := o.proxy.backend(method)
+
+		// The services of WithService are called in process unless routed elsewhere
+		if backend == DefaultBackend && local.serves(method) {
+			backend = InProcessBackend
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxProxyRequestSize)
+
+		call := &proxyCall{method: method, desc: o.proxy.descriptor(method), conn: conns[backend], rules: o.metas.res}
+		call.capture = func(err error) (*status.Status, *ErrorResult) {
+			return o.errorResult(r.Context(), mux, w, r, err)
+		}
+
+		if !isRPCMethod(method) {
+			call.err = status.Errorf(codes.Unimplemented, "malformed method name %q", method)
+		} else if !o.proxy.allowed(method, local) {
+			call.err = status.Errorf(codes.Unimplemented, "unknown method %s", method)
+		}
+
+		md, err := proxyMetadata(mux, r, method)
+
+		if err != nil && call.err == nil {
+			call.err = err
+		}
+
+		call.md = md
+
+		serve(w, r, call)
+	}), nil
+}
+
+// isRPCMethod reports whether path has the form "/package.Service/Method".
+func isRPCMethod(path string) bool {
+	parts := strings.Split(path, "/")
+
+	return len(parts) == 3 && parts[0] == "" && parts[1] != "" && parts[2] != ""
+}
+
+// proxyMetadata converts the request headers into the metadata of the call as mux does for its
+// routes, with its header matcher and metadata annotators.
+func proxyMetadata(mux *runtime.ServeMux, r *http.Request, method string) (metadata.MD, error) {
+	ctx, err := runtime.AnnotateContext(r.Context(), mux, r, method)
+
+	if err != nil {
+		return nil, err
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+
+	return md, nil
+}
+
+// writeMetadataHeaders adds md to the headers h, encoding the binary values. The content type and
+// the reserved gRPC keys of the backend response are left out.
+func writeMetadataHeaders(h http.Header, md metadata.MD, prefix string) {
+	for key, values := range md {
+		if key == "content-type" || strings.HasPrefix(key, "grpc-") {
+			continue
+		}
+
+		for _, value := range values {
+			if strings.HasSuffix(key, "-bin") {
+				value = base64.RawStdEncoding.EncodeToString([]byte(value))
+			}
+
+			h.Add(prefix+textproto.CanonicalMIMEHeaderKey(key), value)
+		}
+	}
+}
+
+// proxyCall is a call passed through to a backend. A call with err set is answered with the
+// error without reaching the backend.
+type proxyCall struct {
+	method string
+	desc   protoreflect.MethodDescriptor // nil when the gateway lacks the descriptor of method
+	conn   *grpc.ClientConn
+	md     metadata.MD
+	err    error
+	// rules are the ResponseMeta rules applied to the header and trailer of the backend
+	rules mdValues
+	// capture answers the errors of the call as the error handler of the REST routes does
+	capture func(err error) (*status.Status, *ErrorResult)
+
+	header  metadata.MD
+	trailer metadata.MD
+	// result is the ErrorResult answering the call, if any
+	result *ErrorResult
+}
+
+// invoke sends the messages returned by next until io.EOF, and hands the responses to recv until
+// the call completes, returning its status. The first message is read before the call is started,
+// and a failing read cancels it. The header of the backend is read before the first response is
+// handed over.
+func (c *proxyCall) invoke(ctx context.Context, next func() ([]byte, error), recv func([]byte) error) *status.Status {
+	if c.err != nil {
+		return c.fail(c.err)
+	}
+
+	msg, err := next()
+
+	if err != nil && err != io.EOF {
+		return c.fail(err)
+	}
+
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(ctx, c.md))
+	defer cancel()
+
+	stream, serr := c.conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, c.method, grpc.ForceCodec(rawCodec{}))
+
+	if serr != nil {
+		return c.fail(serr)
+	}
+
+	for err == nil {
+		// A failed send ends the stream, whose status is then reported by RecvMsg
+		if stream.SendMsg(msg) != nil {
+			break
+		}
+
+		msg, err = next()
+	}
+
+	if err != nil && err != io.EOF {
+		return c.fail(err)
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return c.fail(err)
+	}
+
+	header, _ := stream.Header()
+	c.header = c.rules.response(header, runtime.MetadataHeaderPrefix)
+
+	for {
+		var msg []byte
+
+		if err = stream.RecvMsg(&msg); err != nil {
+			break
+		}
+
+		if err = recv(msg); err != nil {
+			break
+		}
+	}
+
+	c.trailer = c.rules.response(stream.Trailer(), runtime.MetadataTrailerPrefix)
+
+	if err == io.EOF {
+		return status.New(codes.OK, "")
+	}
+
+	return c.fail(err)
+}
+
+// fail returns the status answering err through capture. The message of its ErrorResult is added
+// to the details of the status, as the protocols of the proxy carry their own error responses.
+func (c *proxyCall) fail(err error) *status.Status {
+	if c.capture == nil {
+		return status.Convert(err)
+	}
+
+	st, result := c.capture(err)
+
+	if result == nil || result.Message == nil {
+		return st
+	}
+
+	c.result = result
+	detail, aerr := anypb.New(result.Message)
+
+	if aerr != nil {
+		grpclog.Errorf("Failed to marshal error message %q: %v", st, aerr)
+		return st
+	}
+
+	p := st.Proto()
+	p.Details = append(p.Details, detail)
+
+	return status.FromProto(p)
+}
+
+// rawCodec passes the encoded messages of the proxy through to the backend unchanged.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	buf, ok := v.([]byte)
+
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+
+	return buf, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	buf, ok := v.(*[]byte)
+
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+
+	*buf = append([]byte(nil), data...)
+
+	return nil
+}
+
+// Name is "proto", so that the backend sees the content type of the binary codec.
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// Envelope flags of the length-prefixed messages of gRPC-Web and Connect streams.
+const (
+	envelopeCompressed byte = 0x01
+	envelopeEndStream  byte = 0x02
+	envelopeTrailer    byte = 0x80
+)
+
+// readEnvelope reads a length-prefixed message. It returns io.EOF at the end of r.
+func readEnvelope(r io.Reader) (byte, []byte, error) {
+	var prefix [5]byte
+
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, status.Error(codes.InvalidArgument, "truncated message prefix")
+		}
+
+		return 0, nil, readError(err)
+	}
+
+	size := binary.BigEndian.Uint32(prefix[1:])
+
+	if size > maxProxyMessageSize {
+		return 0, nil, status.Errorf(codes.ResourceExhausted, "message of %d bytes exceeds the limit of %d", size, maxProxyMessageSize)
+	}
+
+	buf := make([]byte, size)
+
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, nil, status.Error(codes.InvalidArgument, "truncated message")
+		}
+
+		return 0, nil, readError(err)
+	}
+
+	return prefix[0], buf, nil
+}
+
+// readError reports a request body exceeding maxProxyRequestSize as ResourceExhausted.
+func readError(err error) error {
+	var tooLarge *http.MaxBytesError
+
+	if errors.As(err, &tooLarge) {
+		return status.Errorf(codes.ResourceExhausted, "request exceeds the limit of %d bytes", tooLarge.Limit)
+	}
+
+	return err
+}
+
+func appendEnvelope(dst []byte, flags byte, msg []byte) []byte {
+	dst = append(dst, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(dst[len(dst)-4:], uint32(len(msg)))
+
+	return append(dst, msg...)
+}
+
+// mediaType returns the media type of the Content-Type header of r, without its parameters.
+func mediaType(r *http.Request) string {
+	ct, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+
+	return strings.ToLower(strings.TrimSpace(ct))
+}
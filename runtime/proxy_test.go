@@ -0,0 +1,158 @@
+package runtime
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// testProxyFiles resolves the descriptors of the test descriptor set, then of the generated code.
+type testProxyFiles struct {
+	files *protoregistry.Files
+}
+
+func (f testProxyFiles) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	if d, err := f.files.FindDescriptorByName(name); err == nil {
+		return d, nil
+	}
+
+	return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// startRPCProxy serves the test descriptor set and the health service on a backend, and returns
+// the proxy of a gateway configured with opts in front of a handler answering 404.
+func startRPCProxy(t *testing.T, opts ...GatewayOptionFunc) http.Handler {
+	addr := startDescriptorServer(t, testDescriptorSet(), func(s *grpc.Server) {
+		grpc_health_v1.RegisterHealthServer(s, health.NewServer())
+	})
+
+	host, port, err := net.SplitHostPort(addr)
+	assert.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	o := newGatewayOption(append([]GatewayOptionFunc{WithBackend(host, uint(p))}, opts...)...)
+
+	files, err := protodesc.NewFiles(testDescriptorSet())
+	assert.NoError(t, err)
+	o.proxy.files = testProxyFiles{files}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	mux := runtime.NewServeMux(append([]runtime.ServeMuxOption{errorCapture(o)}, o.muxOpts...)...)
+	handler, err := o.attachProxy(ctx, http.NotFoundHandler(), mux, nil)
+	assert.NoError(t, err)
+
+	return handler
+}
+
+// testItemMessage returns a message of the test descriptor set with the fields set.
+func testItemMessage(t *testing.T, name protoreflect.FullName, fields map[string]interface{}) *dynamicpb.Message {
+	files, err := protodesc.NewFiles(testDescriptorSet())
+	assert.NoError(t, err)
+
+	d, err := files.FindDescriptorByName(name)
+	assert.NoError(t, err)
+
+	msg := dynamicpb.NewMessage(d.(protoreflect.MessageDescriptor))
+
+	for key, value := range fields {
+		msg.Set(msg.Descriptor().Fields().ByName(protoreflect.Name(key)), protoreflect.ValueOf(value))
+	}
+
+	return msg
+}
+
+func testItemBytes(t *testing.T, fields map[string]interface{}) []byte {
+	buf, err := proto.Marshal(testItemMessage(t, "test.v1.GetItemRequest", fields))
+	assert.NoError(t, err)
+
+	return buf
+}
+
+// testItemName decodes an Item and returns its name.
+func testItemName(t *testing.T, buf []byte) string {
+	item := testItemMessage(t, "test.v1.Item", nil)
+	assert.NoError(t, proto.Unmarshal(buf, item))
+
+	return item.Get(item.Descriptor().Fields().ByName("name")).String()
+}
+
+func TestProxyMetadata(t *testing.T) {
+	o := newGatewayOption(WithMetadata(
+		PassThrowMeta([]string{"X-Tenant"}, RequestMeta),
+		DeleteMeta([]string{"GRPC-Metadata-Set-*"}, ResponseMeta),
+	))
+	mux := runtime.NewServeMux(o.muxOpts...)
+
+	r := httptest.NewRequest("POST", "/test.v1.ItemService/GetItem", nil)
+	r.Header.Set("Content-Type", "application/grpc-web+proto")
+	r.Header.Set("Authorization", "Bearer token")
+	r.Header.Set("Cookie", "session=1")
+	r.Header.Set("X-Api-Key", "secret")
+	r.Header.Set("X-Tenant", "acme")
+	r.Header.Set("Grpc-Metadata-X-Trace-Bin", "AQID")
+	r.Header.Set("Grpc-Timeout", "1S")
+	r.Header.Set("Connect-Protocol-Version", "1")
+
+	md, err := proxyMetadata(mux, r, "/test.v1.ItemService/GetItem")
+	assert.NoError(t, err)
+
+	// The headers are forwarded as for the REST routes
+	assert.Equal(t, []string{"Bearer token"}, md.Get("authorization"))
+	assert.Equal(t, []string{"acme"}, md.Get("x-tenant"))
+	assert.Equal(t, []string{"\x01\x02\x03"}, md.Get("x-trace-bin"))
+
+	for _, key := range []string{"cookie", "x-api-key", "grpc-timeout", "connect-protocol-version"} {
+		assert.Empty(t, md.Get(key), key)
+	}
+
+	// The response metadata deleted by the rules are left out of the proxy responses
+	header := metadata.Pairs("set-cookie", "session=2", "x-trace-bin", "\x01\x02\x03")
+
+	h := make(http.Header)
+	writeMetadataHeaders(h, o.metas.res.response(header, runtime.MetadataHeaderPrefix), "Trailer-")
+
+	assert.Equal(t, http.Header{"Trailer-X-Trace-Bin": {"AQID"}}, h)
+
+	// The REST routes write the response metadata as before
+	ctx := runtime.NewServerMetadataContext(context.Background(), runtime.ServerMetadata{HeaderMD: header})
+	w := httptest.NewRecorder()
+	runtime.HTTPError(ctx, mux, &runtime.JSONPb{}, w, r, status.Error(codes.NotFound, "missing"))
+
+	assert.Equal(t, "session=2", w.Header().Get("Grpc-Metadata-Set-Cookie"))
+	assert.Equal(t, "\x01\x02\x03", w.Header().Get("Grpc-Metadata-X-Trace-Bin"))
+}
+
+func TestRPCProxy_Allowed(t *testing.T) {
+	// Without methods the proxy passes nothing through
+	o := newGatewayOption(WithGRPCWeb())
+	assert.False(t, o.proxy.allowed("/test.v1.ItemService/GetItem", nil))
+
+	o = newGatewayOption(WithConnect(
+		RPCProxyMethods("/test.v1.ItemService/*"),
+		RPCProxyBackend("orders", "/test.v1.OrderService/GetOrder"),
+	))
+
+	assert.True(t, o.proxy.allowed("/test.v1.ItemService/GetItem", nil))
+	assert.True(t, o.proxy.allowed("/test.v1.OrderService/GetOrder", nil))
+	assert.False(t, o.proxy.allowed("/test.v1.OrderService/DeleteOrder", nil))
+	assert.False(t, o.proxy.allowed("/grpc.health.v1.Health/Check", nil))
+}
@@ -0,0 +1,419 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm selects how a RateLimit counts the requests.
+type RateLimitAlgorithm string
+
+const (
+	// TokenBucket refills Requests tokens per Period into a bucket holding up to Burst of them.
+	TokenBucket RateLimitAlgorithm = "token_bucket"
+	// SlidingWindow allows Requests per Period, weighting the previous period by its overlap
+	// with the sliding window.
+	SlidingWindow RateLimitAlgorithm = "sliding_window"
+)
+
+// RateLimit allows Requests per Period to every client key. Burst is the capacity of the token
+// bucket and defaults to Requests.
+type RateLimit struct {
+	Requests  int
+	Period    time.Duration
+	Burst     int
+	Algorithm RateLimitAlgorithm
+}
+
+// RateLimitResult is the decision of a RateLimitStore for one request.
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the quota is fully available again.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, when this one was not.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore keeps the request counts of the rate limited keys. The in-memory store of
+// NewMemoryRateLimitStore limits every gateway instance on its own; an implementation backed by a
+// shared store limits the clients across instances.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, limit RateLimit) (RateLimitResult, error)
+}
+
+// RateLimitKeyFunc returns the key a request is counted under. Requests with an empty key are
+// not limited; the key functions of this package fall back to the IP address instead, and KeyOrIP
+// does the same for other key functions.
+type RateLimitKeyFunc func(r *http.Request) string
+
+type RateLimitOption func(*rateLimiter)
+
+type rateLimitRoute struct {
+	method  string
+	pattern string
+	limit   RateLimit
+}
+
+type rateLimiter struct {
+	limit  RateLimit
+	routes []rateLimitRoute
+	key    RateLimitKeyFunc
+	store  RateLimitStore
+}
+
+// Rate limit response headers.
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+	RetryAfterHeader         = "Retry-After"
+)
+
+// WithRateLimit is a GatewayOptionFunc that adds RateLimitHandler to the handler chain.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithRateLimit(RateLimit{Requests: 100, Period: time.Minute},
+//	        RateLimitKey(KeyByAPIKey("X-API-Key")),
+//	        RateLimitRoute("POST", "/v1/reports", RateLimit{Requests: 5, Period: time.Minute}),
+//	    ),
+//	)
+func WithRateLimit(limit RateLimit, opts ...RateLimitOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.handlers = append(opt.handlers, RateLimitHandler(limit, opts...))
+	}
+}
+
+// RateLimitHandler returns a GatewayHandler limiting the requests of every client key to limit,
+// or to the limit of the first matching RateLimitRoute. Clients are keyed by IP address unless
+// RateLimitKey is given. Rejected requests are answered with ResourceExhausted, that is
+// 429 Too Many Requests, and a Retry-After header; allowed ones carry the RateLimit-* headers.
+// A limit with zero Requests leaves the requests unlimited.
+// The store is shared by the generations built with the handler, so that the counts carry over a
+// Reload. The rate_limit section of a configuration file builds a new handler with a new memory
+// store on every reload instead, whose counts start from zero.
+func RateLimitHandler(limit RateLimit, opts ...RateLimitOption) GatewayHandler {
+	l := &rateLimiter{limit: limit, key: KeyByIP()}
+
+	for _, o := range opts {
+		o(l)
+	}
+
+	if l.store == nil {
+		l.store = NewMemoryRateLimitStore()
+	}
+
+	return func(h http.Handler, o *GatewayOption) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := l.key(r)
+			route, limit := l.route(r)
+
+			if key == "" || limit.Requests <= 0 {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			result, err := l.store.Take(r.Context(), route+"|"+key, limit)
+
+			// Requests are let through while the store is unavailable
+			if err != nil {
+				o.err.Errorf("Rate limit store error: %v", err)
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			header := w.Header()
+			header.Set(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+			header.Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+			header.Set(RateLimitResetHeader, strconv.Itoa(ceilSeconds(result.Reset)))
+
+			if !result.Allowed {
+				header.Set(RetryAfterHeader, strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				httpError(w, r, status.Error(codes.ResourceExhausted, "rate limit exceeded"))
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimitKey sets how the clients are told apart.
+func RateLimitKey(key RateLimitKeyFunc) RateLimitOption {
+	return func(l *rateLimiter) {
+		l.key = key
+	}
+}
+
+// RateLimitStorage replaces the in-memory store.
+func RateLimitStorage(store RateLimitStore) RateLimitOption {
+	return func(l *rateLimiter) {
+		l.store = store
+	}
+}
+
+// RateLimitRoute sets the limit of the requests matching the HTTP method and path pattern, with
+// the pattern syntax of WithRouteTimeout. Each route counts the requests of a client separately.
+func RateLimitRoute(method, pattern string, limit RateLimit) RateLimitOption {
+	return func(l *rateLimiter) {
+		l.routes = append(l.routes, rateLimitRoute{strings.ToUpper(method), pattern, limit})
+	}
+}
+
+// route returns the name and limit of the route matching the request.
+func (l *rateLimiter) route(r *http.Request) (string, RateLimit) {
+	for _, route := range l.routes {
+		if route.method != "" && route.method != "*" && route.method != r.Method {
+			continue
+		}
+
+		if matchPath(route.pattern, r.URL.Path) {
+			return route.method + " " + route.pattern, route.limit
+		}
+	}
+
+	return "", l.limit
+}
+
+// KeyByIP keys the clients by the IP address of the connection.
+func KeyByIP() RateLimitKeyFunc {
+	return func(r *http.Request) string {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+		if err != nil {
+			return r.RemoteAddr
+		}
+
+		return host
+	}
+}
+
+// KeyByHeader keys the clients by the value of the request header name, and the requests
+// without it by IP address.
+func KeyByHeader(name string) RateLimitKeyFunc {
+	return KeyOrIP(func(r *http.Request) string {
+		if value := r.Header.Get(name); value != "" {
+			return name + ":" + value
+		}
+
+		return ""
+	})
+}
+
+// KeyByAPIKey keys the clients by the API key sent in the request header name, which is hashed
+// so that the keys are not held in the store, and the requests without it by IP address.
+func KeyByAPIKey(name string) RateLimitKeyFunc {
+	return KeyOrIP(func(r *http.Request) string {
+		value := r.Header.Get(name)
+
+		if value == "" {
+			return ""
+		}
+
+		sum := sha256.Sum256([]byte(value))
+
+		return "key:" + hex.EncodeToString(sum[:])
+	})
+}
+
+// KeyBySubject keys the clients by the subject of their Identity, or of their verified client
+// certificate when they are not authenticated otherwise, and the anonymous requests by IP address.
+func KeyBySubject() RateLimitKeyFunc {
+	return KeyOrIP(func(r *http.Request) string {
+		if id, ok := IdentityFromContext(r.Context()); ok && id.Subject != "" {
+			return id.Scheme + ":" + id.Subject
+		}
+
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+			return ""
+		}
+
+		return "subject:" + r.TLS.VerifiedChains[0][0].Subject.String()
+	})
+}
+
+// KeyOrIP keys the clients by key and falls back to their IP address, for key functions
+// returning an empty key.
+func KeyOrIP(key RateLimitKeyFunc) RateLimitKeyFunc {
+	ip := KeyByIP()
+
+	return func(r *http.Request) string {
+		if value := key(r); value != "" {
+			return value
+		}
+
+		return ip(r)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+type memoryRateLimitEntry struct {
+	// token bucket
+	tokens float64
+	last   time.Time
+	// sliding window
+	start    time.Time
+	current  int
+	previous int
+	// expires is when the entry is back to its initial state and can be dropped
+	expires time.Time
+}
+
+// memoryRateLimitSweep is the interval at which the expired entries are dropped.
+const memoryRateLimitSweep = time.Minute
+
+type memoryRateLimitStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryRateLimitEntry
+	swept   time.Time
+	now     func() time.Time
+}
+
+// NewMemoryRateLimitStore returns a RateLimitStore keeping the counts in memory.
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		entries: make(map[string]*memoryRateLimitEntry),
+		now:     time.Now,
+	}
+}
+
+func (s *memoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (RateLimitResult, error) {
+	if limit.Period <= 0 {
+		return RateLimitResult{}, fmt.Errorf("invalid rate limit period %s", limit.Period)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	e, ok := s.entries[key]
+
+	if !ok {
+		e = &memoryRateLimitEntry{tokens: float64(limit.burst()), last: now, start: now}
+		s.entries[key] = e
+	}
+
+	var result RateLimitResult
+	var expires time.Time
+
+	if limit.Algorithm == SlidingWindow {
+		result = e.slide(now, limit)
+		// Both counted windows have slid out
+		expires = e.start.Add(2 * limit.Period)
+	} else {
+		result = e.take(now, limit)
+		// The bucket has refilled
+		expires = now.Add(result.Reset)
+	}
+
+	if expires.After(e.expires) {
+		e.expires = expires
+	}
+
+	return result, nil
+}
+
+// sweep drops the expired entries, at most once per memoryRateLimitSweep. Every entry expires by
+// the limit it was taken with, so that the limits of other routes do not drop it early.
+func (s *memoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < memoryRateLimitSweep {
+		return
+	}
+
+	s.swept = now
+
+	for key, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+
+	return l.Requests
+}
+
+func (e *memoryRateLimitEntry) take(now time.Time, limit RateLimit) RateLimitResult {
+	burst := float64(limit.burst())
+	rate := float64(limit.Requests) / limit.Period.Seconds()
+
+	e.tokens = math.Min(burst, e.tokens+now.Sub(e.last).Seconds()*rate)
+	e.last = now
+
+	result := RateLimitResult{Limit: limit.burst()}
+
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - e.tokens) / rate * float64(time.Second))
+	}
+
+	result.Remaining = int(e.tokens)
+	result.Reset = time.Duration((burst - e.tokens) / rate * float64(time.Second))
+
+	return result
+}
+
+func (e *memoryRateLimitEntry) slide(now time.Time, limit RateLimit) RateLimitResult {
+	elapsed := now.Sub(e.start)
+
+	if elapsed >= limit.Period {
+		windows := int(elapsed / limit.Period)
+
+		if windows == 1 {
+			e.previous = e.current
+		} else {
+			e.previous = 0
+		}
+
+		e.current = 0
+		e.start = e.start.Add(time.Duration(windows) * limit.Period)
+		elapsed = now.Sub(e.start)
+	}
+
+	e.last = now
+	weight := 1 - elapsed.Seconds()/limit.Period.Seconds()
+	count := float64(e.previous)*weight + float64(e.current)
+
+	result := RateLimitResult{Limit: limit.Requests, Reset: limit.Period - elapsed}
+
+	if count+1 <= float64(limit.Requests) {
+		e.current++
+		count++
+		result.Allowed = true
+	} else if e.previous > 0 && float64(e.current) < float64(limit.Requests) {
+		// The count drops below the limit once enough of the previous window has slid out
+		free := (float64(limit.Requests) - 1 - float64(e.current)) / float64(e.previous)
+		result.RetryAfter = time.Duration((1-free)*float64(limit.Period)) - elapsed
+	} else {
+		result.RetryAfter = limit.Period - elapsed
+	}
+
+	result.Remaining = int(math.Max(0, math.Floor(float64(limit.Requests)-count)))
+
+	return result
+}
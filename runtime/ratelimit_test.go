@@ -0,0 +1,184 @@
+package runtime
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestRateLimitStore(now *time.Time) *memoryRateLimitStore {
+	store := NewMemoryRateLimitStore().(*memoryRateLimitStore)
+	store.now = func() time.Time {
+		return *now
+	}
+
+	return store
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit RateLimit
+		// allowed at each second offset
+		steps []struct {
+			at      time.Duration
+			allowed bool
+		}
+	}{
+		{
+			name:  "token bucket",
+			limit: RateLimit{Requests: 2, Period: 2 * time.Second, Algorithm: TokenBucket},
+			steps: []struct {
+				at      time.Duration
+				allowed bool
+			}{{0, true}, {0, true}, {0, false}, {time.Second, true}, {time.Second, false}},
+		},
+		{
+			name:  "sliding window",
+			limit: RateLimit{Requests: 2, Period: 2 * time.Second, Algorithm: SlidingWindow},
+			steps: []struct {
+				at      time.Duration
+				allowed bool
+			}{{0, true}, {0, true}, {time.Second, false}, {3 * time.Second, true}, {3 * time.Second, false}, {4 * time.Second, true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			now := start
+			store := newTestRateLimitStore(&now)
+
+			for i, step := range tt.steps {
+				now = start.Add(step.at)
+				result, err := store.Take(context.Background(), "client", tt.limit)
+
+				assert.NoError(t, err)
+				assert.Equal(t, step.allowed, result.Allowed, "step %d", i)
+
+				if !result.Allowed {
+					assert.Greater(t, result.RetryAfter, time.Duration(0), "step %d", i)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRateLimitStore_Sweep(t *testing.T) {
+	start := time.Now()
+	now := start
+	store := newTestRateLimitStore(&now)
+
+	take := func(key string, limit RateLimit) bool {
+		result, err := store.Take(context.Background(), key, limit)
+		assert.NoError(t, err)
+
+		return result.Allowed
+	}
+
+	hourly := RateLimit{Requests: 1, Period: time.Hour, Algorithm: SlidingWindow}
+	bucket := RateLimit{Requests: 1, Period: time.Minute, Burst: 10, Algorithm: TokenBucket}
+	second := RateLimit{Requests: 1, Period: time.Second}
+
+	assert.True(t, take("hourly", hourly))
+
+	for i := 0; i < 10; i++ {
+		assert.True(t, take("bucket", bucket))
+	}
+
+	// The sweeps run by a route with a short period keep the entries of the other limits
+	for _, at := range []time.Duration{5 * time.Second, 2 * time.Minute, 5 * time.Minute} {
+		now = start.Add(at)
+		assert.True(t, take("second", second))
+		assert.Contains(t, store.entries, "hourly")
+		assert.Contains(t, store.entries, "bucket")
+	}
+
+	assert.False(t, take("hourly", hourly))
+
+	// Five of the ten tokens have refilled
+	for i := 0; i < 5; i++ {
+		assert.True(t, take("bucket", bucket))
+	}
+
+	assert.False(t, take("bucket", bucket))
+
+	// Expired entries are dropped
+	now = start.Add(3 * time.Hour)
+	assert.True(t, take("second", second))
+	assert.NotContains(t, store.entries, "hourly")
+	assert.NotContains(t, store.entries, "bucket")
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	server := newGatewayOption()
+	handler := RateLimitHandler(RateLimit{Requests: 1, Period: time.Minute},
+		RateLimitKey(KeyByHeader("X-Client")),
+		RateLimitRoute("POST", "/v1/reports", RateLimit{Requests: 2, Period: time.Minute}),
+	)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), server)
+
+	serve := func(method, path, client string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+
+		if client != "" {
+			r.Header.Set("X-Client", client)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := serve("GET", "/v1/users", "a")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1", w.Header().Get(RateLimitLimitHeader))
+	assert.Equal(t, "0", w.Header().Get(RateLimitRemainingHeader))
+
+	w = serve("GET", "/v1/users", "a")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get(RetryAfterHeader))
+	assert.Contains(t, w.Body.String(), "rate limit exceeded")
+
+	// Other clients, routes and unkeyed requests are counted separately
+	assert.Equal(t, http.StatusOK, serve("GET", "/v1/users", "b").Code)
+	assert.Equal(t, http.StatusOK, serve("POST", "/v1/reports", "a").Code)
+	assert.Equal(t, http.StatusOK, serve("POST", "/v1/reports", "a").Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve("POST", "/v1/reports", "a").Code)
+	assert.Equal(t, http.StatusOK, serve("GET", "/v1/users", "").Code)
+
+	// Unkeyed requests are limited by IP address
+	assert.Equal(t, http.StatusTooManyRequests, serve("GET", "/v1/users", "").Code)
+}
+
+func TestRateLimitConfig_KeyFallback(t *testing.T) {
+	for _, key := range []string{"subject", "api_key:X-API-Key", "header:X-Client"} {
+		t.Run(key, func(t *testing.T) {
+			limiter, err := (&RateLimitConfig{Requests: 1, Period: Duration(time.Minute), Key: key}).handler()
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			handler := limiter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}), newGatewayOption())
+
+			serve := func() int {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/users", nil))
+
+				return w.Code
+			}
+
+			// Leaving the key out falls back to the IP address instead of lifting the limit
+			assert.Equal(t, http.StatusOK, serve())
+			assert.Equal(t, http.StatusTooManyRequests, serve())
+		})
+	}
+}
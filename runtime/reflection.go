@@ -0,0 +1,310 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reflectionTimeout bounds one round of reflection requests to a backend.
+const reflectionTimeout = 10 * time.Second
+
+// WithReflection is a GatewayOptionFunc that registers ReflectionEndpoint against the default
+// backend. With a refresh interval the backend is asked again at that interval, and the gateway
+// reloads as Reload does when its descriptors have changed, so that a new RPC is exposed without
+// regenerating and redeploying the gateway. Only the services named are served when some are given.
+//
+// Example usage:
+//
+//	// the backend calls reflection.Register(s)
+//	server := NewGateway(
+//	    WithBackend("127.0.0.1", 8080),
+//	    WithReflection(time.Minute),
+//	)
+func WithReflection(refresh time.Duration, services ...string) GatewayOptionFunc {
+	return WithBackendReflection(DefaultBackend, refresh, services...)
+}
+
+// WithBackendReflection registers ReflectionEndpoint against a named backend, as WithReflection
+// does for the default one.
+//
+// A backend that cannot be reflected does not fail the start or a Reload of the gateway: the
+// descriptors it last reflected are served, or none at all until a refresh reaches it.
+func WithBackendReflection(backend string, refresh time.Duration, services ...string) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		// The state is read when registering, since Reload hands its state to the gateway it builds
+		WithBackendEndpoint(backend, reflectionEndpoint(services, refresh, func() *gatewayState {
+			return opt.state
+		}))(opt)
+	}
+}
+
+// ReflectionEndpoint returns a GatewayEndpoint serving the methods annotated with google.api.http
+// of the services the backend lists through the grpc.reflection.v1 ServerReflection service, as
+// DescriptorEndpoint does for a descriptor set. Only the services named are served when some are
+// given. The descriptors are read once, when the endpoint is registered.
+func ReflectionEndpoint(services ...string) GatewayEndpoint {
+	return reflectionEndpoint(services, 0, nil)
+}
+
+// reflectedServices are the descriptors reflected by a backend and the services they serve.
+type reflectedServices struct {
+	set      *descriptorpb.FileDescriptorSet
+	services []string
+}
+
+// reflectionKey identifies the descriptors reflected by an endpoint across reloads.
+func reflectionKey(host string, services []string) string {
+	return host + "|" + strings.Join(services, ",")
+}
+
+// reflectionEndpoint reflects the services of the backend. With a state, a backend that cannot
+// be reflected serves its last reflected descriptors, or no route until the refresh reaches it.
+func reflectionEndpoint(services []string, refresh time.Duration, state func() *gatewayState) GatewayEndpoint {
+	return func(ctx context.Context, mux *runtime.ServeMux, host string, opts []grpc.DialOption) error {
+		var digest []byte
+		var reload func() error
+		var last *sync.Map
+
+		if state != nil {
+			reload, last = state().reload, &state().reflected
+		}
+
+		conn, err := serveDescriptors(ctx, mux, host, opts, "reflection on "+host, services, func(ctx context.Context, conn *grpc.ClientConn) (*protoregistry.Files, []string, error) {
+			key := reflectionKey(host, services)
+			set, listed, err := reflectDescriptors(ctx, conn, services)
+
+			if err != nil {
+				err = fmt.Errorf("failed to reflect services of %s: %s", host, err)
+
+				if last == nil {
+					return nil, nil, err
+				}
+
+				if cached, ok := last.Load(key); ok {
+					grpclog.Warningf("%v; serving the services last reflected", err)
+					set, listed = cached.(reflectedServices).set, cached.(reflectedServices).services
+				} else if refresh > 0 && reload != nil {
+					grpclog.Warningf("%v; serving no route until the backend is reflected", err)
+					return &protoregistry.Files{}, nil, nil
+				} else {
+					return nil, nil, err
+				}
+			} else if last != nil {
+				last.Store(key, reflectedServices{set, listed})
+			}
+
+			files, err := protodesc.NewFiles(set)
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid descriptors reflected by %s: %s", host, err)
+			}
+
+			digest = descriptorDigest(set)
+
+			return files, listed, nil
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if refresh > 0 && reload != nil {
+			go refreshReflection(ctx, conn, host, services, refresh, digest, reload)
+		}
+
+		return nil
+	}
+}
+
+// refreshReflection asks the backend for its descriptors every refresh interval until ctx is
+// canceled, and calls reload once they differ from digest, or once it answers when digest is nil.
+func refreshReflection(ctx context.Context, conn *grpc.ClientConn, host string, services []string, refresh time.Duration, digest []byte, reload func() error) {
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		set, _, err := reflectDescriptors(ctx, conn, services)
+
+		if err != nil {
+			grpclog.Warningf("Failed to refresh services of %s by reflection: %v", host, err)
+			continue
+		}
+
+		if bytes.Equal(digest, descriptorDigest(set)) || ctx.Err() != nil {
+			continue
+		}
+
+		grpclog.Infof("Services of %s have changed, reloading", host)
+
+		// On failure the current routes are kept and the next change is tried again
+		if err := reload(); err == nil {
+			return
+		}
+	}
+}
+
+// reflectDescriptors reads the files defining services, or every service the backend lists when
+// none are given, with their dependencies. It returns the services found.
+func reflectDescriptors(ctx context.Context, conn grpc.ClientConnInterface, services []string) (*descriptorpb.FileDescriptorSet, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, reflectionTimeout)
+	defer cancel()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		_ = stream.CloseSend()
+	}()
+
+	call := func(req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+
+		res, err := stream.Recv()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if e := res.GetErrorResponse(); e != nil {
+			return nil, fmt.Errorf("%s: %s", codes.Code(e.GetErrorCode()), e.GetErrorMessage())
+		}
+
+		return res, nil
+	}
+
+	if len(services) == 0 {
+		res, err := call(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{ListServices: "*"},
+		})
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for _, service := range res.GetListServicesResponse().GetService() {
+			if !strings.HasPrefix(service.GetName(), "grpc.reflection.") {
+				services = append(services, service.GetName())
+			}
+		}
+	}
+
+	files := make(map[string]*descriptorpb.FileDescriptorProto)
+
+	add := func(res *reflectionpb.ServerReflectionResponse) error {
+		for _, buf := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			file := &descriptorpb.FileDescriptorProto{}
+
+			if err := proto.Unmarshal(buf, file); err != nil {
+				return err
+			}
+
+			files[file.GetName()] = file
+		}
+
+		return nil
+	}
+
+	for _, service := range services {
+		res, err := call(&reflectionpb.ServerReflectionRequest{
+			MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: service},
+		})
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("service %s: %s", service, err)
+		}
+
+		if err := add(res); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The server may leave out the dependencies it has already sent on the stream
+	for missing := missingDependencies(files); len(missing) > 0; missing = missingDependencies(files) {
+		for _, name := range missing {
+			res, err := call(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: name},
+			})
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("file %s: %s", name, err)
+			}
+
+			if err := add(res); err != nil {
+				return nil, nil, err
+			}
+
+			if _, ok := files[name]; !ok {
+				return nil, nil, fmt.Errorf("file %s was not returned", name)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(files))
+
+	for name := range files {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	set := &descriptorpb.FileDescriptorSet{}
+
+	for _, name := range names {
+		set.File = append(set.File, files[name])
+	}
+
+	return set, services, nil
+}
+
+func missingDependencies(files map[string]*descriptorpb.FileDescriptorProto) []string {
+	missing := make([]string, 0)
+
+	for _, file := range files {
+		for _, dep := range file.GetDependency() {
+			if _, ok := files[dep]; !ok && !contains(missing, dep) {
+				missing = append(missing, dep)
+			}
+		}
+	}
+
+	return missing
+}
+
+// descriptorDigest fingerprints the files of set, which reflectDescriptors sorts by name.
+func descriptorDigest(set *descriptorpb.FileDescriptorSet) []byte {
+	h := sha256.New()
+
+	for _, file := range set.GetFile() {
+		buf, _ := proto.MarshalOptions{Deterministic: true}.Marshal(file)
+		_, _ = fmt.Fprintf(h, "%s:%d:", file.GetName(), len(buf))
+		h.Write(buf)
+	}
+
+	return h.Sum(nil)
+}
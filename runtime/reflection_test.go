@@ -0,0 +1,171 @@
+package runtime
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testReflectionFiles resolves descriptors from files that tests can replace while serving.
+type testReflectionFiles struct {
+	files atomic.Pointer[protoregistry.Files]
+}
+
+func (r *testReflectionFiles) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+	return r.files.Load().FindFileByPath(path)
+}
+
+func (r *testReflectionFiles) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+	return r.files.Load().FindDescriptorByName(name)
+}
+
+type testServiceInfo map[string]grpc.ServiceInfo
+
+func (s testServiceInfo) GetServiceInfo() map[string]grpc.ServiceInfo {
+	return s
+}
+
+func TestReflectionEndpoint(t *testing.T) {
+	set := testDescriptorSet()
+	files, err := protodesc.NewFiles(set)
+	assert.NoError(t, err)
+
+	resolver := &testReflectionFiles{}
+	resolver.files.Store(files)
+
+	addr := startDescriptorServer(t, set, func(s *grpc.Server) {
+		reflectionpb.RegisterServerReflectionServer(s, reflection.NewServerV1(reflection.ServerOptions{
+			Services:           testServiceInfo{"test.v1.ItemService": {}},
+			DescriptorResolver: resolver,
+		}))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dial := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	mux := runtime.NewServeMux()
+	assert.NoError(t, ReflectionEndpoint()(ctx, mux, addr, dial))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/v1/items/book?page=3", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, strings.ReplaceAll(w.Body.String(), " ", ""), `{"name":"book","page":3,"note":"note:book"}`)
+
+	err = ReflectionEndpoint("test.v1.OtherService")(ctx, runtime.NewServeMux(), addr, dial)
+	assert.ErrorContains(t, err, "test.v1.OtherService")
+
+	// A refreshing endpoint reloads once the backend serves other descriptors
+	reloads := make(chan struct{}, 1)
+	state := &gatewayState{reload: func() error {
+		reloads <- struct{}{}
+		return nil
+	}}
+	endpoint := reflectionEndpoint(nil, 10*time.Millisecond, func() *gatewayState {
+		return state
+	})
+	assert.NoError(t, endpoint(ctx, runtime.NewServeMux(), addr, dial))
+
+	select {
+	case <-reloads:
+		t.Fatal("reloaded without a change")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	changed := proto.Clone(set).(*descriptorpb.FileDescriptorSet)
+	service := changed.File[0].Service[0]
+	service.Method = append(service.Method, proto.Clone(service.Method[0]).(*descriptorpb.MethodDescriptorProto))
+	service.Method[len(service.Method)-1].Name = proto.String("LookupItem")
+
+	files, err = protodesc.NewFiles(changed)
+	assert.NoError(t, err)
+	resolver.files.Store(files)
+
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatal("not reloaded after a change")
+	}
+}
+
+func TestReflectionEndpoint_Unreachable(t *testing.T) {
+	set := testDescriptorSet()
+	files, err := protodesc.NewFiles(set)
+	assert.NoError(t, err)
+
+	// The backend fails to reflect while its resolver knows no file
+	resolver := &testReflectionFiles{}
+	resolver.files.Store(&protoregistry.Files{})
+
+	addr := startDescriptorServer(t, set, func(s *grpc.Server) {
+		reflectionpb.RegisterServerReflectionServer(s, reflection.NewServerV1(reflection.ServerOptions{
+			Services:           testServiceInfo{"test.v1.ItemService": {}},
+			DescriptorResolver: resolver,
+		}))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dial := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	reloads := make(chan struct{}, 1)
+	state := &gatewayState{reload: func() error {
+		reloads <- struct{}{}
+		return nil
+	}}
+	endpoint := reflectionEndpoint(nil, 10*time.Millisecond, func() *gatewayState {
+		return state
+	})
+
+	// Without refresh nor descriptors reflected before, the endpoint fails
+	err = reflectionEndpoint(nil, 0, func() *gatewayState {
+		return state
+	})(ctx, runtime.NewServeMux(), addr, dial)
+	assert.ErrorContains(t, err, "failed to reflect services")
+
+	// With refresh the endpoint starts without routes, and reloads once the backend answers
+	mux := runtime.NewServeMux()
+	assert.NoError(t, endpoint(ctx, mux, addr, dial))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/v1/items/book", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	resolver.files.Store(files)
+
+	select {
+	case <-reloads:
+	case <-time.After(time.Second):
+		t.Fatal("not reloaded once reflected")
+	}
+
+	// The reloaded endpoint serves the routes, then keeps them while the backend fails again
+	for _, failing := range []bool{false, true} {
+		if failing {
+			resolver.files.Store(&protoregistry.Files{})
+		}
+
+		mux = runtime.NewServeMux()
+		assert.NoError(t, endpoint(ctx, mux, addr, dial))
+
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/v1/items/book", nil))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,422 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// generation is one build of the mux and handler chain together with the backend connections
+// registered for it. The listener dispatches every request to the current generation, so a
+// reload can replace it without closing the connections that are being served.
+type generation struct {
+	handler http.Handler
+	cancel  context.CancelFunc
+
+	mu      sync.Mutex
+	retired bool
+	active  sync.WaitGroup
+}
+
+// acquire registers an in-flight request, unless the generation has been retired.
+func (g *generation) acquire() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.retired {
+		return false
+	}
+
+	g.active.Add(1)
+
+	return true
+}
+
+func (g *generation) release() {
+	g.active.Done()
+}
+
+// retire stops new requests from entering the generation, waits up to timeout for the
+// in-flight ones and then closes its backend connections.
+func (g *generation) retire(timeout time.Duration) {
+	g.mu.Lock()
+	g.retired = true
+	g.mu.Unlock()
+
+	if timeout > 0 {
+		idle := make(chan struct{})
+
+		go func() {
+			g.active.Wait()
+			close(idle)
+		}()
+
+		select {
+		case <-idle:
+		case <-time.After(timeout):
+		}
+	}
+
+	g.cancel()
+}
+
+func (o *GatewayOption) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	for {
+		gen := o.gen.Load()
+
+		if gen == nil {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		// A retired generation has already been replaced, so load the current one again
+		if gen.acquire() {
+			defer gen.release()
+			gen.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+}
+
+// swap makes gen the generation serving new requests and retires the previous one
+// once its in-flight requests have completed.
+func (o *GatewayOption) swap(gen *generation) {
+	if old := o.gen.Swap(gen); old != nil {
+		go old.retire(o.timeout)
+	}
+}
+
+func (o *GatewayOption) running() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.httpd != nil
+}
+
+// WithConfigWatch is a GatewayOptionFunc that makes a gateway created with NewGatewayFromConfig
+// watch its configuration file while running and call Reload whenever the file changes.
+// The descriptor sets of WithDescriptorSet are watched as well, also without a configuration file.
+func WithConfigWatch(watch bool) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.watch = watch
+	}
+}
+
+// Reload reopens the access and error log files and rebuilds the mux, the handler chain and the
+// backend connections. For gateways created with NewGatewayFromConfig the configuration file is
+// read again first, so the metadata rules, error handlers and handler chain follow the file.
+//
+// The new generation is swapped in atomically while the listener keeps serving; requests already
+// in flight complete on the previous one. Only a change of the server address, TLS settings, h2c
+// or server limits rebinds the listener. A configuration that fails to load or build is rejected
+// with a logged diff against the running configuration, which is left intact.
+func (o *GatewayOption) Reload() error {
+	o.ops.Lock()
+	defer o.ops.Unlock()
+
+	return o.reload()
+}
+
+func (o *GatewayOption) reload() error {
+	o.err.Infof("Gateway server reloading on %s -> %s", o.server.ToString(), o.backendAddrs())
+
+	if o.configPath == "" {
+		o.reopenLog()
+
+		if !o.running() {
+			return nil
+		}
+
+		gen, err := o.build()
+
+		if err != nil {
+			o.err.Errorf("Rejected reload: %v", err)
+			return err
+		}
+
+		o.swap(gen)
+
+		return nil
+	}
+
+	c, err := LoadConfig(o.configPath)
+
+	if err != nil {
+		o.err.Errorf("Rejected configuration reload: %v", err)
+		return err
+	}
+
+	changes := diffConfig(o.config, c)
+
+	next, gen, err := o.prepare(c)
+
+	if err != nil {
+		o.err.Errorf("Rejected configuration reload from %s: %v%s", o.configPath, err, formatDiff(changes))
+		return err
+	}
+
+	relisten := next.server != o.server || !reflect.DeepEqual(next.tls, o.tls) || next.h2c != o.h2c || next.limits != o.limits
+
+	o.adopt(next, c)
+	o.reopenLog()
+
+	if len(changes) > 0 {
+		o.err.Infof("Configuration reloaded from %s%s", o.configPath, formatDiff(changes))
+	}
+
+	if !o.running() {
+		gen.retire(0)
+		return nil
+	}
+
+	if relisten {
+		gen.retire(0)
+		return o.restart()
+	}
+
+	o.swap(gen)
+
+	return nil
+}
+
+// prepare builds a gateway from c and the options given to NewGatewayFromConfig, sharing the
+// loggers and runtime state of o, and builds its generation to validate it before anything is swapped.
+func (o *GatewayOption) prepare(c *Config) (*GatewayOption, *generation, error) {
+	options, err := c.Options()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	next := newGatewayOption(append(options, o.configOpts...)...)
+	next.log, next.err = o.log, o.err
+	next.state = o.state
+
+	if _, err := next.server.ValidToString(); err != nil {
+		return nil, nil, err
+	}
+
+	gen, err := next.build()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return next, gen, nil
+}
+
+// snapshot copies the configuration of o for a generation to read while it serves. The
+// generation keeps its copy when adopt or a later option changes o.
+func (o *GatewayOption) snapshot() *GatewayOption {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return &GatewayOption{
+		server:       o.server,
+		tls:          o.tls,
+		backends:     o.backends,
+		endpoints:    o.endpoints,
+		dialOpts:     o.dialOpts,
+		handlers:     o.handlers,
+		muxOpts:      o.muxOpts,
+		errors:       o.errors,
+		paths:        o.paths,
+		proxy:        o.proxy,
+		streams:      o.streams,
+		services:     o.services,
+		metas:        o.metas,
+		silent:       o.silent,
+		timeout:      o.timeout,
+		h2c:          o.h2c,
+		limits:       o.limits,
+		logs:         o.logs,
+		log:          o.log,
+		err:          o.err,
+		interceptors: o.interceptors,
+		policies:     o.policies,
+		deadlines:    o.deadlines,
+		retry:        o.retry,
+		breaker:      o.breaker,
+		serverOpts:   o.serverOpts,
+		config:       o.config,
+		configPath:   o.configPath,
+		configOpts:   o.configOpts,
+		watch:        o.watch,
+		watched:      o.watched,
+		state:        o.state,
+	}
+}
+
+// adopt takes over the configuration of next. The generations read the snapshots they were
+// built from, so none of them observes the change.
+func (o *GatewayOption) adopt(next *GatewayOption, c *Config) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.server = next.server
+	o.tls = next.tls
+	o.backends = next.backends
+	o.endpoints = next.endpoints
+	o.dialOpts = next.dialOpts
+	o.interceptors = next.interceptors
+	o.policies = next.policies
+	o.deadlines = next.deadlines
+	o.retry = next.retry
+	o.breaker = next.breaker
+	o.handlers = next.handlers
+	o.muxOpts = next.muxOpts
+	o.errors = next.errors
+	o.paths = next.paths
+	o.proxy = next.proxy
+	o.streams = next.streams
+	o.services = next.services
+	o.serverOpts = next.serverOpts
+	o.watched = next.watched
+	o.metas = next.metas
+	o.silent = next.silent
+	o.timeout = next.timeout
+	o.h2c = next.h2c
+	o.limits = next.limits
+	o.logs = next.logs
+	o.config = c
+}
+
+func (o *GatewayOption) reopenLog() {
+	if err := o.openLog(); err != nil {
+		o.err.Errorf("Failed to reopen log files: %v", err)
+	}
+}
+
+// watchConfig calls Reload when the configuration file or one of the watched files changes,
+// until the server is stopped. The files are those in effect when the server started.
+func (o *GatewayOption) watchConfig() error {
+	paths := o.watched
+
+	if o.configPath != "" {
+		paths = append([]string{o.configPath}, paths...)
+	}
+
+	o.mu.Lock()
+	done := o.done
+	o.mu.Unlock()
+
+	stop, err := watchFiles(paths, func() {
+		o.ops.Lock()
+		defer o.ops.Unlock()
+
+		// A change settling after Stop must not reload the stopped gateway, nor a later run of it
+		o.mu.Lock()
+		current := done != nil && o.done == done
+		o.mu.Unlock()
+
+		if current {
+			_ = o.reload()
+		}
+	}, func(err error) {
+		o.err.Errorf("Config file watcher error: %v", err)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	o.stop = stop
+	o.mu.Unlock()
+
+	return nil
+}
+
+// diffConfig lists the settings that differ between two configurations as "key: old -> new".
+func diffConfig(current, next *Config) []string {
+	left, right := flattenConfig(current), flattenConfig(next)
+	keys := make([]string, 0, len(left)+len(right))
+
+	for k := range left {
+		keys = append(keys, k)
+	}
+
+	for k := range right {
+		if _, ok := left[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	changes := make([]string, 0)
+
+	for _, k := range keys {
+		l, lok := left[k]
+		r, rok := right[k]
+
+		switch {
+		case !lok:
+			changes = append(changes, fmt.Sprintf("+ %s: %s", k, r))
+		case !rok:
+			changes = append(changes, fmt.Sprintf("- %s: %s", k, l))
+		case l != r:
+			changes = append(changes, fmt.Sprintf("~ %s: %s -> %s", k, l, r))
+		}
+	}
+
+	return changes
+}
+
+func formatDiff(changes []string) string {
+	if len(changes) == 0 {
+		return ""
+	}
+
+	return "\n\t" + strings.Join(changes, "\n\t")
+}
+
+func flattenConfig(c *Config) map[string]string {
+	values := make(map[string]string)
+
+	if c == nil {
+		return values
+	}
+
+	buf, err := json.Marshal(c)
+
+	if err != nil {
+		return values
+	}
+
+	var v interface{}
+
+	if err := json.Unmarshal(buf, &v); err != nil {
+		return values
+	}
+
+	flattenValue(values, "", v)
+
+	return values
+}
+
+func flattenValue(values map[string]string, key string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if key != "" {
+				k = key + "." + k
+			}
+
+			flattenValue(values, k, child)
+		}
+	case []interface{}:
+		for i, child := range v {
+			flattenValue(values, fmt.Sprintf("%s[%d]", key, i), child)
+		}
+	default:
+		buf, _ := json.Marshal(v)
+		values[key] = string(buf)
+	}
+}
@@ -0,0 +1,191 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func freePort(t *testing.T) uint {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func statusOf(t *testing.T, url string) int {
+	res, err := http.Get(url)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = res.Body.Close()
+
+	return res.StatusCode
+}
+
+func TestGatewayOption_Reload(t *testing.T) {
+	port := freePort(t)
+	base := fmt.Sprintf("http://127.0.0.1:%d", port)
+	config := "server:\n  host: 127.0.0.1\n  port: %d\nsilent: true\nhealth_check_path: %s\n"
+	path := writeConfig(t, "gateway.yaml", fmt.Sprintf(config, port, "/ping"))
+
+	server, err := NewGatewayFromConfig(path, WithShutdownTimeout(time.Second))
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go func() {
+		_ = server.Start()
+	}()
+
+	defer server.Stop()
+
+	assert.Eventually(t, func() bool { return server.running() }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, statusOf(t, base+"/ping"))
+
+	// A valid configuration is swapped in without rebinding the listener
+	assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(config, port, "/health")), 0600))
+	assert.NoError(t, server.Reload())
+	assert.Equal(t, http.StatusNotFound, statusOf(t, base+"/ping"))
+	assert.Equal(t, http.StatusOK, statusOf(t, base+"/health"))
+
+	// An invalid configuration is rejected and the running one is kept
+	assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(config+"handlers: [unknown]\n", port, "/ready")), 0600))
+	assert.Error(t, server.Reload())
+	assert.Equal(t, http.StatusOK, statusOf(t, base+"/health"))
+	assert.Equal(t, "/health", server.config.HealthCheckPath)
+}
+
+func TestGatewayOption_StopWithPendingReload(t *testing.T) {
+	port := freePort(t)
+	config := "server:\n  host: 127.0.0.1\n  port: %d\nsilent: true\nhealth_check_path: %s\n"
+	path := writeConfig(t, "gateway.yaml", fmt.Sprintf(config, port, "/ping"))
+
+	server, err := NewGatewayFromConfig(path, WithConfigWatch(true), WithShutdownTimeout(time.Second))
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- server.Start()
+	}()
+
+	assert.Eventually(t, func() bool { return server.running() }, time.Second, 10*time.Millisecond)
+
+	// The change is seen by the watcher, whose debounce is still pending when the server stops
+	assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(config, port, "/health")), 0600))
+	time.Sleep(30 * time.Millisecond)
+	server.Stop()
+	assert.NoError(t, <-result)
+
+	time.Sleep(300 * time.Millisecond)
+
+	server.ops.Lock()
+	defer server.ops.Unlock()
+
+	assert.Equal(t, "/ping", server.config.HealthCheckPath)
+}
+
+func TestGatewayOption_ReloadInFlight(t *testing.T) {
+	port := freePort(t)
+	base := fmt.Sprintf("http://127.0.0.1:%d", port)
+	config := "server:\n  host: 127.0.0.1\n  port: %d\nsilent: true\nmetadata:\n  - action: pass\n    type: request\n    keys: [%s]\n"
+	path := writeConfig(t, "gateway.yaml", fmt.Sprintf(config, port, "X-First"))
+
+	// The unknown routes reach the error handler of their generation after the reload adopted
+	// the next configuration
+	server, err := NewGatewayFromConfig(path,
+		WithShutdownTimeout(time.Second),
+		WithHandler(func(h http.Handler, _ *GatewayOption) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(20 * time.Millisecond)
+				h.ServeHTTP(w, r)
+			})
+		}),
+		WithErrorHandler(ErrorHandle(codes.NotFound, func(context.Context, *runtime.ServeMux, http.ResponseWriter, *http.Request, *status.Status) *ErrorResult {
+			return nil
+		})),
+	)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go func() {
+		_ = server.Start()
+	}()
+
+	defer server.Stop()
+
+	assert.Eventually(t, func() bool { return server.running() }, time.Second, 10*time.Millisecond)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				res, err := http.Get(base + "/missing")
+
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				_ = res.Body.Close()
+				assert.Equal(t, http.StatusNotFound, res.StatusCode)
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		// Let requests enter the current generation before it is replaced
+		time.Sleep(10 * time.Millisecond)
+
+		assert.NoError(t, os.WriteFile(path, []byte(fmt.Sprintf(config, port, fmt.Sprintf("X-Key-%d", i))), 0600))
+		assert.NoError(t, server.Reload())
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestDiffConfig(t *testing.T) {
+	current := &Config{Server: ServerConfig{Port: 8081}, Handlers: []string{"gzip"}}
+	next := &Config{Server: ServerConfig{Port: 9091}, Handlers: []string{"gzip", "brotli"}, StatusPath: "/status"}
+
+	assert.Equal(t, []string{
+		`+ handlers[1]: "brotli"`,
+		`~ server.port: 8081 -> 9091`,
+		`+ status_path: "/status"`,
+	}, diffConfig(current, next))
+}
@@ -0,0 +1,226 @@
+package runtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy retries the backend calls failing with one of the retryable codes, waiting a random
+// backoff between zero and the current backoff before each attempt. The backoff starts at
+// InitialBackoff and grows by BackoffMultiplier up to MaxBackoff. Zero values take the defaults
+// of DefaultRetryPolicy.
+//
+// Methods lists the gRPC methods the policy opts in, as full method names such as
+// "/helloworld.Greeter/SayHello" or all methods of a service with "/helloworld.Greeter/*".
+// Only idempotent methods should be listed. A policy names its methods explicitly, "*" opting in
+// every method; a policy without methods is rejected.
+type RetryPolicy struct {
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	RetryableCodes    []codes.Code
+	Methods           []string
+}
+
+// RetryBudget limits the share of retries. Every failed call costs one token out of MaxTokens and
+// every successful one gives TokenRatio back; retries stop while less than half of the tokens remain.
+// MaxTokens must be in (0, 1000] and TokenRatio above zero.
+type RetryBudget struct {
+	MaxTokens  float64
+	TokenRatio float64
+}
+
+// DefaultRetryPolicy is the policy whose values fill in the zero fields of a RetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:       3,
+	InitialBackoff:    100 * time.Millisecond,
+	MaxBackoff:        time.Second,
+	BackoffMultiplier: 2,
+	RetryableCodes:    []codes.Code{codes.Unavailable},
+}
+
+type retrySettings struct {
+	policies []RetryPolicy
+	budget   *RetryBudget
+}
+
+// WithRetryPolicy is a GatewayOptionFunc that retries the calls to every backend with the policies.
+// A policy set for the same method with BackendRetryPolicy takes precedence.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithRetryPolicy(RetryPolicy{
+//	        MaxAttempts: 3,
+//	        Methods:     []string{"/helloworld.Greeter/SayHello"},
+//	    }),
+//	    WithRetryBudget(RetryBudget{MaxTokens: 10, TokenRatio: 0.1}),
+//	)
+func WithRetryPolicy(policies ...RetryPolicy) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.retry.policies = append(opt.retry.policies, policies...)
+	}
+}
+
+// WithRetryBudget is a GatewayOptionFunc that limits the retries to every backend with the budget.
+func WithRetryBudget(budget RetryBudget) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.retry.budget = &budget
+	}
+}
+
+// BackendRetryPolicy retries the calls to the backend with the policies.
+func BackendRetryPolicy(policies ...RetryPolicy) BackendOption {
+	return func(b *Backend) {
+		b.retry.policies = append(b.retry.policies, policies...)
+	}
+}
+
+// BackendRetryBudget limits the retries to the backend with the budget.
+func BackendRetryBudget(budget RetryBudget) BackendOption {
+	return func(b *Backend) {
+		b.retry.budget = &budget
+	}
+}
+
+// merge returns the settings of r overridden by those of the backend.
+func (r retrySettings) merge(backend retrySettings) retrySettings {
+	merged := retrySettings{
+		policies: append(r.policies[:len(r.policies):len(r.policies)], backend.policies...),
+		budget:   r.budget,
+	}
+
+	if backend.budget != nil {
+		merged.budget = backend.budget
+	}
+
+	return merged
+}
+
+// methodConfigs converts the policies into the methodConfig entries of a gRPC service config.
+// A method named by several policies takes the last one.
+func (r retrySettings) methodConfigs() ([]json.RawMessage, error) {
+	policies := make(map[string]RetryPolicy)
+
+	for _, policy := range r.policies {
+		if len(policy.Methods) == 0 {
+			return nil, errors.New("retry policy lists no methods; name the idempotent methods to retry, or \"*\" for every method")
+		}
+
+		for _, method := range policy.Methods {
+			policies[method] = policy
+		}
+	}
+
+	names := make([]string, 0, len(policies))
+
+	for name := range policies {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	configs := make([]json.RawMessage, 0, len(names))
+
+	for _, name := range names {
+		config, err := policies[name].methodConfig(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+// validate checks b against the limits of the retryThrottling of a gRPC service config.
+func (b RetryBudget) validate() error {
+	if b.MaxTokens <= 0 || b.MaxTokens > 1000 {
+		return fmt.Errorf("retry budget: max tokens must be in (0, 1000], got %v", b.MaxTokens)
+	}
+
+	if b.TokenRatio <= 0 {
+		return fmt.Errorf("retry budget: token ratio must be above 0, got %v", b.TokenRatio)
+	}
+
+	return nil
+}
+
+func (p RetryPolicy) methodConfig(method string) (json.RawMessage, error) {
+	name, err := methodName(method)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d := DefaultRetryPolicy
+
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = d.MaxAttempts
+	}
+
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = d.InitialBackoff
+	}
+
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = d.MaxBackoff
+	}
+
+	if p.BackoffMultiplier == 0 {
+		p.BackoffMultiplier = d.BackoffMultiplier
+	}
+
+	if len(p.RetryableCodes) == 0 {
+		p.RetryableCodes = d.RetryableCodes
+	}
+
+	if p.MaxAttempts < 2 {
+		return nil, fmt.Errorf("retry policy for %s: max attempts must be at least 2", method)
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"name": []map[string]string{name},
+		"retryPolicy": map[string]interface{}{
+			"maxAttempts":          p.MaxAttempts,
+			"initialBackoff":       durationJSON(p.InitialBackoff),
+			"maxBackoff":           durationJSON(p.MaxBackoff),
+			"backoffMultiplier":    p.BackoffMultiplier,
+			"retryableStatusCodes": p.RetryableCodes,
+		},
+	})
+}
+
+// methodName converts a full method name into the name of a service config methodConfig.
+func methodName(method string) (map[string]string, error) {
+	if method == "*" || method == "" {
+		return map[string]string{}, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(method, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return map[string]string{"service": parts[0]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] == "*":
+		return map[string]string{"service": parts[0]}, nil
+	case len(parts) == 2 && parts[0] != "" && parts[1] != "":
+		return map[string]string{"service": parts[0], "method": parts[1]}, nil
+	}
+
+	return nil, fmt.Errorf("invalid method name %q", method)
+}
+
+// durationJSON formats d as the decimal seconds of the protobuf Duration JSON form.
+func durationJSON(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
@@ -0,0 +1,125 @@
+package runtime
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startFlakyServer serves the gRPC health service, failing the first calls with Unavailable.
+func startFlakyServer(t *testing.T, failures int32, calls *int32) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if atomic.AddInt32(calls, 1) <= failures {
+			return nil, status.Error(codes.Unavailable, "restarting")
+		}
+
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+
+	go func() {
+		_ = server.Serve(listener)
+	}()
+
+	t.Cleanup(server.Stop)
+
+	return listener.Addr().String()
+}
+
+func TestWithRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   []GatewayOptionFunc
+		calls  int32
+		expect codes.Code
+	}{
+		{"no policy", nil, 1, codes.Unavailable},
+		{"all methods", []GatewayOptionFunc{WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond, Methods: []string{"*"}})}, 2, codes.OK},
+		{"opted in", []GatewayOptionFunc{WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond, Methods: []string{"/grpc.health.v1.Health/Check"}})}, 2, codes.OK},
+		{"other method", []GatewayOptionFunc{WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond, Methods: []string{"/grpc.health.v1.Health/Watch"}})}, 1, codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+
+			addr := startFlakyServer(t, 1, &calls)
+			server := newGatewayOption(tt.opts...)
+
+			opts, err := (&Backend{name: "health"}).dialOptions(server.retry, server.clientOptions()...)
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			conn, err := grpc.NewClient(addr, opts...)
+
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			defer conn.Close()
+
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+
+			assert.Equal(t, tt.expect, status.Code(err))
+			assert.Equal(t, tt.calls, atomic.LoadInt32(&calls))
+		})
+	}
+}
+
+func TestRetrySettings_ServiceConfig(t *testing.T) {
+	global := retrySettings{
+		policies: []RetryPolicy{{Methods: []string{"/users.UserService/*"}}},
+		budget:   &RetryBudget{MaxTokens: 10, TokenRatio: 0.1},
+	}
+	backend := retrySettings{
+		policies: []RetryPolicy{{MaxAttempts: 5, Methods: []string{"/users.UserService/*", "/users.UserService/GetUser"}}},
+	}
+
+	config, err := (&Backend{}).serviceConfig(global.merge(backend))
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"methodConfig": [
+			{"name": [{"service": "users.UserService"}], "retryPolicy": {"maxAttempts": 5, "initialBackoff": "0.1s", "maxBackoff": "1s", "backoffMultiplier": 2, "retryableStatusCodes": [14]}},
+			{"name": [{"service": "users.UserService", "method": "GetUser"}], "retryPolicy": {"maxAttempts": 5, "initialBackoff": "0.1s", "maxBackoff": "1s", "backoffMultiplier": 2, "retryableStatusCodes": [14]}}
+		],
+		"retryThrottling": {"maxTokens": 10, "tokenRatio": 0.1}
+	}`, config)
+
+	_, err = (&Backend{}).serviceConfig(retrySettings{policies: []RetryPolicy{{MaxAttempts: 1, Methods: []string{"*"}}}})
+	assert.ErrorContains(t, err, "max attempts must be at least 2")
+
+	_, err = (&Backend{}).serviceConfig(retrySettings{policies: []RetryPolicy{{Methods: []string{"/a/b/c"}}}})
+	assert.ErrorContains(t, err, `invalid method name "/a/b/c"`)
+
+	// Policies opt methods in explicitly
+	_, err = (&Backend{}).serviceConfig(retrySettings{policies: []RetryPolicy{{MaxAttempts: 3}}})
+	assert.ErrorContains(t, err, "retry policy lists no methods")
+
+	for _, budget := range []RetryBudget{{MaxTokens: 0, TokenRatio: 0.1}, {MaxTokens: 1001, TokenRatio: 0.1}, {MaxTokens: 10, TokenRatio: 0}} {
+		_, err = (&Backend{}).serviceConfig(retrySettings{budget: &budget})
+		assert.ErrorContains(t, err, "retry budget")
+
+		_, err = (&RetryConfig{Budget: &RetryBudgetConfig{MaxTokens: budget.MaxTokens, TokenRatio: budget.TokenRatio}}).settings()
+		assert.ErrorContains(t, err, "retry budget")
+	}
+
+	_, err = (&RetryConfig{Policies: []RetryPolicyConfig{{MaxAttempts: 3}}}).settings()
+	assert.ErrorContains(t, err, "retry policies need methods")
+}
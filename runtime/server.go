@@ -2,19 +2,25 @@ package runtime
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/gorilla/handlers"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/credentials"
-	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type GatewayHandler func(h http.Handler, o *GatewayOption) http.Handler
@@ -43,8 +49,11 @@ type ServerInfo struct {
 }
 
 type ServerTLS struct {
-	cert string
-	key  string
+	cert       string
+	key        string
+	certs      []certPair
+	clientCA   []string
+	clientAuth *tls.ClientAuthType
 }
 
 func (s ServerInfo) Valid() error {
@@ -70,18 +79,62 @@ func (s ServerInfo) ToString() string {
 type GatewayOption struct {
 	server    ServerInfo
 	tls       *ServerTLS
-	backend   ServerInfo
-	endpoints []GatewayEndpoint
+	backends  map[string]*Backend
+	endpoints []endpointBinding
+	dialOpts  []grpc.DialOption
 	handlers  []GatewayHandler
 	muxOpts   []runtime.ServeMuxOption
 	errors    map[codes.Code]ErrorHandleCallback
 	paths     []PathHandler
+	proxy     *rpcProxy
+	streams   *streamFormats
+	services  []localService
 	metas     metadataInfo
 	silent    bool
-	ctx       *context.Context
+	timeout   time.Duration
+	h2c       bool
+	limits    ServerLimits
 	logs      LogInfo
 	log       *logrus.Logger
 	err       *logrus.Logger
+
+	interceptors []ClientInterceptor
+	policies     []AuthorizationPolicy
+	deadlines    deadlineRules
+	retry        retrySettings
+	breaker      *BreakerPolicy
+	serverOpts   []grpc.ServerOption
+
+	// configuration file state, see NewGatewayFromConfig
+	config     *Config
+	configPath string
+	configOpts []GatewayOptionFunc
+	watch      bool
+	// watched are further files whose change triggers Reload, e.g. descriptor sets
+	watched []string
+
+	// running state, guarded by mu
+	mu    sync.Mutex
+	ops   sync.Mutex
+	httpd *http.Server
+	gen   atomic.Pointer[generation]
+	done  chan struct{}
+	fail  chan error
+	stop  func()
+	files []*os.File
+	state *gatewayState
+}
+
+// gatewayState is the runtime state shared by a gateway and the generations Reload builds for it.
+type gatewayState struct {
+	certs    atomic.Pointer[certStore]
+	breakers breakerRegistry
+	// reload is the Reload of the gateway, for endpoints that refresh their routes
+	reload func() error
+	// reflected holds the last reflectedServices of every reflecting endpoint, by reflectionKey
+	reflected sync.Map
+	// trustWarning logs once that the default backend trusts the listener certificate
+	trustWarning sync.Once
 }
 
 type GatewayOptionFunc func(*GatewayOption)
@@ -92,28 +145,49 @@ type GatewayServer interface {
 	Restart() error
 }
 
+// ReloadableServer is a GatewayServer that applies its configuration again while serving.
+// It is separate from GatewayServer, so that the implementations of GatewayServer outside this
+// package keep satisfying it; *GatewayOption implements both.
+type ReloadableServer interface {
+	GatewayServer
+	Reload() error
+}
+
 func NewGateway(opts ...GatewayOptionFunc) (*GatewayOption, error) {
+	o := newGatewayOption(opts...)
+
+	if err := o.initLog(); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+// newGatewayOption applies opts over the defaults without opening the log outputs.
+func newGatewayOption(opts ...GatewayOptionFunc) *GatewayOption {
 	o := &GatewayOption{
-		server:    ServerInfo{"0.0.0.0", 8081},
-		backend:   ServerInfo{"127.0.0.1", 8080},
-		endpoints: []GatewayEndpoint{},
+		server: ServerInfo{"0.0.0.0", 8081},
+		backends: map[string]*Backend{
+			DefaultBackend: {name: DefaultBackend, server: ServerInfo{"127.0.0.1", 8080}},
+		},
+		endpoints: []endpointBinding{},
 		handlers:  []GatewayHandler{},
 		muxOpts:   []runtime.ServeMuxOption{},
 		paths:     []PathHandler{},
 		errors:    make(map[codes.Code]ErrorHandleCallback),
 		silent:    false,
+		timeout:   15 * time.Second,
 		logs:      LogInfo{},
+		state:     &gatewayState{},
 	}
 
+	o.state.reload = o.Reload
+
 	for _, opt := range opts {
 		opt(o)
 	}
 
-	if err := o.initLog(); err != nil {
-		return nil, err
-	}
-
-	return o, nil
+	return o
 }
 
 // WithServer is a GatewayOptionFunc that sets the host and port for the server.
@@ -137,9 +211,74 @@ func WithServer(host string, port uint) GatewayOptionFunc {
 // It takes the paths of the certificate and key files as parameters.
 // When used as an argument for GatewayServer.WithOptions or GatewayServer.WithDefaultOptions,
 // it sets the TLS configuration for the server to enable secure communication.
-func WithTLS(cert, key string) GatewayOptionFunc {
+// The ServerTLSOption values enable mutual TLS; the identity of a verified client certificate
+// is then forwarded to the backend as ClientCertSubjectMeta, ClientCertSANMeta and
+// ClientCertFingerprintMeta metadata.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithTLS("server.crt", "server.key",
+//	        ClientCA("partners-ca.pem"),
+//	        ClientAuth(tls.RequireAndVerifyClientCert),
+//	    ),
+//	)
+func WithTLS(cert, key string, opts ...ServerTLSOption) GatewayOptionFunc {
 	return func(opt *GatewayOption) {
-		opt.tls = &ServerTLS{cert, key}
+		t := &ServerTLS{cert: cert, key: key}
+
+		for _, o := range opts {
+			o(t)
+		}
+
+		opt.tls = t
+	}
+}
+
+// WithShutdownTimeout is a GatewayOptionFunc that sets how long Stop and Restart wait for
+// in-flight requests to drain before the listener is forcibly closed.
+func WithShutdownTimeout(timeout time.Duration) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.timeout = timeout
+	}
+}
+
+// WithH2C is a GatewayOptionFunc that makes a plaintext listener speak cleartext HTTP/2 next to
+// HTTP/1.1, both with prior knowledge and through the Upgrade header, for load balancers and
+// native gRPC clients connecting without TLS. A listener with WithTLS negotiates HTTP/2 anyway.
+func WithH2C(enabled bool) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.h2c = enabled
+	}
+}
+
+// ServerLimits are the limits of the HTTP server of the gateway. Zero values keep the defaults of
+// net/http, and of HTTP/2 for MaxConcurrentStreams, the streams a client may open on a connection.
+// The WriteTimeout also ends streaming responses, so leave it zero when serving streams.
+type ServerLimits struct {
+	ReadTimeout          time.Duration
+	ReadHeaderTimeout    time.Duration
+	WriteTimeout         time.Duration
+	IdleTimeout          time.Duration
+	MaxHeaderBytes       int
+	MaxConcurrentStreams uint32
+}
+
+// WithServerLimits is a GatewayOptionFunc that sets the timeouts and size limits of the server.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithH2C(true),
+//	    WithServerLimits(ServerLimits{
+//	        ReadHeaderTimeout:    5 * time.Second,
+//	        IdleTimeout:          2 * time.Minute,
+//	        MaxConcurrentStreams: 250,
+//	    }),
+//	)
+func WithServerLimits(limits ServerLimits) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.limits = limits
 	}
 }
 
@@ -155,7 +294,7 @@ func WithSilent(silent bool) GatewayOptionFunc {
 // it sets the backend configuration to connect to the specified host and port.
 func WithBackend(host string, port uint) GatewayOptionFunc {
 	return func(opt *GatewayOption) {
-		opt.backend = ServerInfo{host, port}
+		opt.backendOf(DefaultBackend).server = ServerInfo{host, port}
 	}
 }
 
@@ -164,10 +303,9 @@ func WithBackend(host string, port uint) GatewayOptionFunc {
 // and appends them to the existing endpoints in the GatewayOption struct. When used as
 // an argument for GatewayServer.WithOptions or GatewayServer.WithDefaultOptions, it adds
 // the provided endpoints to the server configuration.
+// The endpoints are registered against the default backend set by WithBackend.
 func WithEndpoint(endpoints ...GatewayEndpoint) GatewayOptionFunc {
-	return func(opt *GatewayOption) {
-		opt.endpoints = append(opt.endpoints, endpoints...)
-	}
+	return WithBackendEndpoint(DefaultBackend, endpoints...)
 }
 
 func WithPathHandle(method string, path string, handler runtime.HandlerFunc) GatewayOptionFunc {
@@ -230,25 +368,76 @@ func (o *GatewayOption) attachHandler(mux http.Handler) http.Handler {
 	return mux
 }
 
-func (o *GatewayOption) attachEndpoint(ctx context.Context, mux *runtime.ServeMux, opts []grpc.DialOption) error {
-	host, err := o.backend.ValidToString()
-
-	if err != nil {
-		return err
+func (o *GatewayOption) attachEndpoint(ctx context.Context, mux *runtime.ServeMux, local *localServer) error {
+	type target struct {
+		host string
+		opts []grpc.DialOption
 	}
 
-	if o.endpoints != nil {
+	targets := make(map[string]target)
+	shared := o.clientOptions()
+
+	for _, binding := range o.endpoints {
+		t, ok := targets[binding.backend]
 
-		for _, endpoint := range o.endpoints {
-			if err := endpoint(ctx, mux, host, opts); err != nil {
+		if !ok {
+			host, opts, err := o.backendTarget(binding.backend, shared, local)
+
+			if err != nil {
 				return err
 			}
+
+			t = target{host, opts}
+			targets[binding.backend] = t
+		}
+
+		if err := binding.endpoint(ctx, mux, t.host, t.opts); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// backendTarget returns the dial target and options of the backend name, with the shared options
+// of every backend. InProcessBackend is reached through local.
+func (o *GatewayOption) backendTarget(name string, shared []grpc.DialOption, local *localServer) (string, []grpc.DialOption, error) {
+	if name == InProcessBackend {
+		if local == nil {
+			return "", nil, errors.New("no services are registered in process")
+		}
+
+		return "passthrough:///" + InProcessBackend, append(local.dialOptions(), shared...), nil
+	}
+
+	backend, found := o.backends[name]
+
+	if !found {
+		return "", nil, fmt.Errorf("unknown backend %q", name)
+	}
+
+	host, err := backend.target()
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	if security := o.backendTLS(backend); security != backend.tls {
+		dialed := *backend
+		dialed.tls = security
+		backend = &dialed
+	}
+
+	// The circuit breaker comes first, so that it sees the result of all the retries
+	opts, err := backend.dialOptions(o.retry, append(o.breakerOptions(backend), shared...)...)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return host, opts, nil
+}
+
 func (o *GatewayOption) attachPathHandle(mux *runtime.ServeMux) error {
 	if o.paths != nil {
 		for _, path := range o.paths {
@@ -273,6 +462,84 @@ func ndCibtext(ctx context.Context, req *http.Request) metadata.MD {
 	return md
 }
 
+// build creates a new generation: a fresh mux with the path handlers and the endpoints
+// registered against their backends, wrapped in the handler chain. The generation is built from
+// a snapshot of o, so that a reload can change o while its requests drain.
+func (o *GatewayOption) build() (*generation, error) {
+	return o.snapshot().assemble()
+}
+
+func (o *GatewayOption) assemble() (*generation, error) {
+	// The endpoint registrations close their backend connections once ctx is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Register gRPC server backend
+	// Note: Make sure the gRPC server is running properly and accessible
+	// Every mux answers errors through errorCapture, so that the ErrorHandleCallback values apply
+	muxOpts := append([]runtime.ServeMuxOption{errorCapture(o), runtime.WithMetadata(identityMetadata)}, o.muxOpts...)
+
+	if o.tls.verifiesClients() {
+		muxOpts = append(muxOpts, runtime.WithMetadata(clientIdentityMetadata))
+	}
+
+	if o.streams != nil {
+		muxOpts = append(muxOpts, o.streams.muxOptions()...)
+	}
+
+	mux := runtime.NewServeMux(muxOpts...)
+	local := o.startLocal(ctx)
+
+	if err := o.attachPathHandle(mux); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	var handler http.Handler = mux
+
+	if o.streams != nil {
+		handler = o.streams.handler(handler)
+	}
+
+	// gRPC-Web and Connect calls are told apart from the REST routes in front of the mux
+	if o.proxy != nil {
+		proxy, err := o.attachProxy(ctx, handler, mux, local)
+
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		handler = proxy
+	}
+
+	// Native gRPC calls of the in-process services pass the handler chain in front of them
+	if local != nil {
+		handler = local.handler(handler)
+	}
+
+	if o.deadlines.enabled() {
+		handler = o.deadlines.handler(handler)
+	}
+
+	handler = o.attachHandler(handler)
+
+	if o.tls.verifiesClients() {
+		handler = clientIdentityHandler(handler)
+	}
+
+	handler = withServeMux(handler, mux)
+
+	if err := o.attachEndpoint(ctx, mux, local); err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &generation{handler: handler, cancel: cancel}, nil
+}
+
+// run builds a generation, binds the listener and serves it in the background.
+// Requests are dispatched to the current generation, which Reload can swap while serving.
+// Serve failures are reported on o.fail.
 func (o *GatewayOption) run() error {
 	tls := o.tls
 
@@ -282,85 +549,269 @@ func (o *GatewayOption) run() error {
 		return err
 	}
 
-	ctx := context.Background()
+	gen, err := o.build()
 
-	o.ctx = &ctx
-
-	// Register gRPC server backend
-	// Note: Make sure the gRPC server is running properly and accessible
-	mux := runtime.NewServeMux(o.muxOpts...)
-
-	if err := o.attachPathHandle(mux); err != nil {
+	if err != nil {
 		return err
 	}
 
-	handler := o.attachHandler(mux)
+	server := &http.Server{
+		Addr:              host,
+		Handler:           http.HandlerFunc(o.serveHTTP),
+		ReadTimeout:       o.limits.ReadTimeout,
+		ReadHeaderTimeout: o.limits.ReadHeaderTimeout,
+		WriteTimeout:      o.limits.WriteTimeout,
+		IdleTimeout:       o.limits.IdleTimeout,
+		MaxHeaderBytes:    o.limits.MaxHeaderBytes,
+	}
 
-	var credential credentials.TransportCredentials
+	var certs *certStore
 
 	if tls != nil {
-		if c, err := credentials.NewClientTLSFromFile(tls.cert, ""); err != nil {
+		if certs, err = newCertStore(tls); err != nil {
+			gen.cancel()
+			return err
+		}
+
+		if server.TLSConfig, err = tls.config(certs); err != nil {
+			gen.cancel()
 			return err
-		} else {
-			credential = c
 		}
-	} else {
-		credential = insecure.NewCredentials()
 	}
 
-	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(credential),
+	if err := o.configureHTTP2(server); err != nil {
+		gen.cancel()
+		return err
 	}
 
-	if err := o.attachEndpoint(ctx, mux, opts); err != nil {
+	listener, err := net.Listen("tcp", host)
+
+	if err != nil {
+		gen.cancel()
 		return err
 	}
 
-	if tls != nil {
-		return http.ListenAndServeTLS(host, tls.cert, tls.key, handler)
+	if certs != nil {
+		if err := certs.watch(o.err); err != nil {
+			o.err.Errorf("Failed to watch server certificate files: %v", err)
+		}
+
+		o.state.certs.Store(certs)
+	}
+
+	for _, name := range o.plaintextBackends() {
+		o.err.Warnf("Backend %s is dialed in plaintext; use WithBackendTLS, or WithBackendInsecure when intended", name)
+	}
+
+	if b := o.backends[DefaultBackend]; b != nil && o.trustsListener(b) {
+		o.state.trustWarning.Do(func() {
+			o.err.Warnf("Backend %s is dialed over TLS trusting the listener certificate %s; use WithBackendTLS with the CA of the backend", DefaultBackend, o.tls.cert)
+		})
+	}
+
+	o.gen.Store(gen)
+
+	o.mu.Lock()
+	o.httpd = server
+	fail := o.fail
+	o.mu.Unlock()
+
+	go func() {
+		var err error
+
+		if tls != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			select {
+			case fail <- err:
+			default:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// configureHTTP2 applies the HTTP/2 limits to server and, with WithH2C on a plaintext listener,
+// serves cleartext HTTP/2. The HTTP/2 connections take the idle timeout of server.
+func (o *GatewayOption) configureHTTP2(server *http.Server) error {
+	cleartext := o.h2c && o.tls == nil
+
+	if !cleartext && (o.tls == nil || o.limits.MaxConcurrentStreams == 0) {
+		return nil
 	}
 
-	return http.ListenAndServe(host, handler)
+	h2 := &http2.Server{MaxConcurrentStreams: o.limits.MaxConcurrentStreams}
+
+	// Registering the server also lets Shutdown close the hijacked h2c connections gracefully
+	if err := http2.ConfigureServer(server, h2); err != nil {
+		return err
+	}
+
+	if cleartext {
+		server.Handler = h2c.NewHandler(server.Handler, h2)
+	}
+
+	return nil
 }
 
+// terminate gracefully shuts down the running listener, waiting up to o.timeout for in-flight
+// requests to complete, and then closes the backend connections opened by the endpoints.
 func (o *GatewayOption) terminate() bool {
-	if o.ctx != nil {
-		_, cancel := context.WithCancel(*o.ctx)
-		cancel()
+	o.mu.Lock()
+	server := o.httpd
+	o.httpd = nil
+	o.mu.Unlock()
+
+	if server == nil {
+		return false
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), o.timeout)
+	defer stop()
+
+	if err := server.Shutdown(ctx); err != nil {
+		o.err.Errorf("Gateway server did not drain within %s: %v", o.timeout, err)
+
+		if err := server.Close(); err != nil {
+			o.err.Errorf("Failed to close gateway server: %v", err)
+		}
+	}
 
-		o.ctx = nil
+	if gen := o.gen.Swap(nil); gen != nil {
+		gen.retire(0)
+	}
 
-		return true
+	if certs := o.state.certs.Swap(nil); certs != nil {
+		certs.close()
 	}
 
-	return false
+	return true
 }
 
-// Start starts the server by creating a new context with cancel function and setting it to o.ctx.
-// It registers the gRPC server backend, attaches the endpoints, and starts the server using http.ListenAndServe or http.ListenAndServeTLS.
-// It returns an error if any operation fails.
+// Start starts the server by building the mux, registering the endpoints against the backend and
+// serving them on the configured host with http.Server, using TLS when WithTLS is set.
+// It blocks until the server is stopped with Stop, returning nil, or until serving fails.
 func (o *GatewayOption) Start() error {
-	o.err.Infof("Gateway server starting on %s -> %s", o.server.ToString(), o.backend.ToString())
+	// ops is held until the listener is registered, so that a concurrent Stop either precedes
+	// Start or shuts the new listener down
+	o.ops.Lock()
+	o.err.Infof("Gateway server starting on %s -> %s", o.server.ToString(), o.backendAddrs())
+	o.mu.Lock()
+
+	if o.done != nil {
+		o.mu.Unlock()
+		o.ops.Unlock()
+		return errors.New("gateway server is already running")
+	}
 
-	return o.run()
+	done := make(chan struct{})
+	fail := make(chan error, 1)
+	o.done, o.fail = done, fail
+	o.mu.Unlock()
+
+	if err := o.run(); err != nil {
+		o.release()
+		o.ops.Unlock()
+		return err
+	}
+
+	if o.watch && (o.configPath != "" || len(o.watched) > 0) {
+		if err := o.watchConfig(); err != nil {
+			o.err.Errorf("Failed to watch config files: %v", err)
+		}
+	}
+
+	o.ops.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case err := <-fail:
+		o.terminate()
+		o.release()
+		return err
+	}
+}
+
+// release marks the server as no longer running and unblocks Start.
+func (o *GatewayOption) release() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.stop != nil {
+		o.stop()
+		o.stop = nil
+	}
+
+	if o.done != nil {
+		close(o.done)
+		o.done, o.fail = nil, nil
+	}
 }
 
-// Stop stops the server by canceling the context and setting it to nil.
+// Stop gracefully shuts down the listener, draining in-flight requests for up to the shutdown
+// timeout, closes the backend connections and unblocks Start.
 // It returns the GatewayServer instance for method chaining.
 func (o *GatewayOption) Stop() GatewayServer {
+	o.ops.Lock()
+	defer o.ops.Unlock()
+
 	if ok := o.terminate(); ok {
-		o.err.Infof("Gateway server stopping on %s -> %s", o.server.ToString(), o.backend.ToString())
+		o.err.Infof("Gateway server stopping on %s -> %s", o.server.ToString(), o.backendAddrs())
 	} else {
 		o.err.Infof("Gateway is stooped")
 	}
 
+	o.release()
+
 	return o
 }
 
-// Restart restarts the server by first stopping it using the Stop method and then starting it using the Start method.
+// Restart gracefully shuts down the running listener and backend connections, then rebuilds
+// the mux and binds the listener again. Start keeps blocking across restarts.
 func (o *GatewayOption) Restart() error {
-	o.err.Infof("Gateway server restarting on %s -> %s", o.server.ToString(), o.backend.ToString())
+	o.ops.Lock()
+	defer o.ops.Unlock()
+
+	return o.restart()
+}
+
+func (o *GatewayOption) restart() error {
+	o.err.Infof("Gateway server restarting on %s -> %s", o.server.ToString(), o.backendAddrs())
+
+	o.mu.Lock()
+	running := o.done != nil
+	o.mu.Unlock()
+
+	if !running {
+		return errors.New("gateway server is not running")
+	}
+
 	o.terminate()
 
-	return o.run()
+	if err := o.run(); err != nil {
+		o.abort(err)
+		return err
+	}
+
+	return nil
+}
+
+// abort makes a blocking Start return err, used when the server could not be brought back up.
+func (o *GatewayOption) abort(err error) {
+	o.mu.Lock()
+	fail := o.fail
+	o.mu.Unlock()
+
+	if fail != nil {
+		select {
+		case fail <- err:
+		default:
+		}
+	}
 }
@@ -1,9 +1,21 @@
 package runtime
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"io"
+	"net"
+	"net/http"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestWithServer(t *testing.T) {
@@ -11,38 +23,38 @@ func TestWithServer(t *testing.T) {
 		name string
 		host string
 		port uint
-		want GatewayOptionFunc
+		want ServerInfo
 	}{
 		{
 			"Empty host & zero port",
 			"",
 			0,
-			func(opt *GatewayOption) { opt.server = ServerInfo{"", 0} },
+			ServerInfo{"", 0},
 		},
 		{
 			"Non-empty host & zero port",
 			"example.com",
 			0,
-			func(opt *GatewayOption) { opt.server = ServerInfo{"example.com", 0} },
+			ServerInfo{"example.com", 0},
 		},
 		{
 			"Empty host & non-zero port",
 			"",
 			8080,
-			func(opt *GatewayOption) { opt.server = ServerInfo{"", 8080} },
+			ServerInfo{"", 8080},
 		},
 		{
 			"Non-empty host & non-zero port",
 			"example.com",
 			8080,
-			func(opt *GatewayOption) { opt.server = ServerInfo{"example.com", 8080} },
+			ServerInfo{"example.com", 8080},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := WithServer(tt.host, tt.port); !reflect.DeepEqual(reflect.ValueOf(got).Pointer(), reflect.ValueOf(tt.want).Pointer()) {
-				t.Errorf("WithServer() = %v, want %v", reflect.ValueOf(got).Pointer(), reflect.ValueOf(tt.want).Pointer())
+			if got := newGatewayOption(WithServer(tt.host, tt.port)).server; got != tt.want {
+				t.Errorf("WithServer() = %+v, want %+v", got, tt.want)
 			}
 		})
 	}
@@ -64,12 +76,163 @@ func TestServerInfo_Valid(t *testing.T) {
 }
 
 func TestWithHandler(t *testing.T) {
-	server := NewGateway(
+	server, err := NewGateway(
 		WithServer("0.0.0.0", 8080),
 		WithHandler(GzipCompressHandler),
 		WithHandler(BrotliCompressHandler),
 	)
 
-	assert.Contains(t, server.handlers, GzipCompressHandler)
-	assert.Contains(t, server.handlers, BrotliCompressHandler)
+	assert.NoError(t, err)
+
+	// Functions are not comparable, so the handlers are told apart by their code pointers
+	pointers := make([]uintptr, 0, len(server.handlers))
+
+	for _, h := range server.handlers {
+		pointers = append(pointers, reflect.ValueOf(h).Pointer())
+	}
+
+	assert.Equal(t, []uintptr{
+		reflect.ValueOf(GzipCompressHandler).Pointer(),
+		reflect.ValueOf(BrotliCompressHandler).Pointer(),
+	}, pointers)
+}
+
+func TestGatewayOption_StartStop(t *testing.T) {
+	server, err := NewGateway(
+		WithServer("127.0.0.1", 0),
+		WithSilent(true),
+		WithShutdownTimeout(time.Second),
+	)
+
+	assert.NoError(t, err)
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- server.Start()
+	}()
+
+	assert.Eventually(t, func() bool {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return server.httpd != nil
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, server.Restart())
+
+	server.Stop()
+
+	select {
+	case err := <-result:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Start did not return after Stop")
+	}
+
+	assert.Error(t, server.Restart())
+}
+
+func TestGatewayOption_StopWhileStarting(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		port := freePort(t)
+
+		server, err := NewGateway(
+			WithServer("127.0.0.1", port),
+			WithSilent(true),
+			WithShutdownTimeout(time.Second),
+		)
+
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		result := make(chan error, 1)
+
+		go func() {
+			result <- server.Start()
+		}()
+
+		// A Stop preceding Start does nothing, so Stop is repeated until Start returns
+		func() {
+			timeout := time.After(2 * time.Second)
+
+			for {
+				server.Stop()
+
+				select {
+				case err := <-result:
+					assert.NoError(t, err)
+					return
+				case <-time.After(time.Millisecond):
+				case <-timeout:
+					t.Fatal("Start did not return after Stop")
+				}
+			}
+		}()
+
+		// The listener bound by a Start racing Stop must not outlive it
+		if conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port)); err == nil {
+			_ = conn.Close()
+			t.Fatalf("listener on port %d still open after Start returned", port)
+		}
+	}
+}
+
+func TestWithH2C(t *testing.T) {
+	port := freePort(t)
+	base := fmt.Sprintf("http://127.0.0.1:%d", port)
+
+	server, err := NewGateway(
+		WithServer("127.0.0.1", port),
+		WithSilent(true),
+		WithH2C(true),
+		WithServerLimits(ServerLimits{ReadHeaderTimeout: time.Second, MaxConcurrentStreams: 10}),
+		WithService(&grpc_health_v1.Health_ServiceDesc, health.NewServer()),
+		WithPathHandle("GET", "/proto", func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			_, _ = w.Write([]byte(r.Proto))
+		}),
+	)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go func() {
+		_ = server.Start()
+	}()
+
+	defer server.Stop()
+
+	assert.Eventually(t, func() bool { return server.running() }, time.Second, 10*time.Millisecond)
+
+	get := func(client *http.Client) string {
+		res, err := client.Get(base + "/proto")
+
+		if !assert.NoError(t, err) {
+			return ""
+		}
+
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+
+		return string(body)
+	}
+
+	// HTTP/1.1 and HTTP/2 with prior knowledge share the listener
+	assert.Equal(t, "HTTP/1.1", get(http.DefaultClient))
+	assert.Equal(t, "HTTP/2.0", get(&http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}))
+
+	// Native gRPC clients connect without TLS
+	conn, err := grpc.NewClient(fmt.Sprintf("127.0.0.1:%d", port), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	check, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check.GetStatus())
 }
@@ -0,0 +1,303 @@
+package runtime
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// InProcessBackend is the name of the backend serving the services registered with WithService.
+// It cannot be configured with WithNamedBackend.
+const InProcessBackend = "in-process"
+
+type localService struct {
+	desc *grpc.ServiceDesc
+	impl interface{}
+}
+
+// WithService is a GatewayOptionFunc that serves a service implementation in process, so that a
+// small service needs neither a second process nor a second port. The endpoints are registered
+// against InProcessBackend, whose calls reach the implementation over an in-memory connection
+// without a network hop, keeping the interceptors and metadata handling of a remote backend.
+//
+// Native gRPC clients reach the in-process services on the listener of the gateway next to the
+// REST routes. They need HTTP/2, which the listener offers with WithTLS or WithH2C. Their calls
+// pass the handler chain like the REST routes, so that authentication, rate limits and deadlines
+// apply, and the metadata of the authenticated identity is added to them. The method rules of
+// WithAuthorization and the timeouts of WithMethodTimeout are enforced by the in-process server
+// for them; the interceptors of WithClientInterceptors only run for the REST routes, so use
+// WithGRPCServerOptions to intercept both. Only the in-process services are served this way; a
+// native call of another method fails with Unimplemented, as the remote backends are reached by
+// their own clients directly.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithServer("0.0.0.0", 8081),
+//	    WithService(&hw.HelloWorldService_ServiceDesc, &helloService{},
+//	        gw.RegisterHelloWorldServiceHandlerFromEndpoint,
+//	    ),
+//	)
+func WithService(desc *grpc.ServiceDesc, impl interface{}, endpoints ...GatewayEndpoint) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.services = append(opt.services, localService{desc, impl})
+		WithBackendEndpoint(InProcessBackend, endpoints...)(opt)
+	}
+}
+
+// WithGRPCServerOptions is a GatewayOptionFunc that sets options of the gRPC server running the
+// services of WithService, such as interceptors or message size limits.
+func WithGRPCServerOptions(opts ...grpc.ServerOption) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.serverOpts = append(opt.serverOpts, opts...)
+	}
+}
+
+// localServer runs the services of WithService for one generation.
+type localServer struct {
+	server   *grpc.Server
+	listener *memoryListener
+	// identity adds the metadata of the client certificate to the native calls
+	identity bool
+	// policies and deadlines are enforced on the native calls, which bypass the backend connection
+	policies  []AuthorizationPolicy
+	deadlines deadlineRules
+}
+
+type nativeCallKey struct{}
+
+// startLocal starts the in-process services, which are stopped once ctx is canceled. It returns
+// nil without services.
+func (o *GatewayOption) startLocal(ctx context.Context) *localServer {
+	if len(o.services) == 0 {
+		return nil
+	}
+
+	l := &localServer{
+		listener:  newMemoryListener(),
+		identity:  o.tls.verifiesClients(),
+		policies:  o.policies,
+		deadlines: o.deadlines,
+	}
+
+	opts := append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(l.unaryInterceptor),
+		grpc.ChainStreamInterceptor(l.streamInterceptor),
+	}, o.serverOpts...)
+
+	l.server = grpc.NewServer(opts...)
+
+	for _, service := range o.services {
+		l.server.RegisterService(service.desc, service.impl)
+	}
+
+	go func() {
+		if err := l.server.Serve(l.listener); err != nil {
+			grpclog.Errorf("In-process gRPC server failed: %v", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		l.server.Stop()
+	}()
+
+	return l
+}
+
+// dialOptions connect to the in-process services over the in-memory listener.
+func (l *localServer) dialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.listener.DialContext(ctx)
+		}),
+	}
+}
+
+// serves reports whether method, given as "/package.Service/Method", belongs to an in-process
+// service. It is false for a nil server.
+func (l *localServer) serves(method string) bool {
+	if l == nil {
+		return false
+	}
+
+	service, _, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	_, ok := l.server.GetServiceInfo()[service]
+
+	return ok
+}
+
+// handler serves the native gRPC calls and passes the other requests on to h. The metadata the
+// REST routes forward from the identity of the request is set as headers of the call, which the
+// gRPC server turns into its metadata.
+func (l *localServer) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || !isGRPC(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		md := identityMetadata(r.Context(), r)
+
+		if l.identity {
+			md = metadata.Join(md, clientIdentityMetadata(r.Context(), r))
+		}
+
+		for key := range md {
+			r.Header.Del(key)
+		}
+
+		writeMetadataHeaders(r.Header, md, "")
+		l.server.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), nativeCallKey{}, true)))
+	})
+}
+
+// intercept authorizes a native call of method and applies its method timeout, as the
+// interceptors of the backend connection do for the REST routes. Calls from the backend
+// connection pass unchanged. The returned cancel is nil when ctx is kept.
+func (l *localServer) intercept(ctx context.Context, method string) (context.Context, context.CancelFunc, error) {
+	if native, _ := ctx.Value(nativeCallKey{}).(bool); !native {
+		return ctx, nil, nil
+	}
+
+	for _, policy := range l.policies {
+		if err := policy.authorizeMethod(ctx, method); err != nil {
+			return ctx, nil, err
+		}
+	}
+
+	ctx, cancel := l.deadlines.methodDeadline(ctx, method)
+
+	return ctx, cancel, nil
+}
+
+func (l *localServer) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel, err := l.intercept(ctx, info.FullMethod)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cancel != nil {
+		defer cancel()
+	}
+
+	return handler(ctx, req)
+}
+
+func (l *localServer) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel, err := l.intercept(ss.Context(), info.FullMethod)
+
+	if err != nil {
+		return err
+	}
+
+	if cancel == nil {
+		return handler(srv, ss)
+	}
+
+	defer cancel()
+
+	return handler(srv, &contextStream{ss, ctx})
+}
+
+// contextStream is a grpc.ServerStream whose context is replaced.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// isGRPC reports whether r is a native gRPC call, as opposed to a gRPC-Web one.
+func isGRPC(r *http.Request) bool {
+	ct := mediaType(r)
+
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+")
+}
+
+// grpcError answers a native gRPC call with the status of err in a trailers-only response.
+func grpcError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(st.Code())))
+
+	if st.Message() != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(st.Message()))
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// memoryListener is a net.Listener whose connections are dialed in process over net.Pipe.
+type memoryListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newMemoryListener() *memoryListener {
+	return &memoryListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+func (l *memoryListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *memoryListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+
+	return nil
+}
+
+func (l *memoryListener) Addr() net.Addr {
+	return memoryAddr{}
+}
+
+// DialContext connects to the listener, waiting until the connection is accepted.
+func (l *memoryListener) DialContext(ctx context.Context) (net.Conn, error) {
+	server, client := net.Pipe()
+
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-ctx.Done():
+		_ = server.Close()
+		_ = client.Close()
+		return nil, ctx.Err()
+	case <-l.done:
+		_ = server.Close()
+		_ = client.Close()
+		return nil, net.ErrClosed
+	}
+}
+
+type memoryAddr struct{}
+
+func (memoryAddr) Network() string {
+	return "memory"
+}
+
+func (memoryAddr) String() string {
+	return InProcessBackend
+}
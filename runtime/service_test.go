@@ -0,0 +1,187 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithService(t *testing.T) {
+	set := testDescriptorSet()
+	data, err := proto.Marshal(set)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "services.binpb")
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	// The items are answered by the echo handler of the in-process server
+	o := newGatewayOption(
+		WithGRPCServerOptions(grpc.UnknownServiceHandler(descriptorEchoHandler(t, set))),
+		WithService(&grpc_health_v1.Health_ServiceDesc, health.NewServer()),
+		WithBackendDescriptorSet(InProcessBackend, path),
+		WithGRPCWeb(),
+	)
+
+	gen, err := o.build()
+	assert.NoError(t, err)
+	defer gen.cancel()
+
+	server := httptest.NewUnstartedServer(gen.handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	// REST
+	res, err := server.Client().Get(server.URL + "/v1/items/book")
+	assert.NoError(t, err)
+
+	body, _ := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(body), `"book"`)
+
+	// Native gRPC on the same port
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	conn, err := grpc.NewClient(server.Listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool})))
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	check, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check.GetStatus())
+
+	// gRPC-Web calls of in-process services stay in process
+	req, err := http.NewRequest("POST", server.URL+"/grpc.health.v1.Health/Check", bytes.NewReader(appendEnvelope(nil, 0, nil)))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+
+	res, err = server.Client().Do(req)
+	assert.NoError(t, err)
+
+	body, _ = io.ReadAll(res.Body)
+	_ = res.Body.Close()
+
+	assert.Contains(t, string(body), "grpc-status: 0\r\n")
+
+	// Endpoints of the in-process backend need services
+	_, err = newGatewayOption(WithBackendDescriptorSet(InProcessBackend, path)).build()
+	assert.ErrorContains(t, err, "no services")
+}
+
+func TestWithService_Chain(t *testing.T) {
+	incoming := make(chan metadata.MD, 1)
+	capture := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		incoming <- md
+
+		return handler(ctx, req)
+	}
+
+	o := newGatewayOption(
+		WithGRPCServerOptions(grpc.UnaryInterceptor(capture)),
+		WithService(&grpc_health_v1.Health_ServiceDesc, health.NewServer()),
+		WithAPIKeyAuth(NewMemoryKeyStore(map[string]APIKey{"secret": {Name: "reader"}})),
+		WithHandler(GzipCompressHandler),
+	)
+
+	gen, err := o.build()
+	assert.NoError(t, err)
+	defer gen.cancel()
+
+	server := httptest.NewUnstartedServer(gen.handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	conn, err := grpc.NewClient(server.Listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool})))
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	// Native gRPC calls without an API key are rejected with a gRPC status
+	_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// The identity of the key replaces the metadata sent by the client
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "secret", APIKeyNameMeta, "admin")
+	check, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check.GetStatus())
+	assert.Equal(t, []string{"reader"}, (<-incoming).Get(APIKeyNameMeta))
+}
+
+func TestWithService_Authorization(t *testing.T) {
+	o := newGatewayOption(
+		WithService(&grpc_health_v1.Health_ServiceDesc, health.NewServer()),
+		WithAPIKeyAuth(NewMemoryKeyStore(map[string]APIKey{
+			"reader": {Name: "reader"},
+			"admin":  {Name: "admin", Scopes: []string{"admin"}},
+		})),
+		WithAuthorization(AuthorizationPolicy{
+			DenyByDefault: true,
+			Rules:         []AuthorizationRule{{Method: "/grpc.health.v1.Health/*", Scopes: []string{"admin"}}},
+		}),
+	)
+
+	gen, err := o.build()
+	assert.NoError(t, err)
+	defer gen.cancel()
+
+	server := httptest.NewUnstartedServer(gen.handler)
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+
+	conn, err := grpc.NewClient(server.Listener.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool})))
+	assert.NoError(t, err)
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	client := grpc_health_v1.NewHealthClient(conn)
+	reader := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "reader")
+	admin := metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "admin")
+
+	// The method rules apply to the native calls as they do to the REST routes
+	_, err = client.Check(reader, &grpc_health_v1.HealthCheckRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	watch, err := client.Watch(reader, &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = watch.Recv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	check, err := client.Check(admin, &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, check.GetStatus())
+}
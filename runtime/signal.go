@@ -0,0 +1,61 @@
+package runtime
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Run starts the GatewayServer and blocks until it stops.
+// SIGINT and SIGTERM, or the cancellation of ctx, drain the server with Stop and make Run return.
+// SIGHUP calls Reload of a ReloadableServer, which reopens the log files and applies the
+// configuration again while the server keeps running, and is ignored by other servers; a failed
+// reload that leaves the server down ends Run with the error returned by Start.
+//
+// Example usage:
+//
+//	server, err := NewGateway(
+//	    WithServer("0.0.0.0", 8081),
+//	)
+//
+//	if err != nil {
+//	    panic(err)
+//	}
+//
+//	if err := Run(context.Background(), server); err != nil {
+//	    panic(err)
+//	}
+func Run(ctx context.Context, server GatewayServer) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	defer signal.Stop(signals)
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- server.Start()
+	}()
+
+	for {
+		select {
+		case err := <-result:
+			return err
+		case <-ctx.Done():
+			server.Stop()
+			return <-result
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				if r, ok := server.(ReloadableServer); ok {
+					_ = r.Reload()
+				}
+
+				continue
+			}
+
+			server.Stop()
+			return <-result
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testServer is a GatewayServer whose Start blocks until Stop.
+type testServer struct {
+	err     error
+	stop    chan struct{}
+	once    sync.Once
+	stops   atomic.Int32
+	reloads atomic.Int32
+}
+
+func newTestServer(err error) *testServer {
+	return &testServer{err: err, stop: make(chan struct{})}
+}
+
+func (s *testServer) Start() error {
+	if s.err != nil {
+		return s.err
+	}
+
+	<-s.stop
+
+	return nil
+}
+
+func (s *testServer) Stop() GatewayServer {
+	s.stops.Add(1)
+	s.once.Do(func() {
+		close(s.stop)
+	})
+
+	return s
+}
+
+func (s *testServer) Restart() error {
+	return nil
+}
+
+// reloadableTestServer is a testServer that counts the calls of Reload.
+type reloadableTestServer struct {
+	*testServer
+}
+
+func (s reloadableTestServer) Reload() error {
+	s.reloads.Add(1)
+	return nil
+}
+
+// runWithSignals runs server and sends sig to the process until done reports true. The test
+// registers sig itself, so that a signal sent before Run registers it does not end the process.
+func runWithSignals(t *testing.T, server GatewayServer, sig os.Signal, done func() bool) chan error {
+	caught := make(chan os.Signal, 16)
+	signal.Notify(caught, sig)
+	t.Cleanup(func() {
+		signal.Stop(caught)
+	})
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- Run(context.Background(), server)
+	}()
+
+	assert.Eventually(t, func() bool {
+		_ = syscall.Kill(os.Getpid(), sig.(syscall.Signal))
+		return done()
+	}, 5*time.Second, 20*time.Millisecond)
+
+	return result
+}
+
+func TestRun(t *testing.T) {
+	// The cancellation of ctx stops the server
+	s := newTestServer(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+
+	go func() {
+		result <- Run(ctx, s)
+	}()
+
+	cancel()
+	assert.NoError(t, <-result)
+	assert.Equal(t, int32(1), s.stops.Load())
+
+	// A failing Start ends Run with its error
+	failure := errors.New("listen failed")
+	assert.ErrorIs(t, Run(context.Background(), newTestServer(failure)), failure)
+
+	// SIGHUP reloads a ReloadableServer and keeps it running
+	r := reloadableTestServer{newTestServer(nil)}
+	result = runWithSignals(t, r, syscall.SIGHUP, func() bool {
+		return r.reloads.Load() > 0
+	})
+
+	assert.Equal(t, int32(0), r.stops.Load())
+	r.Stop()
+	assert.NoError(t, <-result)
+
+	// SIGTERM stops the server
+	s = newTestServer(nil)
+	result = runWithSignals(t, s, syscall.SIGTERM, func() bool {
+		return s.stops.Load() > 0
+	})
+
+	assert.NoError(t, <-result)
+}
@@ -0,0 +1,362 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The media types of the stream formats, negotiated with the Accept header.
+const (
+	eventStreamType = "text/event-stream"
+	ndjsonType      = "application/x-ndjson"
+)
+
+// lastEventIDHeader carries the id of the last event received by a reconnecting EventSource.
+const lastEventIDHeader = "Last-Event-ID"
+
+type streamFormats struct {
+	heartbeat time.Duration
+}
+
+// WithStreamFormats is a GatewayOptionFunc that lets the clients of server-streaming methods pick
+// a format browsers consume easily instead of the chunked {"result": ...} envelopes of
+// grpc-gateway. The format is negotiated with the Accept header, q-values included:
+//
+//   - text/event-stream sends every message as a Server-Sent Event numbered with its id, and
+//     errors as "error" events. The Last-Event-ID header of a reconnecting client is passed to
+//     the backend as the last-event-id metadata, and the ids continue after it. The stream opens
+//     with the status 200 at the first message; errors before it, such as unknown routes, failed
+//     authentication or a backend failing the call, are answered by the error handler with
+//     their status instead. A comment is sent every heartbeat while the open stream is idle, so
+//     that proxies keep the connection open; zero disables the heartbeats.
+//   - application/x-ndjson sends every message as a line of JSON, and errors as a line with
+//     the {"error": ...} envelope.
+//
+// Every message is flushed to the client once written, through the compression handlers too.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithServer("0.0.0.0", 8081),
+//	    WithStreamFormats(15*time.Second),
+//	)
+func WithStreamFormats(heartbeat time.Duration) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.streams = &streamFormats{heartbeat: heartbeat}
+	}
+}
+
+// muxOptions register the marshalers of the stream formats and pass Last-Event-ID on.
+func (s *streamFormats) muxOptions() []runtime.ServeMuxOption {
+	return []runtime.ServeMuxOption{
+		runtime.WithMarshalerOption(eventStreamType, &eventStreamMarshaler{streamJSON()}),
+		runtime.WithMarshalerOption(ndjsonType, &ndjsonMarshaler{streamJSON()}),
+		runtime.WithMetadata(lastEventIDMetadata),
+	}
+}
+
+// handler sets the Accept header of the requests preferring a stream format to that format, which
+// the mux matches exactly, and numbers the events of the event streams and sends their heartbeats.
+func (s *streamFormats) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := streamFormat(r)
+
+		if format != "" {
+			r.Header.Set("Accept", format)
+		}
+
+		if format != eventStreamType {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &eventStreamWriter{ResponseWriter: w, id: lastEventID(r) + 1}
+
+		if s.heartbeat > 0 {
+			done := make(chan struct{})
+			defer close(done)
+
+			go func() {
+				ticker := time.NewTicker(s.heartbeat)
+				defer ticker.Stop()
+
+				for {
+					select {
+					case <-ticker.C:
+						sw.heartbeat()
+					case <-done:
+						return
+					}
+				}
+			}()
+		}
+
+		defer sw.close()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+// streamFormat returns the stream format preferred by the Accept header of r, or "" when the
+// client prefers another media type or names none of the formats. A format wins a tie with a
+// wildcard.
+func streamFormat(r *http.Request) string {
+	var format string
+	var best, other float64
+
+	for _, value := range r.Header.Values("Accept") {
+		for _, item := range chunkValues(value) {
+			mt, params, err := mime.ParseMediaType(item)
+
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+
+			switch {
+			case mt != eventStreamType && mt != ndjsonType:
+				other = max(other, q)
+			case q > best:
+				format, best = mt, q
+			}
+		}
+	}
+
+	if best > 0 && best >= other {
+		return format
+	}
+
+	return ""
+}
+
+// lastEventID returns the id sent by a reconnecting client, or zero.
+func lastEventID(r *http.Request) uint64 {
+	id, err := strconv.ParseUint(strings.TrimSpace(r.Header.Get(lastEventIDHeader)), 10, 64)
+
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
+
+func lastEventIDMetadata(_ context.Context, r *http.Request) metadata.MD {
+	if id := r.Header.Get(lastEventIDHeader); id != "" {
+		return metadata.Pairs("last-event-id", id)
+	}
+
+	return metadata.MD{}
+}
+
+// streamJSON renders the messages like the default marshaler of grpc-gateway.
+func streamJSON() runtime.JSONPb {
+	return runtime.JSONPb{
+		MarshalOptions:   protojson.MarshalOptions{EmitUnpopulated: true},
+		UnmarshalOptions: protojson.UnmarshalOptions{DiscardUnknown: true},
+	}
+}
+
+// streamResult unwraps a message from the {"result": ...} envelope of runtime.ForwardResponseStream.
+func streamResult(v interface{}) interface{} {
+	if chunk, ok := v.(map[string]interface{}); ok && len(chunk) == 1 {
+		if result, ok := chunk["result"]; ok {
+			return result
+		}
+	}
+
+	return v
+}
+
+// streamError returns the status of an error chunk of runtime.ForwardResponseStream, or of an
+// error answered by runtime.DefaultHTTPErrorHandler.
+func streamError(v interface{}) (proto.Message, bool) {
+	switch v := v.(type) {
+	case map[string]proto.Message:
+		st, ok := v["error"]
+		return st, ok && len(v) == 1
+	case *spb.Status:
+		return v, true
+	}
+
+	return nil, false
+}
+
+// ndjsonMarshaler writes the messages of a stream as lines of JSON.
+type ndjsonMarshaler struct {
+	runtime.JSONPb
+}
+
+func (m *ndjsonMarshaler) Marshal(v interface{}) ([]byte, error) {
+	return m.JSONPb.Marshal(streamResult(v))
+}
+
+func (m *ndjsonMarshaler) ContentType(_ interface{}) string {
+	return ndjsonType
+}
+
+func (m *ndjsonMarshaler) Delimiter() []byte {
+	return []byte("\n")
+}
+
+// eventStreamMarshaler writes the messages of a stream as the fields of Server-Sent Events. The
+// id of the events is added by eventStreamWriter.
+type eventStreamMarshaler struct {
+	runtime.JSONPb
+}
+
+func (m *eventStreamMarshaler) Marshal(v interface{}) ([]byte, error) {
+	var event bytes.Buffer
+
+	if st, ok := streamError(v); ok {
+		event.WriteString("event: error\n")
+		v = st
+	}
+
+	buf, err := m.JSONPb.Marshal(streamResult(v))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range bytes.Split(buf, []byte("\n")) {
+		event.WriteString("data: ")
+		event.Write(line)
+		event.WriteByte('\n')
+	}
+
+	return event.Bytes(), nil
+}
+
+func (m *eventStreamMarshaler) ContentType(_ interface{}) string {
+	return eventStreamType
+}
+
+// Delimiter ends an event with a blank line.
+func (m *eventStreamMarshaler) Delimiter() []byte {
+	return []byte("\n")
+}
+
+// eventStreamWriter prefixes the events of a text/event-stream response with their id. An event is
+// what is written between two flushes, as runtime.ForwardResponseStream flushes every message.
+// The stream is opened by the first message; a response starting with an error status is
+// passed through as the error handler writes it.
+type eventStreamWriter struct {
+	http.ResponseWriter
+	mu      sync.Mutex
+	id      uint64
+	open    bool // an event was written since the last flush
+	ended   int  // the newlines ending the written data
+	started bool // the response headers were sent
+	failed  bool // the response is an error rather than a stream
+	closed  bool
+}
+
+// start sends the headers of the event stream. The caller holds mu.
+func (w *eventStreamWriter) start() {
+	w.Header().Set("Content-Type", eventStreamType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+	flushResponse(w.ResponseWriter)
+	w.started = true
+}
+
+// WriteHeader opens the stream with a successful status, and sends an error status as it is.
+// The status of the mux is ignored once the stream has started.
+func (w *eventStreamWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch {
+	case w.started:
+	case code >= 200 && code < 300:
+		w.start()
+	default:
+		w.ResponseWriter.WriteHeader(code)
+		w.started = true
+		w.failed = true
+	}
+}
+
+func (w *eventStreamWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.started {
+		w.start()
+	}
+
+	if !w.open && !w.failed {
+		if _, err := w.ResponseWriter.Write([]byte("id: " + strconv.FormatUint(w.id, 10) + "\n")); err != nil {
+			return 0, err
+		}
+
+		w.id++
+	}
+
+	w.open = true
+
+	if trimmed := bytes.TrimRight(b, "\n"); len(trimmed) == 0 {
+		w.ended += len(b)
+	} else {
+		w.ended = len(b) - len(trimmed)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *eventStreamWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.started {
+		w.start()
+	}
+
+	w.open = false
+	flushResponse(w.ResponseWriter)
+}
+
+// heartbeat sends a comment between the events of a started stream.
+func (w *eventStreamWriter) heartbeat() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || !w.started || w.failed || w.open {
+		return
+	}
+
+	if _, err := w.ResponseWriter.Write([]byte(": heartbeat\n\n")); err == nil {
+		flushResponse(w.ResponseWriter)
+	}
+}
+
+// close ends the last event of a unary response, which lacks the blank line of the stream
+// delimiter, and stops the heartbeats before the response is done.
+func (w *eventStreamWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.open && w.ended < 2 {
+		_, _ = w.ResponseWriter.Write([]byte("\n"))
+	}
+
+	w.closed = true
+}
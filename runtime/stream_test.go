@@ -0,0 +1,213 @@
+package runtime
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithStreamFormats(t *testing.T) {
+	set := testDescriptorSet()
+	data, err := proto.Marshal(set)
+	assert.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "services.binpb")
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+
+	host, port, err := net.SplitHostPort(startDescriptorServer(t, set))
+	assert.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+
+	o := newGatewayOption(
+		WithBackend(host, uint(p)),
+		WithDescriptorSet(path),
+		WithStreamFormats(time.Minute),
+		WithHandler(GzipCompressHandler),
+	)
+
+	gen, err := o.build()
+	assert.NoError(t, err)
+	defer gen.cancel()
+
+	server := httptest.NewServer(gen.handler)
+	defer server.Close()
+
+	item := `{"name":"book","page":0,"note":""}`
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		lastID   string
+		encoding string
+		ct       string
+		code     int
+		want     string
+	}{
+		{"event stream", "/v1/items/book:watch", eventStreamType, "", "", eventStreamType, http.StatusOK, "id: 1\ndata: " + item + "\n\nid: 2\ndata: " + item + "\n\n"},
+		{"last event id", "/v1/items/book:watch", eventStreamType, "5", "", eventStreamType, http.StatusOK, "id: 6\ndata: " + item + "\n\nid: 7\ndata: " + item + "\n\n"},
+		{"gzip", "/v1/items/book:watch", eventStreamType, "", "gzip", eventStreamType, http.StatusOK, "id: 1\ndata: " + item + "\n\nid: 2\ndata: " + item + "\n\n"},
+		{"accept list", "/v1/items/book:watch", "application/json;q=0.5, text/event-stream;q=0.9", "", "", eventStreamType, http.StatusOK, "id: 1\ndata: " + item + "\n\nid: 2\ndata: " + item + "\n\n"},
+		{"accept wildcard", "/v1/items/book:watch", "*/*, text/event-stream", "", "", eventStreamType, http.StatusOK, "id: 1\ndata: " + item + "\n\nid: 2\ndata: " + item + "\n\n"},
+		{"unary", "/v1/items/book", eventStreamType, "", "", eventStreamType, http.StatusOK, "id: 1\ndata: {\"name\":\"book\",\"page\":0,\"note\":\"note:book\"}\n\n"},
+		{"ndjson", "/v1/items/book:watch", ndjsonType, "", "", ndjsonType, http.StatusOK, item + "\n" + item + "\n"},
+		{"ndjson q", "/v1/items/book:watch", "application/x-ndjson;q=0.8", "", "", ndjsonType, http.StatusOK, item + "\n" + item + "\n"},
+		{"error", "/v1/unknown", eventStreamType, "", "", eventStreamType, http.StatusNotFound, "event: error\ndata: {\"code\":5,\"message\":\"NotFound\",\"details\":[]}\n\n"},
+		{"error before the stream", "/v1/items/book:watch?page=x", eventStreamType, "", "", eventStreamType, http.StatusBadRequest, "event: error\n"},
+		{"prefers json", "/v1/items/book:watch", "text/event-stream;q=0.5, application/json", "", "", "application/json", http.StatusOK, `{"result":` + item + "}\n" + `{"result":` + item + "}\n"},
+		{"refused", "/v1/items/book:watch", "text/event-stream;q=0", "", "", "application/json", http.StatusOK, `{"result":` + item + "}\n" + `{"result":` + item + "}\n"},
+		{"json", "/v1/items/book:watch", "", "", "", "application/json", http.StatusOK, `{"result":` + item + "}\n" + `{"result":` + item + "}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", server.URL+tt.path, nil)
+			assert.NoError(t, err)
+
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			if tt.lastID != "" {
+				req.Header.Set(lastEventIDHeader, tt.lastID)
+			}
+
+			if tt.encoding != "" {
+				req.Header.Set("Accept-Encoding", tt.encoding)
+			}
+
+			res, err := http.DefaultTransport.RoundTrip(req)
+			assert.NoError(t, err)
+
+			var body io.Reader = res.Body
+
+			if tt.encoding != "" {
+				assert.Equal(t, tt.encoding, res.Header.Get("Content-Encoding"))
+				body, err = gzip.NewReader(res.Body)
+				assert.NoError(t, err)
+			}
+
+			buf, _ := io.ReadAll(body)
+			_ = res.Body.Close()
+
+			assert.Equal(t, tt.code, res.StatusCode)
+			assert.Equal(t, tt.ct, res.Header.Get("Content-Type"))
+
+			// protojson varies the spaces of its output, and the parser its messages
+			if tt.code == http.StatusBadRequest {
+				assert.True(t, strings.HasPrefix(string(buf), tt.want), string(buf))
+			} else {
+				assert.Equal(t, strings.ReplaceAll(tt.want, " ", ""), strings.ReplaceAll(string(buf), " ", ""))
+			}
+		})
+	}
+
+	// Every event is flushed through the compression on its own
+	req := httptest.NewRequest("GET", "/v1/items/book:watch", nil)
+	req.Header.Set("Accept", eventStreamType)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	gen.handler.ServeHTTP(w, req)
+
+	var flushed []string
+
+	for _, body := range w.flushes {
+		zr, err := gzip.NewReader(strings.NewReader(body))
+		assert.NoError(t, err)
+
+		// The stream is not closed yet at a flush
+		buf, _ := io.ReadAll(zr)
+		text := strings.ReplaceAll(string(buf), " ", "")
+
+		if len(flushed) == 0 || flushed[len(flushed)-1] != text {
+			flushed = append(flushed, text)
+		}
+	}
+
+	event := func(id int) string {
+		return "id:" + strconv.Itoa(id) + "\ndata:" + strings.ReplaceAll(item, " ", "") + "\n\n"
+	}
+
+	assert.Equal(t, []string{"", event(1), event(1) + event(2)}, flushed)
+}
+
+// flushRecorder records the body sent at every flush.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes []string
+}
+
+func (w *flushRecorder) Flush() {
+	w.ResponseRecorder.Flush()
+	w.flushes = append(w.flushes, w.Body.String())
+}
+
+func TestEventStreamWriter(t *testing.T) {
+	w := httptest.NewRecorder()
+	sw := &eventStreamWriter{ResponseWriter: w, id: 1}
+
+	// The stream opens with its first event, and the heartbeats wait for it
+	sw.heartbeat()
+	sw.WriteHeader(http.StatusOK)
+	sw.heartbeat()
+	sw.WriteHeader(http.StatusNotFound)
+
+	_, _ = sw.Write([]byte("data: 1\n"))
+	sw.heartbeat()
+	_, _ = sw.Write([]byte("\n"))
+	sw.Flush()
+	sw.heartbeat()
+
+	// A stream error leaves its event unflushed
+	chunk := map[string]proto.Message{"error": &spb.Status{Code: 5, Message: "gone"}}
+	buf, err := (&eventStreamMarshaler{streamJSON()}).Marshal(chunk)
+	assert.NoError(t, err)
+	_, _ = sw.Write(buf)
+	_, _ = sw.Write([]byte("\n"))
+	sw.close()
+	sw.heartbeat()
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, ": heartbeat\n\nid: 1\ndata: 1\n\n: heartbeat\n\nid: 2\nevent: error\ndata: {\"code\":5,\"message\":\"gone\",\"details\":[]}\n\n", strings.ReplaceAll(w.Body.String(), ", ", ","))
+	assert.Equal(t, eventStreamType, w.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", w.Header().Get("Cache-Control"))
+	assert.True(t, w.Flushed)
+
+	// An error before the stream keeps its status and is written as it is
+	w = httptest.NewRecorder()
+	sw = &eventStreamWriter{ResponseWriter: w, id: 1}
+
+	w.Header().Set("Content-Type", eventStreamType)
+	sw.WriteHeader(http.StatusUnauthorized)
+	sw.heartbeat()
+	_, _ = sw.Write([]byte("event: error\ndata: {}\n"))
+	sw.close()
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "event: error\ndata: {}\n\n", w.Body.String())
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+}
+
+func TestLastEventIDMetadata(t *testing.T) {
+	assert.Empty(t, lastEventIDMetadata(context.Background(), httptest.NewRequest("GET", "/", nil)))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(lastEventIDHeader, "7")
+
+	assert.Equal(t, []string{"7"}, lastEventIDMetadata(context.Background(), r).Get("last-event-id"))
+	assert.Equal(t, uint64(7), lastEventID(r))
+}
@@ -0,0 +1,253 @@
+package runtime
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestTimeoutHeader lets a client ask for a deadline shorter or longer than the route
+// timeout, given as a Go duration such as "2.5s" or a number of seconds. Like the gRPC
+// Grpc-Timeout header it is capped by WithMaxTimeout.
+const RequestTimeoutHeader = "X-Request-Timeout"
+
+const grpcTimeoutHeader = "Grpc-Timeout"
+
+type routeTimeout struct {
+	method  string
+	pattern string
+	timeout time.Duration
+}
+
+type methodTimeout struct {
+	method  string
+	timeout time.Duration
+}
+
+// deadlineRules are the timeouts applied to the requests forwarded to the backends.
+type deadlineRules struct {
+	routes   []routeTimeout
+	methods  []methodTimeout
+	fallback time.Duration
+	max      time.Duration
+}
+
+func (d deadlineRules) enabled() bool {
+	return len(d.routes) > 0 || len(d.methods) > 0 || d.fallback > 0 || d.max > 0
+}
+
+// WithRouteTimeout is a GatewayOptionFunc that sets the deadline of the requests matching the
+// HTTP method and path pattern. An empty method or "*" matches any method. In the pattern a "*"
+// or "{name}" segment matches one path segment and a trailing "**" the rest of the path.
+// The first matching route applies.
+//
+// Example usage:
+//
+//	server := NewGateway(
+//	    WithDefaultTimeout(10 * time.Second),
+//	    WithRouteTimeout("GET", "/v1/reports/**", time.Minute),
+//	    WithMethodTimeout("/users.UserService/*", 2 * time.Second),
+//	)
+func WithRouteTimeout(method, pattern string, timeout time.Duration) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.deadlines.routes = append(opt.deadlines.routes, routeTimeout{strings.ToUpper(method), pattern, timeout})
+	}
+}
+
+// WithMethodTimeout is a GatewayOptionFunc that sets the deadline of the calls to the gRPC full
+// method name, or to every method of a service with "/package.Service/*". A shorter deadline
+// already set by a route timeout or requested by the client is kept, so a client can shorten the
+// method timeout but never extend it.
+func WithMethodTimeout(method string, timeout time.Duration) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.deadlines.methods = append(opt.deadlines.methods, methodTimeout{method, timeout})
+	}
+}
+
+// WithDefaultTimeout is a GatewayOptionFunc that sets the deadline of the requests matching no route timeout.
+func WithDefaultTimeout(timeout time.Duration) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.deadlines.fallback = timeout
+	}
+}
+
+// WithMaxTimeout is a GatewayOptionFunc that caps the timeout a client requests with the
+// Grpc-Timeout or X-Request-Timeout headers. Without it the request is capped by its route timeout.
+func WithMaxTimeout(timeout time.Duration) GatewayOptionFunc {
+	return func(opt *GatewayOption) {
+		opt.deadlines.max = timeout
+	}
+}
+
+// route returns the timeout configured for the request, or the default timeout.
+func (d deadlineRules) route(r *http.Request) time.Duration {
+	for _, route := range d.routes {
+		if route.method != "" && route.method != "*" && route.method != r.Method {
+			continue
+		}
+
+		if matchPath(route.pattern, r.URL.Path) {
+			return route.timeout
+		}
+	}
+
+	return d.fallback
+}
+
+// handler sets the deadline of the request context from the route timeout or the timeout
+// requested by the client. The timeout headers are consumed, so that the backend only sees the
+// resulting deadline.
+func (d deadlineRules) handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := d.route(r)
+		requested, ok := requestedTimeout(r.Header)
+
+		r.Header.Del(RequestTimeoutHeader)
+		r.Header.Del(grpcTimeoutHeader)
+
+		ctx := r.Context()
+
+		if ok {
+			limit := d.max
+
+			if limit <= 0 {
+				limit = timeout
+			}
+
+			if limit > 0 && requested > limit {
+				requested = limit
+			}
+
+			timeout = requested
+		}
+
+		if timeout > 0 {
+			var cancel context.CancelFunc
+
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// methodDeadline applies the timeout of method to ctx, keeping an earlier deadline of ctx.
+// The returned cancel is nil when ctx is kept.
+func (d deadlineRules) methodDeadline(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	for _, m := range d.methods {
+		if matchMethod(m.method, method) {
+			return context.WithTimeout(ctx, m.timeout)
+		}
+	}
+
+	return ctx, nil
+}
+
+// interceptor applies the method timeouts to the backend calls.
+func (d deadlineRules) interceptor() ClientInterceptor {
+	return ClientInterceptor{
+		Unary: func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			ctx, cancel := d.methodDeadline(ctx, method)
+
+			if cancel != nil {
+				defer cancel()
+			}
+
+			return invoker(ctx, method, req, reply, cc, opts...)
+		},
+		Stream: func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			ctx, cancel := d.methodDeadline(ctx, method)
+
+			// The stream outlives this call, so its context is released once it is done
+			if cancel != nil {
+				go func() {
+					<-ctx.Done()
+					cancel()
+				}()
+			}
+
+			return streamer(ctx, desc, cc, method, opts...)
+		},
+	}
+}
+
+// requestedTimeout reads the timeout from the X-Request-Timeout or Grpc-Timeout header.
+func requestedTimeout(header http.Header) (time.Duration, bool) {
+	if value := header.Get(RequestTimeoutHeader); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			return d, true
+		}
+
+		if s, err := strconv.ParseFloat(value, 64); err == nil && s > 0 {
+			return time.Duration(s * float64(time.Second)), true
+		}
+	}
+
+	if value := header.Get(grpcTimeoutHeader); value != "" {
+		return parseGrpcTimeout(value)
+	}
+
+	return 0, false
+}
+
+// parseGrpcTimeout decodes the Grpc-Timeout header format, an integer followed by one of the
+// units H, M, S, m, u or n.
+func parseGrpcTimeout(value string) (time.Duration, bool) {
+	if len(value) < 2 {
+		return 0, false
+	}
+
+	units := map[byte]time.Duration{
+		'H': time.Hour,
+		'M': time.Minute,
+		'S': time.Second,
+		'm': time.Millisecond,
+		'u': time.Microsecond,
+		'n': time.Nanosecond,
+	}
+
+	unit, ok := units[value[len(value)-1]]
+
+	if !ok {
+		return 0, false
+	}
+
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(n) * unit, true
+}
+
+// matchMethod matches a gRPC full method name against a method pattern, see WithMethodTimeout.
+func matchMethod(pattern, method string) bool {
+	return pattern == method || (strings.HasSuffix(pattern, "/*") && strings.HasPrefix(method, pattern[:len(pattern)-1]))
+}
+
+// matchPath matches a URL path against a route pattern, see WithRouteTimeout.
+func matchPath(pattern, path string) bool {
+	patterns := strings.Split(strings.Trim(pattern, "/"), "/")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for i, p := range patterns {
+		if p == "**" && i == len(patterns)-1 {
+			return true
+		}
+
+		if i >= len(segments) {
+			return false
+		}
+
+		if p != "*" && !(strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}")) && p != segments[i] {
+			return false
+		}
+	}
+
+	return len(patterns) == len(segments)
+}
@@ -0,0 +1,162 @@
+package runtime
+
+import (
+	"context"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		expect  bool
+	}{
+		{"/v1/users", "/v1/users", true},
+		{"/v1/users", "/v1/users/1", false},
+		{"/v1/users/{id}", "/v1/users/1", true},
+		{"/v1/users/*/posts", "/v1/users/1/posts", true},
+		{"/v1/users/*/posts", "/v1/users/1/likes", false},
+		{"/v1/reports/**", "/v1/reports/2024/06", true},
+		{"/v1/reports/**", "/v1/users", false},
+		{"/**", "/anything/at/all", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.expect, matchPath(tt.pattern, tt.path))
+		})
+	}
+}
+
+func TestRequestedTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		expect time.Duration
+		ok     bool
+	}{
+		{"duration", RequestTimeoutHeader, "1.5s", 1500 * time.Millisecond, true},
+		{"seconds", RequestTimeoutHeader, "2", 2 * time.Second, true},
+		{"grpc seconds", grpcTimeoutHeader, "5S", 5 * time.Second, true},
+		{"grpc millis", grpcTimeoutHeader, "250m", 250 * time.Millisecond, true},
+		{"invalid unit", grpcTimeoutHeader, "5x", 0, false},
+		{"invalid value", RequestTimeoutHeader, "soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(tt.header, tt.value)
+
+			d, ok := requestedTimeout(header)
+
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expect, d)
+		})
+	}
+}
+
+func TestDeadlineRules_Handler(t *testing.T) {
+	server := newGatewayOption(
+		WithDefaultTimeout(time.Second),
+		WithMaxTimeout(10*time.Second),
+		WithRouteTimeout("GET", "/v1/reports/**", time.Minute),
+	)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		header  string
+		expect  time.Duration
+	}{
+		{"default", "GET", "/v1/users", "", time.Second},
+		{"route", "GET", "/v1/reports/daily", "", time.Minute},
+		{"other method", "POST", "/v1/reports/daily", "", time.Second},
+		{"requested", "GET", "/v1/users", "5s", 5 * time.Second},
+		{"capped", "GET", "/v1/users", "1h", 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var remaining time.Duration
+
+			handler := server.deadlines.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				deadline, _ := r.Context().Deadline()
+				remaining = time.Until(deadline)
+
+				assert.Empty(t, r.Header.Get(RequestTimeoutHeader))
+			}))
+
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+
+			if tt.header != "" {
+				r.Header.Set(RequestTimeoutHeader, tt.header)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.InDelta(t, tt.expect, remaining, float64(100*time.Millisecond))
+		})
+	}
+}
+
+func TestDeadlineRules_MethodTimeout(t *testing.T) {
+	// Without a max or route timeout the requested timeout is uncapped, and the method timeout still applies
+	server := newGatewayOption(WithMethodTimeout("/users.UserService/*", 2*time.Second))
+	unary := server.deadlines.interceptor().Unary
+
+	tests := []struct {
+		name   string
+		header string
+		expect time.Duration
+	}{
+		{"method", "", 2 * time.Second},
+		{"longer requested", "1h", 2 * time.Second},
+		{"shorter requested", "500ms", 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var remaining time.Duration
+
+			handler := server.deadlines.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = unary(r.Context(), "/users.UserService/GetUser", nil, nil, nil,
+					func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+						deadline, _ := ctx.Deadline()
+						remaining = time.Until(deadline)
+						return nil
+					})
+			}))
+
+			r := httptest.NewRequest("GET", "/v1/users/1", nil)
+
+			if tt.header != "" {
+				r.Header.Set(RequestTimeoutHeader, tt.header)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			assert.InDelta(t, tt.expect, remaining, float64(100*time.Millisecond))
+		})
+	}
+}
+
+func TestErrorCapture_Deadline(t *testing.T) {
+	server := newGatewayOption()
+	mux := runtime.NewServeMux(errorCapture(server))
+
+	r := httptest.NewRequest("GET", "/v1/users", nil)
+	w := httptest.NewRecorder()
+
+	runtime.HTTPError(context.Background(), mux, &runtime.JSONPb{}, w, r, context.DeadlineExceeded)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+}
@@ -0,0 +1,288 @@
+package runtime
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Metadata keys carrying the identity of a verified client certificate to the backend.
+const (
+	ClientCertSubjectMeta     = "x-client-cert-subject"
+	ClientCertSANMeta         = "x-client-cert-san"
+	ClientCertFingerprintMeta = "x-client-cert-fingerprint"
+)
+
+type ServerTLSOption func(*ServerTLS)
+
+type certPair struct {
+	cert string
+	key  string
+}
+
+// Certificate adds a certificate and key served to the clients whose SNI server name it covers.
+// The certificate given to WithTLS is the default for clients matching none of them.
+func Certificate(cert, key string) ServerTLSOption {
+	return func(t *ServerTLS) {
+		t.certs = append(t.certs, certPair{cert, key})
+	}
+}
+
+// ClientCA sets the PEM encoded CA bundles used to verify client certificates.
+// Unless ClientAuth is given as well, clients are then required to present a valid certificate.
+func ClientCA(paths ...string) ServerTLSOption {
+	return func(t *ServerTLS) {
+		t.clientCA = append(t.clientCA, paths...)
+	}
+}
+
+// ClientAuth sets how client certificates are requested and verified, e.g.
+// tls.VerifyClientCertIfGiven to accept clients without a certificate.
+func ClientAuth(mode tls.ClientAuthType) ServerTLSOption {
+	return func(t *ServerTLS) {
+		t.clientAuth = &mode
+	}
+}
+
+// ParseClientAuth converts the configuration names "none", "request", "require_any",
+// "verify_if_given" and "require_and_verify" into a tls.ClientAuthType.
+func ParseClientAuth(name string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(name) {
+	case "none", "":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require_any":
+		return tls.RequireAnyClientCert, nil
+	case "verify_if_given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require_and_verify":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+
+	return tls.NoClientCert, fmt.Errorf("unknown client auth mode %q", name)
+}
+
+func (t *ServerTLS) authType() tls.ClientAuthType {
+	if t.clientAuth != nil {
+		return *t.clientAuth
+	}
+
+	if len(t.clientCA) > 0 {
+		return tls.RequireAndVerifyClientCert
+	}
+
+	return tls.NoClientCert
+}
+
+// verifiesClients reports whether the listener verifies the certificates clients present.
+func (t *ServerTLS) verifiesClients() bool {
+	if t == nil {
+		return false
+	}
+
+	return t.authType() >= tls.VerifyClientCertIfGiven
+}
+
+// config builds the tls.Config of the listener, serving the certificates from certs and
+// verifying clients against the client CA pool.
+func (t *ServerTLS) config(certs *certStore) (*tls.Config, error) {
+	config := &tls.Config{
+		GetCertificate: certs.getCertificate,
+		ClientAuth:     t.authType(),
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if len(t.clientCA) > 0 {
+		pool := x509.NewCertPool()
+
+		for _, path := range t.clientCA {
+			pem, err := os.ReadFile(path)
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to read client CA file %s: %s", path, err)
+			}
+
+			if ok := pool.AppendCertsFromPEM(pem); !ok {
+				return nil, fmt.Errorf("no certificate found in client CA file %s", path)
+			}
+		}
+
+		config.ClientCAs = pool
+	}
+
+	return config, nil
+}
+
+// clientIdentityHandler removes the client certificate headers a client could send to
+// impersonate another identity, before they can be turned into metadata.
+func clientIdentityHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key := range r.Header {
+			name := strings.TrimPrefix(strings.ToLower(key), "grpc-metadata-")
+
+			if strings.HasPrefix(name, "x-client-cert-") {
+				r.Header.Del(key)
+			}
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientIdentityMetadata forwards the subject, the subject alternative names and the SHA-256
+// fingerprint of a verified client certificate to the backend.
+func clientIdentityMetadata(_ context.Context, r *http.Request) metadata.MD {
+	md := make(metadata.MD)
+
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return md
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	md.Set(ClientCertSubjectMeta, cert.Subject.String())
+	md.Set(ClientCertFingerprintMeta, hex.EncodeToString(fingerprint[:]))
+
+	for _, name := range cert.DNSNames {
+		md.Append(ClientCertSANMeta, "DNS:"+name)
+	}
+
+	for _, email := range cert.EmailAddresses {
+		md.Append(ClientCertSANMeta, "email:"+email)
+	}
+
+	for _, ip := range cert.IPAddresses {
+		md.Append(ClientCertSANMeta, "IP:"+ip.String())
+	}
+
+	for _, uri := range cert.URIs {
+		md.Append(ClientCertSANMeta, "URI:"+uri.String())
+	}
+
+	return md
+}
+
+// certStore serves the listener certificates through tls.Config.GetCertificate, so that they
+// can be replaced from their files while the listener is running.
+type certStore struct {
+	pairs []certPair
+	certs atomic.Pointer[[]*tls.Certificate]
+	stop  func()
+}
+
+func newCertStore(t *ServerTLS) (*certStore, error) {
+	s := &certStore{
+		pairs: append([]certPair{{t.cert, t.key}}, t.certs...),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// load reads every certificate and key pair and replaces the served certificates at once.
+// The certificates in use are kept when one of the pairs cannot be loaded.
+func (s *certStore) load() error {
+	certs := make([]*tls.Certificate, 0, len(s.pairs))
+
+	for _, pair := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(pair.cert, pair.key)
+
+		if err != nil {
+			return fmt.Errorf("failed to load server certificate %s: %s", pair.cert, err)
+		}
+
+		if cert.Leaf == nil {
+			if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return fmt.Errorf("failed to parse server certificate %s: %s", pair.cert, err)
+			}
+		}
+
+		certs = append(certs, &cert)
+	}
+
+	s.certs.Store(&certs)
+
+	return nil
+}
+
+// watch reloads the certificates whenever one of their files changes, until close is called.
+func (s *certStore) watch(log *logrus.Logger) error {
+	paths := make([]string, 0, len(s.pairs)*2)
+
+	for _, pair := range s.pairs {
+		paths = append(paths, pair.cert, pair.key)
+	}
+
+	stop, err := watchFiles(paths, func() {
+		if err := s.load(); err != nil {
+			log.Errorf("Keeping the current server certificates: %v", err)
+			return
+		}
+
+		log.Infof("Server certificates reloaded")
+	}, func(err error) {
+		log.Errorf("Certificate file watcher error: %v", err)
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.stop = stop
+
+	return nil
+}
+
+func (s *certStore) close() {
+	if s.stop != nil {
+		s.stop()
+	}
+}
+
+// getCertificate selects the first certificate supporting the client hello, which covers the
+// SNI server name, and falls back to the default certificate.
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := *s.certs.Load()
+
+	if len(certs) > 1 {
+		for _, cert := range certs {
+			if err := hello.SupportsCertificate(cert); err == nil {
+				return cert, nil
+			}
+		}
+	}
+
+	return certs[0], nil
+}
+
+// status reports the certificates currently served and their expiry.
+func (s *certStore) status() []CertificateStatus {
+	certs := *s.certs.Load()
+	values := make([]CertificateStatus, 0, len(certs))
+
+	for _, cert := range certs {
+		values = append(values, CertificateStatus{
+			Subject:   cert.Leaf.Subject.String(),
+			DNSNames:  cert.Leaf.DNSNames,
+			NotAfter:  cert.Leaf.NotAfter.Format(time.RFC3339),
+			ExpiresIn: time.Until(cert.Leaf.NotAfter).Seconds(),
+		})
+	}
+
+	return values
+}
@@ -0,0 +1,212 @@
+package runtime
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCert(t *testing.T, cn string, dns ...string) *x509.Certificate {
+	cert, _ := newTestKeyPair(t, cn, dns...)
+
+	return cert
+}
+
+func newTestKeyPair(t *testing.T, cn string, dns ...string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri, _ := url.Parse("spiffe://example.org/" + cn)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"example"}},
+		DNSNames:     dns,
+		URIs:         []*url.URL{uri},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, key
+}
+
+// writeTestKeyPair writes a self-signed certificate and its key as PEM files into dir.
+func writeTestKeyPair(t *testing.T, dir, cn string, dns ...string) (string, string) {
+	cert, key := newTestKeyPair(t, cn, dns...)
+	der, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPath, keyPath := filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certPath, keyPath
+}
+
+func TestClientIdentityMetadata(t *testing.T) {
+	cert := newTestCert(t, "partner", "partner.example.org")
+	r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+
+	assert.Empty(t, clientIdentityMetadata(context.Background(), r))
+
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	md := clientIdentityMetadata(context.Background(), r)
+
+	assert.Equal(t, []string{"CN=partner,O=example"}, md.Get(ClientCertSubjectMeta))
+	assert.Equal(t, []string{"DNS:partner.example.org", "URI:spiffe://example.org/partner"}, md.Get(ClientCertSANMeta))
+	assert.Len(t, md.Get(ClientCertFingerprintMeta)[0], 64)
+}
+
+func TestClientIdentityHandler(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	r.Header.Set("Grpc-Metadata-X-Client-Cert-Subject", "CN=spoofed")
+	r.Header.Set("X-Client-Cert-Fingerprint", "spoofed")
+	r.Header.Set("X-Request-Id", "kept")
+
+	clientIdentityHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Empty(t, r.Header.Get("Grpc-Metadata-X-Client-Cert-Subject"))
+		assert.Empty(t, r.Header.Get("X-Client-Cert-Fingerprint"))
+		assert.Equal(t, "kept", r.Header.Get("X-Request-Id"))
+	})).ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func TestParseClientAuth(t *testing.T) {
+	mode, err := ParseClientAuth("verify_if_given")
+
+	assert.NoError(t, err)
+	assert.Equal(t, tls.VerifyClientCertIfGiven, mode)
+
+	_, err = ParseClientAuth("always")
+	assert.Error(t, err)
+
+	assert.Equal(t, tls.RequireAndVerifyClientCert, (&ServerTLS{clientCA: []string{"ca.pem"}}).authType())
+	assert.False(t, (&ServerTLS{}).verifiesClients())
+}
+
+func TestCertStore(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeTestKeyPair(t, dir, "default", "a.example.org")
+	other, otherKey := writeTestKeyPair(t, dir, "other", "b.example.org")
+
+	store, err := newCertStore(&ServerTLS{cert: cert, key: key, certs: []certPair{{other, otherKey}}})
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	config, err := (&ServerTLS{}).config(store)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+
+	served := func(name string) string {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{ServerName: name, InsecureSkipVerify: true})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+
+	assert.Equal(t, "other", served("b.example.org"))
+	assert.Equal(t, "default", served("a.example.org"))
+	assert.Equal(t, "default", served("unknown.example.org"))
+
+	// Replacing the files is picked up by the watcher
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	assert.NoError(t, store.watch(log))
+	defer store.close()
+
+	renewed, renewedKey := writeTestKeyPair(t, t.TempDir(), "renewed", "a.example.org")
+	copyFile(t, renewedKey, key)
+	copyFile(t, renewed, cert)
+
+	assert.Eventually(t, func() bool {
+		return served("a.example.org") == "renewed"
+	}, 2*time.Second, 20*time.Millisecond)
+
+	status := store.status()
+
+	assert.Len(t, status, 2)
+	assert.Equal(t, "CN=renewed,O=example", status[0].Subject)
+	assert.InDelta(t, time.Hour.Seconds(), status[0].ExpiresIn, 60)
+}
+
+func copyFile(t *testing.T, from, to string) {
+	data, err := os.ReadFile(from)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(to, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// watchFiles calls onChange when one of the files at paths is written or replaced, until the
+// returned stop function is called. The parent directories are watched so that files replaced
+// by rename, as editors, certificate managers and Kubernetes volumes do, are picked up as well.
+// Bursts of events are coalesced and onChange runs once they have settled.
+func watchFiles(paths []string, onChange func(), onError func(error)) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{})
+	dirs := make(map[string]struct{})
+
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		names[path] = struct{}{}
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+
+	var mu sync.Mutex
+	var once sync.Once
+
+	stop := make(chan struct{})
+	stopped := false
+
+	// The timer may fire while stop runs, so the callback checks that the watch is still on
+	timer := time.AfterFunc(time.Hour, func() {
+		mu.Lock()
+		active := !stopped
+		mu.Unlock()
+
+		if active {
+			onChange()
+		}
+	})
+	timer.Stop()
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				name := filepath.Clean(event.Name)
+
+				// Kubernetes swaps the "..data" symlink when a mounted volume is updated
+				if _, ok := names[name]; !ok && filepath.Base(name) != "..data" {
+					continue
+				}
+
+				if event.Has(fsnotify.Write) || event.Has(fsnotify.Create) || event.Has(fsnotify.Rename) {
+					mu.Lock()
+
+					if !stopped {
+						timer.Reset(100 * time.Millisecond)
+					}
+
+					mu.Unlock()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				onError(err)
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() {
+			mu.Lock()
+			stopped = true
+			timer.Stop()
+			mu.Unlock()
+
+			close(stop)
+			_ = watcher.Close()
+		})
+	}, nil
+}